@@ -5,6 +5,8 @@ go 1.15
 require (
 	github.com/dustin/go-humanize v1.0.0
 	github.com/klauspost/compress v1.11.12
+	github.com/klauspost/pgzip v1.2.5
+	github.com/pierrec/lz4/v4 v4.1.17
 	github.com/sirupsen/logrus v1.7.0
 	github.com/ulikunitz/xz v0.5.10
 	golang.org/x/sys v0.0.0-20191026070338-33540a1f6037
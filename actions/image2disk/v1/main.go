@@ -1,14 +1,27 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	log "github.com/sirupsen/logrus"
+	storage "github.com/tinkerbell/hub/actions/image2disk/v1/pkg/disk"
 	"github.com/tinkerbell/hub/actions/image2disk/v1/pkg/image"
 )
 
+const (
+	// shareMountpoint is where the share set with IMG_NFS or IMG_SMB is mounted.
+	shareMountpoint = "/mnt/image"
+	// cacheMountpoint is where the partition set with IMG_CACHE_DEVICE is mounted.
+	cacheMountpoint = "/mnt/cache"
+)
+
 func main() {
 	fmt.Printf("IMAGE2DISK - Cloud image streamer\n------------------------\n")
 	disk := os.Getenv("DEST_DISK")
@@ -18,10 +31,317 @@ func main() {
 	// We can ignore the error and default compressed to false.
 	cmp, _ := strconv.ParseBool(compressedEnv)
 
-	// Write the image to disk
-	err := image.Write(img, disk, cmp)
-	if err != nil {
+	opts := image.Options{
+		Retries:      image.DefaultRetries,
+		RetryBackoff: image.DefaultRetryBackoff,
+
+		SHA256: os.Getenv("IMG_SHA256"),
+		SHA512: os.Getenv("IMG_SHA512"),
+
+		SignatureURL: os.Getenv("IMG_SIGNATURE_URL"),
+		PublicKey:    os.Getenv("IMG_PUBLIC_KEY"),
+
+		AuthToken: os.Getenv("IMG_AUTH_TOKEN"),
+		BasicUser: os.Getenv("IMG_BASIC_USER"),
+		BasicPass: os.Getenv("IMG_BASIC_PASS"),
+
+		CACert: os.Getenv("IMG_CA_CERT"),
+
+		BmapURL: os.Getenv("BMAP_URL"),
+
+		ChunkStore: os.Getenv("IMG_CHUNK_STORE"),
+
+		Compression: strings.ToLower(os.Getenv("COMPRESSION")),
+
+		CacheDir: os.Getenv("IMG_CACHE_DIR"),
+
+		MetricsURL: os.Getenv("METRICS_URL"),
+	}
+
+	// We can ignore the error and default to verifying the certificate.
+	opts.InsecureSkipVerify, _ = strconv.ParseBool(os.Getenv("IMG_INSECURE_SKIP_VERIFY"))
+	opts.Verify, _ = strconv.ParseBool(os.Getenv("VERIFY"))
+	opts.Wipe, _ = strconv.ParseBool(os.Getenv("WIPE"))
+	opts.GrowPartition, _ = strconv.ParseBool(os.Getenv("GROW_PARTITION"))
+
+	if concurrency, ok := os.LookupEnv("CONCURRENCY"); ok {
+		n, err := strconv.Atoi(concurrency)
+		if err == nil && n < 1 {
+			err = fmt.Errorf("must be at least 1, got %d", n)
+		}
+		if err != nil {
+			log.Fatalf("Parsing failed for environment variable [%s].  %v", "CONCURRENCY", err)
+		}
+		opts.Concurrency = n
+	}
+
+	if threads, ok := os.LookupEnv("DECOMPRESS_THREADS"); ok {
+		n, err := strconv.Atoi(threads)
+		if err == nil && n < 1 {
+			err = fmt.Errorf("must be at least 1, got %d", n)
+		}
+		if err != nil {
+			log.Fatalf("Parsing failed for environment variable [%s].  %v", "DECOMPRESS_THREADS", err)
+		}
+		opts.DecompressThreads = n
+	}
+
+	if interval, ok := os.LookupEnv("PROGRESS_INTERVAL"); ok {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Parsing failed for environment variable [%s].  %v", "PROGRESS_INTERVAL", err)
+		}
+		opts.ProgressInterval = d
+	}
+
+	if limit, ok := os.LookupEnv("WRITE_LIMIT_MBPS"); ok {
+		mbps, err := strconv.ParseFloat(limit, 64)
+		if err == nil && mbps < 0 {
+			err = fmt.Errorf("must be at least 0, got %g", mbps)
+		}
+		if err != nil {
+			log.Fatalf("Parsing failed for environment variable [%s].  %v", "WRITE_LIMIT_MBPS", err)
+		}
+		opts.WriteLimit = mbps
+	}
+
+	if size, ok := os.LookupEnv("IMG_SIZE"); ok {
+		n, err := humanize.ParseBytes(size)
+		if err != nil {
+			log.Fatalf("Parsing failed for environment variable [%s].  %v", "IMG_SIZE", err)
+		}
+		opts.ImageSize = int64(n)
+	}
+
+	if timeout, ok := os.LookupEnv("PARTITION_TIMEOUT"); ok {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("Parsing failed for environment variable [%s].  %v", "PARTITION_TIMEOUT", err)
+		}
+		opts.PartitionTimeout = d
+	}
+
+	if retries, ok := os.LookupEnv("RETRIES"); ok {
+		n, err := strconv.Atoi(retries)
+		if err == nil && n < 0 {
+			err = fmt.Errorf("must be at least 0, got %d", n)
+		}
+		if err != nil {
+			log.Fatalf("Parsing failed for environment variable [%s].  %v", "RETRIES", err)
+		}
+		opts.Retries = n
+	}
+
+	if backoff, ok := os.LookupEnv("RETRY_BACKOFF"); ok {
+		d, err := time.ParseDuration(backoff)
+		if err != nil {
+			log.Fatalf("Parsing failed for environment variable [%s].  %v", "RETRY_BACKOFF", err)
+		}
+		opts.RetryBackoff = d
+	}
+
+	// The first of the mirrors is the image, the others are tried in order when it fails
+	if urls := os.Getenv("IMG_URLS"); urls != "" {
+		if img != "" {
+			log.Fatal("IMG_URLS can't be used along with IMG_URL")
+		}
+
+		var mirrors []string
+		for _, url := range strings.Split(urls, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				mirrors = append(mirrors, url)
+			}
+		}
+		if len(mirrors) == 0 {
+			log.Fatalf("Parsing failed for environment variable [%s].  no URLs", "IMG_URLS")
+		}
+		img, opts.Mirrors = mirrors[0], mirrors[1:]
+	}
+
+	// Read the image from standard input rather than a URL
+	switch source := os.Getenv("IMG_SOURCE"); source {
+	case "", "url":
+	case "stdin":
+		if img != "" {
+			log.Fatal("IMG_SOURCE=stdin can't be used along with IMG_URL or IMG_URLS")
+		}
+		img = image.Stdin
+	default:
+		log.Fatalf("Parsing failed for environment variable [%s].  unknown source %q", "IMG_SOURCE", source)
+	}
+
+	// log.Fatal exits without running deferred functions, so what is mounted is only unmounted when
+	// the errors are returned to here
+	if err := run(img, disk, cmp, opts); err != nil {
 		log.Fatal(err)
 	}
-	log.Infof("Successfully written [%s] to [%s]", img, disk)
+}
+
+// run mounts the cache and the share of the image, if there are any, and writes the image to the disk.
+func run(img, disk string, cmp bool, opts image.Options) error {
+	// Mount the scratch partition that images are cached on
+	if device := os.Getenv("IMG_CACHE_DEVICE"); device != "" {
+		fstype := os.Getenv("IMG_CACHE_FSTYPE")
+		if fstype == "" {
+			fstype = "ext4"
+		}
+
+		unmount, err := image.MountCache(device, fstype, cacheMountpoint)
+		if err != nil {
+			return err
+		}
+		defer unmount()
+
+		opts.CacheDir = filepath.Join(cacheMountpoint, opts.CacheDir)
+	}
+
+	// Mount the share the image is on, a relative IMG_URL is a path within it
+	if share, ok := imageShare(); ok {
+		unmount, err := image.MountShare(share, shareMountpoint)
+		if err != nil {
+			return err
+		}
+		defer unmount()
+
+		img = sharePath(img)
+	}
+
+	// Pick the disk to write to by its attributes rather than its name
+	if selector := os.Getenv("DEST_DISK_SELECTOR"); selector != "" {
+		if disk != "" {
+			return errors.New("DEST_DISK_SELECTOR can't be used along with DEST_DISK")
+		}
+
+		disks, err := storage.List()
+		if err != nil {
+			return err
+		}
+		selected, err := storage.Select(disks, selector)
+		if err != nil {
+			return err
+		}
+		log.Infof("Selected disk %s with [%s]", selected, selector)
+		disk = selected.Path()
+	}
+
+	// Log into the iSCSI target and write to its LUN
+	if portal := os.Getenv("ISCSI_PORTAL"); portal != "" {
+		if disk != "" || os.Getenv("MANIFEST") != "" {
+			return errors.New("ISCSI_PORTAL can't be used along with DEST_DISK, DEST_DISK_SELECTOR or MANIFEST")
+		}
+
+		target := storage.ISCSITarget{
+			Portal:        portal,
+			IQN:           os.Getenv("ISCSI_TARGET"),
+			InitiatorName: os.Getenv("ISCSI_INITIATOR_NAME"),
+			CHAPUser:      os.Getenv("ISCSI_CHAP_USER"),
+			CHAPPass:      os.Getenv("ISCSI_CHAP_PASS"),
+		}
+		if lun, ok := os.LookupEnv("ISCSI_LUN"); ok {
+			n, err := strconv.Atoi(lun)
+			if err == nil && n < 0 {
+				err = fmt.Errorf("must be at least 0, got %d", n)
+			}
+			if err != nil {
+				return fmt.Errorf("Parsing failed for environment variable [%s].  %w", "ISCSI_LUN", err)
+			}
+			target.LUN = n
+		}
+
+		var timeout time.Duration
+		if t, ok := os.LookupEnv("ISCSI_LOGIN_TIMEOUT"); ok {
+			d, err := time.ParseDuration(t)
+			if err != nil {
+				return fmt.Errorf("Parsing failed for environment variable [%s].  %w", "ISCSI_LOGIN_TIMEOUT", err)
+			}
+			timeout = d
+		}
+
+		device, logout, err := storage.LoginISCSI(target, timeout)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := logout(); err != nil {
+				log.Error(err)
+			}
+		}()
+		log.Infof("Writing to LUN %d of iSCSI target [%s] at [%s]", target.LUN, target.IQN, device)
+		disk = device
+
+		if err := image.Write(img, disk, cmp, opts); err != nil {
+			return err
+		}
+		log.Infof("Successfully written [%s] to [%s]", image.RedactURL(img), disk)
+		return nil
+	}
+
+	// Write each image of the manifest to its disk
+	if manifest := os.Getenv("MANIFEST"); manifest != "" {
+		if img != "" || disk != "" {
+			return errors.New("MANIFEST can't be used along with IMG_URL or DEST_DISK")
+		}
+
+		targets, err := image.ParseManifest(manifest)
+		if err != nil {
+			return err
+		}
+		if _, ok := imageShare(); ok {
+			for i := range targets {
+				targets[i].Image = sharePath(targets[i].Image)
+			}
+		}
+
+		// We can ignore the error and default to writing the images one after the other.
+		parallel, _ := strconv.ParseBool(os.Getenv("MANIFEST_PARALLEL"))
+
+		if err := image.WriteAll(targets, opts, parallel); err != nil {
+			return err
+		}
+		log.Infof("Successfully written the %d images of the manifest", len(targets))
+		return nil
+	}
+
+	// Write the image to disk
+	if err := image.Write(img, disk, cmp, opts); err != nil {
+		return err
+	}
+	log.Infof("Successfully written [%s] to [%s]", image.RedactURL(img), disk)
+
+	return nil
+}
+
+// imageShare returns the share the image is on, if IMG_NFS or IMG_SMB is set.
+func imageShare() (image.Share, bool) {
+	opts := os.Getenv("IMG_MOUNT_OPTIONS")
+
+	if nfs := os.Getenv("IMG_NFS"); nfs != "" {
+		return image.Share{Type: "nfs", Source: nfs, Options: opts}, true
+	}
+
+	if smb := os.Getenv("IMG_SMB"); smb != "" {
+		var creds []string
+		if user := os.Getenv("IMG_SMB_USER"); user != "" {
+			creds = append(creds, "username="+user)
+		}
+		if pass := os.Getenv("IMG_SMB_PASS"); pass != "" {
+			creds = append(creds, "password="+pass)
+		}
+		if opts != "" {
+			creds = append(creds, opts)
+		}
+
+		return image.Share{Type: "cifs", Source: smb, Options: strings.Join(creds, ",")}, true
+	}
+
+	return image.Share{}, false
+}
+
+// sharePath returns the path of a relative image path within the mounted share.
+func sharePath(img string) string {
+	if img == "" || img == image.Stdin || filepath.IsAbs(img) || strings.Contains(img, "://") {
+		return img
+	}
+
+	return filepath.Join(shareMountpoint, img)
 }
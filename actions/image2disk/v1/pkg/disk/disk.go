@@ -0,0 +1,145 @@
+package disk
+
+// This package finds the disk to write an image to from the disks the kernel lists
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sysBlock is where the kernel lists the block devices.
+var sysBlock = "/sys/block"
+
+// Disk is a whole disk of the machine.
+type Disk struct {
+	Name   string
+	Size   uint64
+	Model  string
+	Serial string
+	// WWN is the world wide name of the disk, as it is listed by the kernel without the naa., eui.
+	// or 0x prefix.
+	WWN string
+	// Transport is nvme, virtio, usb or scsi, which covers SATA and SAS disks.
+	Transport  string
+	Rotational bool
+	Removable  bool
+}
+
+// Path returns the device node of the disk.
+func (d Disk) Path() string {
+	return "/dev/" + d.Name
+}
+
+func (d Disk) String() string {
+	return fmt.Sprintf("%s (%s, %d bytes, model %q, serial %q, wwn %q)", d.Path(), d.Transport, d.Size, d.Model, d.Serial, d.WWN)
+}
+
+// List returns the disks of the machine, sorted by name. Devices that aren't backed by hardware,
+// such as loop and device mapper devices, and empty drives are left out.
+func List() ([]Disk, error) {
+	entries, err := ioutil.ReadDir(sysBlock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the disks -> %w", err)
+	}
+
+	var disks []Disk
+	for _, entry := range entries {
+		dir := filepath.Join(sysBlock, entry.Name())
+
+		// Only devices of hardware have a device
+		if _, err := os.Stat(filepath.Join(dir, "device")); err != nil {
+			continue
+		}
+
+		sectors, err := strconv.ParseUint(readAttr(dir, "size"), 10, 64)
+		if err != nil || sectors == 0 {
+			continue
+		}
+
+		d := Disk{
+			Name:       entry.Name(),
+			Size:       sectors * 512,
+			Model:      readAttr(dir, "device/model"),
+			Serial:     readAttr(dir, "device/serial"),
+			WWN:        normaliseWWN(firstAttr(dir, "wwid", "device/wwid")),
+			Transport:  transport(dir, entry.Name()),
+			Rotational: readAttr(dir, "queue/rotational") == "1",
+			Removable:  readAttr(dir, "removable") == "1",
+		}
+		if d.Serial == "" {
+			d.Serial = vpdSerial(dir)
+		}
+
+		disks = append(disks, d)
+	}
+
+	sort.Slice(disks, func(i, j int) bool { return disks[i].Name < disks[j].Name })
+
+	return disks, nil
+}
+
+// transport returns how the disk is attached.
+func transport(dir, name string) string {
+	switch {
+	case strings.HasPrefix(name, "nvme"):
+		return "nvme"
+	case strings.HasPrefix(name, "vd"):
+		return "virtio"
+	}
+
+	if resolved, err := filepath.EvalSymlinks(filepath.Join(dir, "device")); err == nil && strings.Contains(resolved, "/usb") {
+		return "usb"
+	}
+
+	return "scsi"
+}
+
+// vpdSerial returns the serial number of a SCSI disk from its unit serial number VPD page.
+func vpdSerial(dir string) string {
+	page, err := ioutil.ReadFile(filepath.Join(dir, "device", "vpd_pg80"))
+	if err != nil || len(page) < 4 {
+		return ""
+	}
+
+	length := int(page[2])<<8 | int(page[3])
+	if 4+length > len(page) {
+		length = len(page) - 4
+	}
+
+	return strings.TrimSpace(string(page[4 : 4+length]))
+}
+
+// normaliseWWN strips the prefix of the type of a WWN, so that it can be compared however it is
+// written.
+func normaliseWWN(wwn string) string {
+	wwn = strings.ToLower(strings.TrimSpace(wwn))
+	for _, prefix := range []string{"naa.", "eui.", "t10.", "0x"} {
+		wwn = strings.TrimPrefix(wwn, prefix)
+	}
+
+	return wwn
+}
+
+func readAttr(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+func firstAttr(dir string, names ...string) string {
+	for _, name := range names {
+		if v := readAttr(dir, name); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
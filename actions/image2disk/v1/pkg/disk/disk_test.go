@@ -0,0 +1,76 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	defer func(dir string) { sysBlock = dir }(sysBlock)
+	sysBlock = t.TempDir()
+	devices := t.TempDir()
+
+	write := func(path, value string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(value+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	disk := func(name, device string, attrs map[string]string) {
+		t.Helper()
+		dir := filepath.Join(sysBlock, name)
+		if device != "" {
+			if err := os.MkdirAll(filepath.Join(devices, device), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(filepath.Join(devices, device), filepath.Join(dir, "device")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for attr, value := range attrs {
+			write(filepath.Join(dir, attr), value)
+		}
+	}
+
+	disk("nvme0n1", "pci0000:00/nvme/nvme0", map[string]string{
+		"size": "3907029168", "wwid": "eui.0025388b91b2c3d4", "queue/rotational": "0", "removable": "0",
+		"device/model": "Samsung SSD 980 PRO 2TB", "device/serial": "S5GXNF0R123456",
+	})
+	// The serial of a SCSI disk is in its VPD page
+	disk("sda", "pci0000:00/ata1/host0/target0:0:0/0:0:0:0", map[string]string{
+		"size": "1953525168", "queue/rotational": "1", "removable": "0",
+		"device/model": "ST1000NM0055", "device/wwid": "naa.5000c500a1b2c3d4", "device/vpd_pg80": "\x00\x80\x00\x08ZA1B2C3D",
+	})
+	disk("sdb", "pci0000:00/usb1/1-1/1-1:1.0/host6/target6:0:0/6:0:0:0", map[string]string{
+		"size": "61440000", "queue/rotational": "0", "removable": "1", "device/model": "Cruzer",
+	})
+	disk("loop0", "", map[string]string{"size": "1024"})
+	disk("sr0", "pci0000:00/ata2/host1/target1:0:0/1:0:0:0", map[string]string{"size": "0"})
+
+	disks, err := List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	want := []Disk{
+		{Name: "nvme0n1", Size: 3907029168 * 512, Model: "Samsung SSD 980 PRO 2TB", Serial: "S5GXNF0R123456", WWN: "0025388b91b2c3d4", Transport: "nvme"},
+		{Name: "sda", Size: 1953525168 * 512, Model: "ST1000NM0055", Serial: "ZA1B2C3D", WWN: "5000c500a1b2c3d4", Transport: "scsi", Rotational: true},
+		{Name: "sdb", Size: 61440000 * 512, Model: "Cruzer", Transport: "usb", Removable: true},
+	}
+	if len(disks) != len(want) {
+		t.Fatalf("List() = %v, want %v", disks, want)
+	}
+	for i := range want {
+		if disks[i] != want[i] {
+			t.Errorf("List()[%d] = %+v, want %+v", i, disks[i], want[i])
+		}
+	}
+}
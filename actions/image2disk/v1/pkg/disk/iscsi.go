@@ -0,0 +1,201 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultLoginTimeout is how long to wait for the LUN of an iSCSI target to appear once logged in.
+const DefaultLoginTimeout = 30 * time.Second
+
+var (
+	// sysISCSISession is where the kernel lists the iSCSI sessions.
+	sysISCSISession = "/sys/class/iscsi_session"
+	// initiatorNameFile is where iscsid reads the name of the initiator from.
+	initiatorNameFile = "/etc/iscsi/initiatorname.iscsi"
+)
+
+// ISCSITarget is an iSCSI target whose LUN is written to, such as the boot LUN of a diskless node.
+type ISCSITarget struct {
+	// Portal is the address of the target as host or host:port, port 3260 when it isn't set.
+	Portal string
+	// IQN is the qualified name of the target.
+	IQN string
+	// LUN is the logical unit of the target that is written to.
+	LUN int
+	// InitiatorName is the IQN the action logs in as, one is made up when it isn't set.
+	InitiatorName string
+	// CHAPUser and CHAPPass are the CHAP credentials of the initiator, the session isn't
+	// authenticated when CHAPUser isn't set.
+	CHAPUser string
+	CHAPPass string
+}
+
+// portal returns the portal with the default port when it has none.
+func (t ISCSITarget) portal() string {
+	host := t.Portal
+	if strings.HasPrefix(host, "[") {
+		if strings.Contains(host, "]:") {
+			return host
+		}
+		return host + ":3260"
+	}
+	if strings.Count(host, ":") == 1 {
+		return host
+	}
+	if strings.Contains(host, ":") {
+		// A bare IPv6 address
+		return "[" + host + "]:3260"
+	}
+
+	return host + ":3260"
+}
+
+// nodeArgs returns the iscsiadm commands that record the node of the target with its credentials.
+func (t ISCSITarget) nodeArgs() [][]string {
+	node := []string{"-m", "node", "-T", t.IQN, "-p", t.portal()}
+	update := func(name, value string) []string {
+		return append(append([]string{}, node...), "-o", "update", "-n", name, "-v", value)
+	}
+
+	args := [][]string{append(append([]string{}, node...), "-o", "new")}
+	if t.CHAPUser != "" {
+		args = append(args,
+			update("node.session.auth.authmethod", "CHAP"),
+			update("node.session.auth.username", t.CHAPUser),
+			update("node.session.auth.password", t.CHAPPass),
+		)
+	}
+
+	return args
+}
+
+// LoginISCSI logs into the target, waits up to timeout for its LUN to appear and returns the device
+// node of the LUN, along with a function that logs out of the target again.
+func LoginISCSI(t ISCSITarget, timeout time.Duration) (string, func() error, error) {
+	if t.Portal == "" || t.IQN == "" {
+		return "", nil, errors.New("an iSCSI portal and target IQN are required")
+	}
+	if timeout <= 0 {
+		timeout = DefaultLoginTimeout
+	}
+
+	name := t.InitiatorName
+	if name == "" {
+		host, _ := os.Hostname()
+		name = "iqn.2004-10.org.tinkerbell:image2disk:" + host
+	}
+	if err := os.MkdirAll(filepath.Dir(initiatorNameFile), 0o755); err != nil {
+		return "", nil, fmt.Errorf("error creating the iSCSI configuration directory -> %w", err)
+	}
+	if err := ioutil.WriteFile(initiatorNameFile, []byte("InitiatorName="+name+"\n"), 0o644); err != nil {
+		return "", nil, fmt.Errorf("error writing the iSCSI initiator name -> %w", err)
+	}
+
+	// iscsid runs in the foreground so that it can be stopped along with the action
+	iscsid := exec.Command("iscsid", "-f")
+	if err := iscsid.Start(); err != nil {
+		return "", nil, fmt.Errorf("failed to start iscsid -> %w", err)
+	}
+	stop := func() {
+		_ = iscsid.Process.Kill()
+		_ = iscsid.Wait()
+	}
+
+	for _, args := range t.nodeArgs() {
+		if err := runCommand("iscsiadm", args...); err != nil {
+			stop()
+			return "", nil, fmt.Errorf("failed to configure iSCSI target [%s] -> %w", t.IQN, err)
+		}
+	}
+
+	node := []string{"-m", "node", "-T", t.IQN, "-p", t.portal()}
+	if err := runCommand("iscsiadm", append(node, "--login")...); err != nil {
+		stop()
+		return "", nil, fmt.Errorf("failed to log into iSCSI target [%s] at [%s] -> %w", t.IQN, t.portal(), err)
+	}
+	log.Infof("Logged into iSCSI target [%s] at [%s] as [%s]", t.IQN, t.portal(), name)
+
+	logout := func() error {
+		defer stop()
+		if err := runCommand("iscsiadm", append(node, "--logout")...); err != nil {
+			return fmt.Errorf("failed to log out of iSCSI target [%s] -> %w", t.IQN, err)
+		}
+		if err := runCommand("iscsiadm", append(node, "-o", "delete")...); err != nil {
+			return fmt.Errorf("failed to delete iSCSI target [%s] -> %w", t.IQN, err)
+		}
+		log.Infof("Logged out of iSCSI target [%s]", t.IQN)
+		return nil
+	}
+
+	device, err := waitForLUN(t.IQN, t.LUN, timeout)
+	if err != nil {
+		_ = logout()
+		return "", nil, err
+	}
+
+	return device, logout, nil
+}
+
+// runCommand runs an iscsiadm command, its output is the error when it fails. The arguments are
+// left out of the error as they may hold the CHAP password.
+func runCommand(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s -> %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// waitForLUN waits up to timeout for the kernel to list lun of a session with the target iqn, and
+// for its device node to appear.
+func waitForLUN(iqn string, lun int, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if name := lunDevice(iqn, lun); name != "" {
+			if _, err := os.Stat("/dev/" + name); err == nil {
+				return "/dev/" + name, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("LUN %d of iSCSI target [%s] didn't appear after %s", lun, iqn, timeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// lunDevice returns the name of the block device of lun of a session with the target iqn, or an
+// empty string when there is none yet.
+func lunDevice(iqn string, lun int) string {
+	sessions, err := ioutil.ReadDir(sysISCSISession)
+	if err != nil {
+		return ""
+	}
+
+	for _, session := range sessions {
+		dir := filepath.Join(sysISCSISession, session.Name())
+		if readAttr(dir, "targetname") != iqn {
+			continue
+		}
+
+		// The SCSI devices of the session are named host:channel:target:lun
+		blocks, _ := filepath.Glob(filepath.Join(dir, "device", "target*", "*:*:*:"+strconv.Itoa(lun), "block", "*"))
+		if len(blocks) > 0 {
+			return filepath.Base(blocks[0])
+		}
+	}
+
+	return ""
+}
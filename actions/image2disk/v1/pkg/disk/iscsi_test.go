@@ -0,0 +1,75 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestISCSITarget_portal(t *testing.T) {
+	tests := map[string]string{
+		"192.168.1.2":      "192.168.1.2:3260",
+		"192.168.1.2:3261": "192.168.1.2:3261",
+		"san.example.com":  "san.example.com:3260",
+		"fd00::2":          "[fd00::2]:3260",
+		"[fd00::2]":        "[fd00::2]:3260",
+		"[fd00::2]:3261":   "[fd00::2]:3261",
+	}
+	for portal, want := range tests {
+		if got := (ISCSITarget{Portal: portal}).portal(); got != want {
+			t.Errorf("portal(%q) = %q, want %q", portal, got, want)
+		}
+	}
+}
+
+func TestISCSITarget_nodeArgs(t *testing.T) {
+	node := []string{"-m", "node", "-T", "iqn.2001-04.com.example:boot", "-p", "192.168.1.2:3260"}
+	with := func(args ...string) []string { return append(append([]string{}, node...), args...) }
+
+	target := ISCSITarget{Portal: "192.168.1.2", IQN: "iqn.2001-04.com.example:boot"}
+	if got, want := target.nodeArgs(), [][]string{with("-o", "new")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("nodeArgs() = %v, want %v", got, want)
+	}
+
+	target.CHAPUser, target.CHAPPass = "node1", "s3cr3t"
+	want := [][]string{
+		with("-o", "new"),
+		with("-o", "update", "-n", "node.session.auth.authmethod", "-v", "CHAP"),
+		with("-o", "update", "-n", "node.session.auth.username", "-v", "node1"),
+		with("-o", "update", "-n", "node.session.auth.password", "-v", "s3cr3t"),
+	}
+	if got := target.nodeArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("nodeArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestLunDevice(t *testing.T) {
+	defer func(dir string) { sysISCSISession = dir }(sysISCSISession)
+	sysISCSISession = t.TempDir()
+
+	session := func(name, iqn, device string) {
+		t.Helper()
+		dir := filepath.Join(sysISCSISession, name)
+		if err := os.MkdirAll(filepath.Join(dir, "device", device), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "targetname"), []byte(iqn+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session("session1", "iqn.2001-04.com.example:data", "target2:0:0/2:0:0:0/block/sdb")
+	session("session2", "iqn.2001-04.com.example:boot", "target3:0:0/3:0:0:1/block/sdd")
+	session("session3", "iqn.2001-04.com.example:boot", "target4:0:0/4:0:0:0/block/sdc")
+
+	if got := lunDevice("iqn.2001-04.com.example:boot", 0); got != "sdc" {
+		t.Errorf("lunDevice(boot, 0) = %q, want sdc", got)
+	}
+	if got := lunDevice("iqn.2001-04.com.example:boot", 1); got != "sdd" {
+		t.Errorf("lunDevice(boot, 1) = %q, want sdd", got)
+	}
+	if got := lunDevice("iqn.2001-04.com.example:missing", 0); got != "" {
+		t.Errorf("lunDevice(missing, 0) = %q, want none", got)
+	}
+}
@@ -0,0 +1,169 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Select returns the disk that selector picks out of disks. The selector is a space separated list
+// of rules that a disk has to match all of:
+//
+//   - nvme, virtio, scsi, sata or usb match how the disk is attached, ssd or hdd whether it rotates
+//   - removable matches removable disks, which are otherwise left out along with USB disks
+//   - serial=, wwn=, model= and name= match those of the disk, model= and name= may be globs
+//   - size>=, size<=, size> and size< compare the size of the disk with a size such as 500GB or 2TiB
+//   - smallest or largest pick the smallest or largest of the disks that match
+//
+// It fails unless exactly one disk is picked, so that the image is never written to a guess.
+func Select(disks []Disk, selector string) (Disk, error) {
+	terms := strings.Fields(selector)
+	if len(terms) == 0 {
+		return Disk{}, errors.New("empty disk selector")
+	}
+
+	var (
+		rules []func(Disk) bool
+		pick  string
+		usb   bool
+		rem   bool
+	)
+
+	for _, term := range terms {
+		switch t := strings.ToLower(term); t {
+		case "smallest", "largest":
+			if pick != "" {
+				return Disk{}, fmt.Errorf("only one of smallest or largest can be used in disk selector [%s]", selector)
+			}
+			pick = t
+		case "nvme", "virtio", "scsi", "usb":
+			usb = usb || t == "usb"
+			rules = append(rules, func(d Disk) bool { return d.Transport == t })
+		case "sata", "sas":
+			rules = append(rules, func(d Disk) bool { return d.Transport == "scsi" })
+		case "ssd":
+			rules = append(rules, func(d Disk) bool { return !d.Rotational })
+		case "hdd":
+			rules = append(rules, func(d Disk) bool { return d.Rotational })
+		case "removable":
+			rem = true
+			rules = append(rules, func(d Disk) bool { return d.Removable })
+		default:
+			rule, err := parseRule(term)
+			if err != nil {
+				return Disk{}, fmt.Errorf("invalid disk selector [%s] -> %w", selector, err)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	var matches []Disk
+	for _, d := range disks {
+		// A USB stick or a card reader is never picked unless it is asked for
+		if (d.Transport == "usb" && !usb) || (d.Removable && !rem && !usb) {
+			continue
+		}
+
+		matched := true
+		for _, rule := range rules {
+			matched = matched && rule(d)
+		}
+		if matched {
+			matches = append(matches, d)
+		}
+	}
+
+	if len(matches) == 0 {
+		return Disk{}, fmt.Errorf("no disk matches selector [%s], the disks are:%s", selector, list(disks))
+	}
+
+	if pick != "" {
+		best := []Disk{matches[0]}
+		for _, d := range matches[1:] {
+			switch {
+			case d.Size == best[0].Size:
+				best = append(best, d)
+			case (pick == "smallest") == (d.Size < best[0].Size):
+				best = []Disk{d}
+			}
+		}
+		matches = best
+	}
+
+	if len(matches) > 1 {
+		return Disk{}, fmt.Errorf("%d disks match selector [%s]:%s", len(matches), selector, list(matches))
+	}
+
+	return matches[0], nil
+}
+
+// parseRule parses a key=value rule, or a comparison of the size.
+func parseRule(term string) (func(Disk) bool, error) {
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if !strings.HasPrefix(strings.ToLower(term), "size"+op) {
+			continue
+		}
+
+		size, err := humanize.ParseBytes(term[len("size"+op):])
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case ">=":
+			return func(d Disk) bool { return d.Size >= size }, nil
+		case "<=":
+			return func(d Disk) bool { return d.Size <= size }, nil
+		case ">":
+			return func(d Disk) bool { return d.Size > size }, nil
+		default:
+			return func(d Disk) bool { return d.Size < size }, nil
+		}
+	}
+
+	i := strings.Index(term, "=")
+	if i <= 0 {
+		return nil, fmt.Errorf("unknown rule [%s]", term)
+	}
+	key, value := strings.ToLower(term[:i]), term[i+1:]
+
+	switch key {
+	case "serial":
+		return func(d Disk) bool { return d.Serial == value }, nil
+	case "wwn":
+		wwn := normaliseWWN(value)
+		return func(d Disk) bool { return d.WWN != "" && d.WWN == wwn }, nil
+	case "model":
+		return globRule(value, func(d Disk) string { return d.Model })
+	case "name":
+		return globRule(strings.TrimPrefix(value, "/dev/"), func(d Disk) string { return d.Name })
+	}
+
+	return nil, fmt.Errorf("unknown rule [%s]", term)
+}
+
+// globRule matches an attribute of the disk against the glob pattern, ignoring case.
+func globRule(pattern string, attr func(Disk) string) (func(Disk) bool, error) {
+	pattern = strings.ToLower(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	return func(d Disk) bool {
+		ok, _ := path.Match(pattern, strings.ToLower(attr(d)))
+		return ok
+	}, nil
+}
+
+func list(disks []Disk) string {
+	var b strings.Builder
+	for _, d := range disks {
+		b.WriteString("\n  ")
+		b.WriteString(d.String())
+	}
+
+	return b.String()
+}
@@ -0,0 +1,49 @@
+package disk
+
+import "testing"
+
+func TestSelect(t *testing.T) {
+	disks := []Disk{
+		{Name: "nvme0n1", Size: 2000e9, Model: "Samsung SSD 980 PRO 2TB", Serial: "S5GXNF0R123456", WWN: "0025388b91b2c3d4", Transport: "nvme"},
+		{Name: "nvme1n1", Size: 240e9, Model: "Micron_7300_MTFDHBG240TDF", Serial: "MC1234", Transport: "nvme"},
+		{Name: "sda", Size: 1000e9, Model: "ST1000NM0055", Serial: "ZA1B2C3D", WWN: "5000c500a1b2c3d4", Transport: "scsi", Rotational: true},
+		{Name: "sdb", Size: 1000e9, Model: "ST1000NM0055", Serial: "ZA1B2C3E", WWN: "5000c500a1b2c3d5", Transport: "scsi", Rotational: true},
+		{Name: "sdc", Size: 32e9, Model: "Cruzer", Transport: "usb", Removable: true},
+	}
+
+	tests := []struct {
+		selector string
+		want     string
+		wantErr  bool
+	}{
+		{"smallest nvme", "nvme1n1", false},
+		{"largest", "nvme0n1", false},
+		{"smallest", "nvme1n1", false},
+		{"serial=ZA1B2C3E", "sdb", false},
+		{"wwn=0x5000C500A1B2C3D4", "sda", false},
+		{"wwn=naa.5000c500a1b2c3d4", "sda", false},
+		{"model=samsung*", "nvme0n1", false},
+		{"ssd size>=1TB", "nvme0n1", false},
+		{"nvme size<500GB", "nvme1n1", false},
+		{"usb", "sdc", false},
+		{"name=/dev/sda", "sda", false},
+		{"hdd", "", true},
+		{"smallest hdd", "", true},
+		{"serial=MISSING", "", true},
+		{"size<100GB", "", true},
+		{"fastest", "", true},
+		{"smallest largest", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			got, err := Select(disks, tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Select() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Name != tt.want {
+				t.Errorf("Select() = %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
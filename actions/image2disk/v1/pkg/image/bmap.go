@@ -0,0 +1,169 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // bmap files before version 2.0 checksum their ranges with sha1
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// bmap is a block map of an image, as made by bmaptool create. Only the ranges of blocks it maps
+// have data, the rest of the image is unused and isn't written to the disk.
+type bmap struct {
+	imageSize int64
+	blockSize int64
+	newHash   func() hash.Hash
+	ranges    []bmapRange
+}
+
+// bmapRange is a range of mapped blocks, from first to last inclusive.
+type bmapRange struct {
+	first, last int64
+	checksum    []byte
+}
+
+// extent is a region of the disk that was written to.
+type extent struct {
+	offset, length int64
+}
+
+// bmapFile is the XML format of a bmap file, versions 1 and 2 are supported.
+type bmapFile struct {
+	Version      string `xml:"version,attr"`
+	ImageSize    string `xml:"ImageSize"`
+	BlockSize    string `xml:"BlockSize"`
+	ChecksumType string `xml:"ChecksumType"`
+	Ranges       []struct {
+		Blocks string `xml:",chardata"`
+		Chksum string `xml:"chksum,attr"`
+		SHA1   string `xml:"sha1,attr"`
+	} `xml:"BlockMap>Range"`
+}
+
+// parseBmap parses the bmap file in data.
+func parseBmap(data []byte) (*bmap, error) {
+	var f bmapFile
+	if err := xml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse bmap -> %w", err)
+	}
+
+	m := &bmap{}
+
+	var err error
+	if m.imageSize, err = strconv.ParseInt(strings.TrimSpace(f.ImageSize), 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid bmap ImageSize -> %w", err)
+	}
+	if m.blockSize, err = strconv.ParseInt(strings.TrimSpace(f.BlockSize), 10, 64); err != nil || m.blockSize <= 0 {
+		return nil, fmt.Errorf("invalid bmap BlockSize [%s]", strings.TrimSpace(f.BlockSize))
+	}
+
+	switch checksumType := strings.TrimSpace(f.ChecksumType); {
+	case checksumType == "sha256":
+		m.newHash = sha256.New
+	case checksumType == "sha1", checksumType == "" && strings.HasPrefix(f.Version, "1."):
+		m.newHash = sha1.New
+	default:
+		return nil, fmt.Errorf("unsupported bmap ChecksumType [%s]", checksumType)
+	}
+
+	var next int64
+	for _, rng := range f.Ranges {
+		var r bmapRange
+
+		blocks := strings.TrimSpace(rng.Blocks)
+		first, last := blocks, blocks
+		if i := strings.Index(blocks, "-"); i >= 0 {
+			first, last = blocks[:i], blocks[i+1:]
+		}
+		if r.first, err = strconv.ParseInt(first, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid bmap range [%s]", blocks)
+		}
+		if r.last, err = strconv.ParseInt(last, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid bmap range [%s]", blocks)
+		}
+		if r.first < next || r.last < r.first || r.first*m.blockSize >= m.imageSize {
+			return nil, fmt.Errorf("bmap range [%s] is out of order or outside the image", blocks)
+		}
+		next = r.last + 1
+
+		chksum := rng.Chksum
+		if chksum == "" {
+			chksum = rng.SHA1
+		}
+		if chksum != "" {
+			if r.checksum, err = hex.DecodeString(chksum); err != nil {
+				return nil, fmt.Errorf("invalid checksum of bmap range [%s] -> %w", blocks, err)
+			}
+		}
+
+		m.ranges = append(m.ranges, r)
+	}
+
+	if len(m.ranges) == 0 {
+		return nil, errors.New("bmap has no mapped ranges")
+	}
+
+	return m, nil
+}
+
+// write copies the mapped ranges of the image read from r to the same offsets of disk, through w
+// which writes to disk, skipping over the rest. The checksum of each range is verified as it is
+// written. It returns the extents of the disk that were written.
+func (m *bmap) write(disk io.Seeker, w io.Writer, r io.Reader) ([]extent, error) {
+	var (
+		pos     int64
+		extents []extent
+	)
+
+	for _, rng := range m.ranges {
+		start := rng.first * m.blockSize
+		end := (rng.last + 1) * m.blockSize
+		if end > m.imageSize {
+			end = m.imageSize
+		}
+
+		if _, err := io.CopyN(ioutil.Discard, r, start-pos); err != nil {
+			return extents, fmt.Errorf("error reading the image up to block %d -> %w", rng.first, err)
+		}
+
+		if _, err := disk.Seek(start, io.SeekStart); err != nil {
+			return extents, err
+		}
+
+		h := m.newHash()
+		if _, err := io.CopyN(io.MultiWriter(w, h), r, end-start); err != nil {
+			return extents, fmt.Errorf("error writing blocks %d-%d of the image -> %w", rng.first, rng.last, err)
+		}
+		pos = end
+
+		extents = append(extents, extent{offset: start, length: end - start})
+
+		if rng.checksum != nil && !bytes.Equal(h.Sum(nil), rng.checksum) {
+			return extents, fmt.Errorf("checksum mismatch for blocks %d-%d of the image", rng.first, rng.last)
+		}
+	}
+
+	return extents, nil
+}
+
+// mappedSize returns the number of bytes of the image that the bmap maps.
+func (m *bmap) mappedSize() int64 {
+	var size int64
+	for _, rng := range m.ranges {
+		end := (rng.last + 1) * m.blockSize
+		if end > m.imageSize {
+			end = m.imageSize
+		}
+		size += end - rng.first*m.blockSize
+	}
+
+	return size
+}
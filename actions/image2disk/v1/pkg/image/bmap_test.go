@@ -0,0 +1,175 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func Test_parseBmap(t *testing.T) {
+	tests := []struct {
+		name       string
+		bmap       string
+		wantRanges int
+		wantMapped int64
+		wantErr    bool
+	}{
+		{
+			name: "version 2",
+			bmap: `<?xml version="1.0" ?>
+<bmap version="2.0">
+    <ImageSize> 10000 </ImageSize>
+    <BlockSize> 4096 </BlockSize>
+    <BlocksCnt> 3 </BlocksCnt>
+    <MappedBlocksCnt> 2 </MappedBlocksCnt>
+    <ChecksumType> sha256 </ChecksumType>
+    <BlockMap>
+        <Range chksum="9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"> 0 </Range>
+        <Range chksum="9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"> 2 </Range>
+    </BlockMap>
+</bmap>`,
+			wantRanges: 2,
+			wantMapped: 4096 + 10000 - 8192,
+		},
+		{
+			name: "version 1 with sha1",
+			bmap: `<bmap version="1.4">
+    <ImageSize> 8192 </ImageSize>
+    <BlockSize> 4096 </BlockSize>
+    <BlockMap>
+        <Range sha1="a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"> 0-1 </Range>
+    </BlockMap>
+</bmap>`,
+			wantRanges: 1,
+			wantMapped: 8192,
+		},
+		{
+			name: "overlapping ranges",
+			bmap: `<bmap version="2.0">
+    <ImageSize> 16384 </ImageSize>
+    <BlockSize> 4096 </BlockSize>
+    <ChecksumType> sha256 </ChecksumType>
+    <BlockMap>
+        <Range> 0-2 </Range>
+        <Range> 2-3 </Range>
+    </BlockMap>
+</bmap>`,
+			wantErr: true,
+		},
+		{
+			name: "range outside the image",
+			bmap: `<bmap version="2.0">
+    <ImageSize> 4096 </ImageSize>
+    <BlockSize> 4096 </BlockSize>
+    <ChecksumType> sha256 </ChecksumType>
+    <BlockMap>
+        <Range> 1 </Range>
+    </BlockMap>
+</bmap>`,
+			wantErr: true,
+		},
+		{
+			name:    "not xml",
+			bmap:    "0-1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parseBmap([]byte(tt.bmap))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBmap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(m.ranges) != tt.wantRanges {
+				t.Errorf("parseBmap() returned %d ranges, want %d", len(m.ranges), tt.wantRanges)
+			}
+			if got := m.mappedSize(); got != tt.wantMapped {
+				t.Errorf("mappedSize() = %d, want %d", got, tt.wantMapped)
+			}
+		})
+	}
+}
+
+func TestWriteBmap(t *testing.T) {
+	const blockSize = 4096
+
+	// Blocks 0, 1 and 3 of the image have data, block 2 and the partial block 4 are unmapped
+	img := make([]byte, 4*blockSize+100)
+	for _, block := range []int{0, 1, 3} {
+		for i := 0; i < blockSize; i++ {
+			img[block*blockSize+i] = byte(block + 1)
+		}
+	}
+
+	sum := func(data []byte) string {
+		h := sha256.Sum256(data)
+		return hex.EncodeToString(h[:])
+	}
+	bmapFor := func(lastChksum string) string {
+		return fmt.Sprintf(`<bmap version="2.0">
+    <ImageSize> %d </ImageSize>
+    <BlockSize> %d </BlockSize>
+    <ChecksumType> sha256 </ChecksumType>
+    <BlockMap>
+        <Range chksum="%s"> 0-1 </Range>
+        <Range chksum="%s"> 3 </Range>
+    </BlockMap>
+</bmap>`, len(img), blockSize, sum(img[:2*blockSize]), lastChksum)
+	}
+
+	tests := []struct {
+		name    string
+		bmap    string
+		wantErr bool
+	}{
+		{"mapped blocks written", bmapFor(sum(img[3*blockSize : 4*blockSize])), false},
+		{"checksum mismatch", bmapFor(sum([]byte("YourDataHere"))), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if filepath.Ext(r.URL.Path) == ".bmap" {
+					w.Write([]byte(tt.bmap))
+					return
+				}
+				w.Write(img)
+			}))
+			defer server.Close()
+
+			// The old contents of the disk are left where the bmap doesn't map any blocks
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(disk, bytes.Repeat([]byte{0xff}, len(img)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Write(server.URL+"/image.raw", disk, false, Options{BmapURL: server.URL + "/image.bmap", Verify: true})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			written, err := ioutil.ReadFile(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := append([]byte{}, img...)
+			copy(want[2*blockSize:3*blockSize], bytes.Repeat([]byte{0xff}, blockSize))
+			copy(want[4*blockSize:], bytes.Repeat([]byte{0xff}, 100))
+			if !bytes.Equal(written, want) {
+				t.Errorf("Write() didn't write only the mapped blocks of the image")
+			}
+		})
+	}
+}
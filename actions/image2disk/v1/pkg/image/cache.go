@@ -0,0 +1,109 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// cachePath returns where the image at sourceImage is cached in dir. It is keyed by the URL along
+// with the digests the image is expected to have, so that a changed digest doesn't use a stale copy.
+// The extension of the image is kept, as its decompressor is picked by it.
+func cachePath(dir, sourceImage, sha256sum, sha512sum string) string {
+	key := sha256.Sum256([]byte(sourceImage + "\n" + strings.ToLower(sha256sum) + "\n" + strings.ToLower(sha512sum)))
+
+	ext := filepath.Ext(sourceImage)
+	if u, err := url.Parse(sourceImage); err == nil {
+		ext = path.Ext(u.Path)
+	}
+
+	return filepath.Join(dir, hex.EncodeToString(key[:])+ext)
+}
+
+// cacheEntry stores an image in the cache as it is downloaded. It is written to a temporary file
+// that only takes the place of the cached image once the whole image is downloaded and verified.
+type cacheEntry struct {
+	path string
+	file *os.File
+	err  error
+}
+
+func newCacheEntry(path string) (*cacheEntry, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating the image cache [%s] -> %w", filepath.Dir(path), err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".download-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating the cached image -> %w", err)
+	}
+
+	return &cacheEntry{path: path, file: f}, nil
+}
+
+// Write stores p in the cache. A cache that fails to be written, such as one that is full, is given
+// up on without failing the write of the image.
+func (e *cacheEntry) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return len(p), nil
+	}
+
+	if _, err := e.file.Write(p); err != nil {
+		e.err = err
+		log.Warnf("Failed to cache the image, it won't be cached -> %v", err)
+	}
+
+	return len(p), nil
+}
+
+// commit puts the downloaded image in place in the cache.
+func (e *cacheEntry) commit() error {
+	if e.err != nil {
+		e.discard()
+		return nil
+	}
+
+	if err := e.file.Close(); err != nil {
+		e.discard()
+		return fmt.Errorf("error closing the cached image -> %w", err)
+	}
+
+	if err := os.Rename(e.file.Name(), e.path); err != nil {
+		e.discard()
+		return fmt.Errorf("error storing the cached image [%s] -> %w", e.path, err)
+	}
+
+	return nil
+}
+
+// discard removes what was stored of the image, it does nothing once the entry is committed.
+func (e *cacheEntry) discard() {
+	e.file.Close()
+	os.Remove(e.file.Name())
+}
+
+// MountCache mounts the filesystem on device, of type fstype, at target to cache images in and
+// returns a function that unmounts it.
+func MountCache(device, fstype, target string) (func() error, error) {
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating the cache mountpoint [%s] -> %w", target, err)
+	}
+
+	if err := unix.Mount(device, target, fstype, 0, ""); err != nil {
+		return nil, fmt.Errorf("mounting [%s] -> [%s] error -> %w", device, target, err)
+	}
+	log.Infof("Mounted [%s] -> [%s]", device, target)
+
+	return func() error {
+		return unix.Unmount(target, 0)
+	}, nil
+}
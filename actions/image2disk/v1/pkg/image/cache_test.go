@@ -0,0 +1,75 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_cachePath(t *testing.T) {
+	a := cachePath("/mnt/cache", "http://192.168.1.2/ubuntu.raw.gz?token=1", "", "")
+	if filepath.Dir(a) != "/mnt/cache" || !strings.HasSuffix(a, ".gz") {
+		t.Errorf("cachePath() = %q, want a .gz file in /mnt/cache", a)
+	}
+	if b := cachePath("/mnt/cache", "http://192.168.1.2/ubuntu.raw.gz?token=1", "", ""); a != b {
+		t.Errorf("cachePath() = %q and %q for the same image", a, b)
+	}
+	if b := cachePath("/mnt/cache", "http://192.168.1.2/ubuntu.raw.gz?token=1", strings.Repeat("a", 64), ""); a == b {
+		t.Errorf("cachePath() = %q for images with different digests", a)
+	}
+}
+
+func TestWriteCache(t *testing.T) {
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write([]byte("YourDataHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	opts := Options{CacheDir: t.TempDir()}
+	for i := 0; i < 2; i++ {
+		disk := filepath.Join(t.TempDir(), "disk")
+		if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Write(server.URL+"/image.raw.gz", disk, true, opts); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+
+		written, err := ioutil.ReadFile(disk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(written) != "YourDataHere" {
+			t.Errorf("Write() wrote %q, want %q", written, "YourDataHere")
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("image was downloaded %d times, want once", requests)
+	}
+
+	entries, err := ioutil.ReadDir(opts.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("cache has %d files, want only the image", len(entries))
+	}
+}
@@ -0,0 +1,52 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dustin/go-humanize"
+)
+
+// uncompressedSize returns the size of the image once it is decompressed, or 0 when it isn't known.
+// It is the size that is set for the write, that of the bmap of the image, or the size of the
+// download for an image that isn't compressed.
+func uncompressedSize(opts Options, bm *bmap, compressed bool, downloadSize int64) int64 {
+	switch {
+	case opts.ImageSize > 0:
+		return opts.ImageSize
+	case bm != nil:
+		return bm.imageSize
+	case !compressed && downloadSize > 0:
+		return downloadSize
+	}
+
+	return 0
+}
+
+// checkCapacity fails when the image of size bytes doesn't fit on disk, so that a write that can't
+// succeed fails before the disk is touched rather than once it is full. A disk that is a regular
+// file, such as a disk image, grows to fit and isn't checked.
+func checkCapacity(disk *os.File, size int64) error {
+	info, err := disk.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat disk [%s] -> %w", disk.Name(), err)
+	}
+	if info.Mode()&os.ModeDevice == 0 {
+		return nil
+	}
+
+	capacity, err := disk.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to find the size of disk [%s] -> %w", disk.Name(), err)
+	}
+	if _, err := disk.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to find the size of disk [%s] -> %w", disk.Name(), err)
+	}
+
+	if size > capacity {
+		return fmt.Errorf("image of %s doesn't fit on disk [%s] of %s", humanize.IBytes(uint64(size)), disk.Name(), humanize.IBytes(uint64(capacity)))
+	}
+
+	return nil
+}
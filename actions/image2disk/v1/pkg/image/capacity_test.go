@@ -0,0 +1,60 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_uncompressedSize(t *testing.T) {
+	bm := &bmap{imageSize: 4 << 30}
+
+	tests := []struct {
+		name         string
+		opts         Options
+		bm           *bmap
+		compressed   bool
+		downloadSize int64
+		want         int64
+	}{
+		{"set", Options{ImageSize: 8 << 30}, bm, true, 1 << 30, 8 << 30},
+		{"bmap", Options{}, bm, true, 1 << 30, 4 << 30},
+		{"uncompressed download", Options{}, nil, false, 2 << 30, 2 << 30},
+		{"compressed download", Options{}, nil, true, 1 << 30, 0},
+		{"unknown", Options{}, nil, false, -1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uncompressedSize(tt.opts, tt.bm, tt.compressed, tt.downloadSize); got != tt.want {
+				t.Errorf("uncompressedSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkCapacity(t *testing.T) {
+	// A disk image grows to fit the image
+	path := filepath.Join(t.TempDir(), "disk")
+	if err := ioutil.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := checkCapacity(f, 1<<30); err != nil {
+		t.Errorf("checkCapacity() of a file error = %v", err)
+	}
+
+	// The size of /dev/zero is 0, so nothing fits on it
+	dev, err := os.Open("/dev/zero")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer dev.Close()
+	if err := checkCapacity(dev, 1); err == nil {
+		t.Error("checkCapacity() of a device too small for the image succeeded")
+	}
+}
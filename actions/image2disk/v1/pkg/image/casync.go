@@ -0,0 +1,211 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+)
+
+// The headers of a casync chunk index, as in ca-format.h of casync.
+const (
+	caFormatIndex         = 0x96824d9c7b129ff9
+	caFormatTable         = 0xe75b9e112f17417d
+	caFormatTableTailMark = 0x4b4f050e5549ecd1
+	caFormatSHA512256     = 0x2000000000000000
+	// The index header is followed by the 16 byte header of the table, then its 40 byte items.
+	caFormatIndexSize     = 48
+	caFormatTableItems    = caFormatIndexSize + 16
+	caFormatTableItemSize = 40
+)
+
+// chunkIndex is a casync or desync blob index (.caibx), which splits an image into content addressed
+// chunks that are stored in a chunk store.
+type chunkIndex struct {
+	newHash func() hash.Hash
+	chunks  []indexChunk
+}
+
+// indexChunk is a chunk of the image, from offset for length bytes.
+type indexChunk struct {
+	id             [32]byte
+	offset, length int64
+}
+
+// size returns the size of the image that the index is of.
+func (x *chunkIndex) size() int64 {
+	if len(x.chunks) == 0 {
+		return 0
+	}
+	last := x.chunks[len(x.chunks)-1]
+
+	return last.offset + last.length
+}
+
+// isChunkIndex reports whether sourceImage is a chunk index rather than an image.
+func isChunkIndex(sourceImage string) bool {
+	if u, err := url.Parse(sourceImage); err == nil {
+		return path.Ext(u.Path) == ".caibx"
+	}
+
+	return filepath.Ext(sourceImage) == ".caibx"
+}
+
+// parseChunkIndex parses the .caibx index in data.
+func parseChunkIndex(data []byte) (*chunkIndex, error) {
+	if len(data) < caFormatTableItems {
+		return nil, errors.New("chunk index is too short")
+	}
+
+	le := binary.LittleEndian
+	if le.Uint64(data[0:8]) != caFormatIndexSize || le.Uint64(data[8:16]) != caFormatIndex {
+		return nil, errors.New("not a casync chunk index")
+	}
+
+	x := &chunkIndex{newHash: sha256.New}
+	if le.Uint64(data[16:24])&caFormatSHA512256 != 0 {
+		x.newHash = sha512.New512_256
+	}
+
+	if le.Uint64(data[56:64]) != caFormatTable {
+		return nil, errors.New("chunk index has no chunk table")
+	}
+
+	var offset int64
+	for item := data[caFormatTableItems:]; ; item = item[caFormatTableItemSize:] {
+		if len(item) < caFormatTableItemSize {
+			return nil, errors.New("chunk index is truncated")
+		}
+
+		end := int64(le.Uint64(item[0:8]))
+		if end == 0 {
+			// The tail of the table starts with zeroes, and ends with its marker
+			if le.Uint64(item[32:40]) != caFormatTableTailMark {
+				return nil, errors.New("chunk index has an invalid table tail")
+			}
+			break
+		}
+		if end <= offset {
+			return nil, fmt.Errorf("chunk %d of the index ends at %d, before it starts", len(x.chunks), end)
+		}
+
+		c := indexChunk{offset: offset, length: end - offset}
+		copy(c.id[:], item[8:40])
+		x.chunks = append(x.chunks, c)
+		offset = end
+	}
+
+	return x, nil
+}
+
+// chunkLocation returns where the chunk with id is in the chunk store, which is laid out as casync
+// and desync lay it out.
+func chunkLocation(store string, id [32]byte) string {
+	name := hex.EncodeToString(id[:])
+
+	return strings.TrimSuffix(store, "/") + "/" + name[:4] + "/" + name + ".cacnk"
+}
+
+// defaultChunkStore returns the store that casync puts next to the index, default.castr.
+func defaultChunkStore(index string) string {
+	return index[:strings.LastIndex(index, "/")+1] + "default.castr"
+}
+
+// writeChunkIndex writes the image of the chunk index at sourceImage to the disk. The disk is the
+// seed: a chunk that the disk already has at its offset, as it does for the blocks that are unchanged
+// since the image written before, is left as it is, and only the others are downloaded.
+func writeChunkIndex(c *client, sourceImage, destinationDevice string, opts Options) error {
+	if opts.Wipe {
+		return errors.New("the disk can't be wiped when it is the seed of a chunk index")
+	}
+
+	store := opts.ChunkStore
+	if store == "" {
+		store = defaultChunkStore(sourceImage)
+	}
+
+	data, err := readLocation(c, sourceImage)
+	if err != nil {
+		return fmt.Errorf("failed to fetch chunk index -> %w", err)
+	}
+	index, err := parseChunkIndex(data)
+	if err != nil {
+		return err
+	}
+
+	disk, err := os.OpenFile(destinationDevice, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+
+	if err := checkCapacity(disk, index.size()); err != nil {
+		return err
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return fmt.Errorf("[ERROR] New zstd reader: %w", err)
+	}
+	defer dec.Close()
+
+	log.Infof("Beginning write of the %d chunks of index [%s] to disk [%s]", len(index.chunks), imageName(sourceImage), destinationDevice)
+	start := time.Now()
+
+	var reused, fetched, fetchedBytes int64
+	for _, chunk := range index.chunks {
+		seed := make([]byte, chunk.length)
+		if n, _ := disk.ReadAt(seed, chunk.offset); n == len(seed) && bytes.Equal(sum(index.newHash, seed), chunk.id[:]) {
+			reused++
+			continue
+		}
+
+		compressed, err := readLocation(c, chunkLocation(store, chunk.id))
+		if err != nil {
+			return fmt.Errorf("failed to fetch chunk %x -> %w", chunk.id, err)
+		}
+		plain, err := dec.DecodeAll(compressed, seed[:0])
+		if err != nil {
+			return fmt.Errorf("failed to decompress chunk %x -> %w", chunk.id, err)
+		}
+		if int64(len(plain)) != chunk.length || !bytes.Equal(sum(index.newHash, plain), chunk.id[:]) {
+			return fmt.Errorf("chunk %x from the store doesn't match its id", chunk.id)
+		}
+
+		if _, err := disk.WriteAt(plain, chunk.offset); err != nil {
+			return fmt.Errorf("error writing chunk %x to disk [%s] -> %w", chunk.id, destinationDevice, err)
+		}
+		fetched++
+		fetchedBytes += int64(len(compressed))
+	}
+
+	log.Infof("Written %s to disk [%s] in %s, %d chunks were already on the disk and %d were downloaded (%s)",
+		humanize.Bytes(uint64(index.size())), destinationDevice, time.Since(start).Round(time.Second), reused, fetched, humanize.Bytes(uint64(fetchedBytes)))
+
+	if err := disk.Sync(); err != nil {
+		return fmt.Errorf("failed to sync the block device")
+	}
+
+	return finishDisk(disk, destinationDevice, opts)
+}
+
+func sum(newHash func() hash.Hash, data []byte) []byte {
+	h := newHash()
+	h.Write(data)
+
+	return h.Sum(nil)
+}
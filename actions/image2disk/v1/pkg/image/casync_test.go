@@ -0,0 +1,127 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+)
+
+// makeChunkIndex returns a .caibx index of chunks, and stores them as a chunk store in dir.
+func makeChunkIndex(t *testing.T, dir string, chunks [][]byte) []byte {
+	t.Helper()
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	le := binary.LittleEndian
+	var index bytes.Buffer
+	for _, v := range []uint64{caFormatIndexSize, caFormatIndex, caFormatSHA512256, 1, 2, 3, ^uint64(0), caFormatTable} {
+		_ = binary.Write(&index, le, v)
+	}
+
+	var end uint64
+	for _, chunk := range chunks {
+		id := sha512.Sum512_256(chunk)
+		end += uint64(len(chunk))
+		_ = binary.Write(&index, le, end)
+		index.Write(id[:])
+
+		path := chunkLocation(dir, id)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, enc.EncodeAll(chunk, nil), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, v := range []uint64{0, 0, caFormatIndexSize, uint64(16 + 40*(len(chunks)+1)), caFormatTableTailMark} {
+		_ = binary.Write(&index, le, v)
+	}
+
+	return index.Bytes()
+}
+
+func Test_parseChunkIndex(t *testing.T) {
+	chunks := [][]byte{[]byte("first chunk"), []byte("second")}
+	data := makeChunkIndex(t, t.TempDir(), chunks)
+
+	index, err := parseChunkIndex(data)
+	if err != nil {
+		t.Fatalf("parseChunkIndex() error = %v", err)
+	}
+	if len(index.chunks) != 2 || index.chunks[1].offset != 11 || index.chunks[1].length != 6 || index.size() != 17 {
+		t.Errorf("parseChunkIndex() = %+v, want chunks of 11 and 6 bytes", index.chunks)
+	}
+
+	if _, err := parseChunkIndex(data[:len(data)-40]); err == nil {
+		t.Error("parseChunkIndex() of an index without its tail succeeded")
+	}
+	if _, err := parseChunkIndex([]byte(strings.Repeat("x", 100))); err == nil {
+		t.Error("parseChunkIndex() of garbage succeeded")
+	}
+}
+
+func TestWriteChunkIndex(t *testing.T) {
+	chunks := [][]byte{
+		bytes.Repeat([]byte("a"), 4096),
+		bytes.Repeat([]byte("b"), 4096),
+		bytes.Repeat([]byte("c"), 4096),
+	}
+	dir := t.TempDir()
+	index := makeChunkIndex(t, filepath.Join(dir, "default.castr"), chunks)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".cacnk") {
+			atomic.AddInt32(&requests, 1)
+		}
+		http.FileServer(http.Dir(dir)).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "image.caibx"), index, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The disk has the image before it, where only the middle chunk changed
+	disk := filepath.Join(t.TempDir(), "disk")
+	seed := append(append(append([]byte{}, chunks[0]...), bytes.Repeat([]byte("x"), 4096)...), chunks[2]...)
+	if err := ioutil.WriteFile(disk, seed, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	if err := Write(server.URL+"/image.caibx?X-Amz-Signature=secret", disk, false, Options{}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if strings.Contains(logs.String(), "secret") {
+		t.Errorf("Write() logged the query of the index URL:\n%s", logs.String())
+	}
+
+	written, err := ioutil.ReadFile(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, bytes.Join(chunks, nil)) {
+		t.Error("Write() didn't write the image of the chunk index")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("Write() downloaded %d chunks, want only the one that changed", n)
+	}
+}
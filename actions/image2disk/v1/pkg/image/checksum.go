@@ -0,0 +1,67 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// checksum is an expected digest of the source image, computed as the image is streamed.
+type checksum struct {
+	name string
+	want []byte
+	hash hash.Hash
+}
+
+// newChecksums returns a checksum for each of the hex encoded sha256 and sha512 digests that is set.
+func newChecksums(sha256Sum, sha512Sum string) ([]*checksum, error) {
+	var sums []*checksum
+
+	for _, c := range []struct {
+		name string
+		sum  string
+		hash hash.Hash
+	}{
+		{"sha256", sha256Sum, sha256.New()},
+		{"sha512", sha512Sum, sha512.New()},
+	} {
+		if c.sum == "" {
+			continue
+		}
+
+		want, err := hex.DecodeString(strings.TrimSpace(c.sum))
+		if err != nil || len(want) != c.hash.Size() {
+			return nil, fmt.Errorf("invalid %s checksum [%s], must be %d hex characters", c.name, c.sum, 2*c.hash.Size())
+		}
+
+		sums = append(sums, &checksum{name: c.name, want: want, hash: c.hash})
+	}
+
+	return sums, nil
+}
+
+// checksumWriter returns a writer that feeds every checksum.
+func checksumWriter(sums []*checksum) io.Writer {
+	writers := make([]io.Writer, len(sums))
+	for i, sum := range sums {
+		writers[i] = sum.hash
+	}
+
+	return io.MultiWriter(writers...)
+}
+
+// verifyChecksums returns an error naming the first checksum that doesn't match what was streamed.
+func verifyChecksums(sourceImage string, sums []*checksum) error {
+	for _, sum := range sums {
+		if got := sum.hash.Sum(nil); !bytes.Equal(got, sum.want) {
+			return fmt.Errorf("%s checksum mismatch for [%s], expected [%x] got [%x]", sum.name, RedactURL(sourceImage), sum.want, got)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_newChecksums(t *testing.T) {
+	sha256Sum := sha256.Sum256([]byte("YourDataHere"))
+	sha512Sum := sha512.Sum512([]byte("YourDataHere"))
+
+	tests := []struct {
+		name      string
+		sha256Sum string
+		sha512Sum string
+		want      int
+		wantErr   bool
+	}{
+		{"none", "", "", 0, false},
+		{"sha256", hex.EncodeToString(sha256Sum[:]), "", 1, false},
+		{"both upper case", strings.ToUpper(hex.EncodeToString(sha256Sum[:])), hex.EncodeToString(sha512Sum[:]), 2, false},
+		{"sha512 given as sha256", hex.EncodeToString(sha512Sum[:]), "", 0, true},
+		{"not hex", strings.Repeat("z", 64), "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sums, err := newChecksums(tt.sha256Sum, tt.sha512Sum)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newChecksums() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(sums) != tt.want {
+				t.Errorf("newChecksums() returned %d checksums, want %d", len(sums), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteChecksum(t *testing.T) {
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write([]byte("YourDataHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	sha256Sum := sha256.Sum256(compressed.Bytes())
+	sha512Sum := sha512.Sum512(compressed.Bytes())
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"no checksum", Options{}, false},
+		{"sha256", Options{SHA256: hex.EncodeToString(sha256Sum[:])}, false},
+		{"sha256 and sha512", Options{SHA256: hex.EncodeToString(sha256Sum[:]), SHA512: hex.EncodeToString(sha512Sum[:])}, false},
+		{"sha256 mismatch", Options{SHA256: strings.Repeat("0", 64)}, true},
+		{"sha512 mismatch", Options{SHA256: hex.EncodeToString(sha256Sum[:]), SHA512: strings.Repeat("0", 128)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Write(server.URL+"/image.raw.gz?X-Amz-Signature=secret", disk, true, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Write() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && strings.Contains(err.Error(), "secret") {
+				t.Errorf("Write() error = %v, want the query of the image URL redacted", err)
+			}
+
+			written, err := ioutil.ReadFile(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(written) != "YourDataHere" {
+				t.Errorf("Write() wrote %q, want %q", written, "YourDataHere")
+			}
+		})
+	}
+}
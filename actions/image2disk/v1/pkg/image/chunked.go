@@ -0,0 +1,159 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// chunkSize is the size of each range that is downloaded on its own in a chunked download.
+var chunkSize int64 = 8 << 20
+
+// chunk is a downloaded range of the image, or the error that downloading it failed with.
+type chunk struct {
+	data []byte
+	err  error
+}
+
+// chunkedBody downloads the image as ranges of chunkSize, up to concurrency of them at once, and
+// reads them back in order. Chunks that arrive early wait in a reorder buffer of at most concurrency
+// chunks, so a slow chunk holds up the downloads after it rather than memory growing without bound.
+type chunkedBody struct {
+	// pending are the chunks in the order they are read, each is delivered once it is downloaded.
+	pending <-chan chan chunk
+	current []byte
+	err     error
+	// ctx is cancelled once the body is closed, which stops the downloads.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	client *client
+}
+
+// newChunkedBody starts a chunked download of the image at url with concurrency downloads at once,
+// resp is the response to downloading it that tells whether the server can serve ranges. It returns
+// false when it can't or the size of the image is unknown, and the image is then downloaded with
+// resp as usual.
+func newChunkedBody(c *client, url string, resp *http.Response, concurrency int) (io.ReadCloser, bool) {
+	if !acceptsRanges(resp) || resp.ContentLength <= 0 {
+		log.Warnf("Server doesn't serve [%s] in ranges of known size, downloading it in one stream", RedactURL(url))
+		return nil, false
+	}
+
+	// Only the headers of the response were needed
+	resp.Body.Close()
+
+	pending := make(chan chan chunk, concurrency)
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &chunkedBody{pending: pending, ctx: ctx, cancel: cancel, client: c}
+	validator := rangeValidator(resp)
+	size := resp.ContentLength
+
+	log.Infof("Downloading [%s] in chunks of %d bytes, %d at once", RedactURL(url), chunkSize, concurrency)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer close(pending)
+
+		for start := int64(0); start < size; start += chunkSize {
+			end := start + chunkSize - 1
+			if end >= size {
+				end = size - 1
+			}
+
+			delivered := make(chan chunk, 1)
+
+			select {
+			case pending <- delivered:
+			case <-ctx.Done():
+				return
+			}
+
+			b.wg.Add(1)
+			go func(start, end int64) {
+				defer b.wg.Done()
+				delivered <- b.download(url, validator, start, end)
+			}(start, end)
+		}
+	}()
+
+	return b, true
+}
+
+// download fetches a single chunk, retrying it as an interrupted download would be resumed.
+func (b *chunkedBody) download(url, validator string, start, end int64) chunk {
+	var err error
+
+	for retry := 1; ; retry++ {
+		var data []byte
+		if data, err = b.readRange(url, validator, start, end); err == nil {
+			return chunk{data: data}
+		}
+
+		if retry > b.client.retry.retries || !isTransient(err) || b.ctx.Err() != nil {
+			break
+		}
+
+		log.Warnf("Attempt %d to download bytes %d-%d of [%s] failed: %v", retry, start, end, RedactURL(url), err)
+
+		select {
+		case <-time.After(b.client.retry.delay(retry)):
+			b.client.retried()
+		case <-b.ctx.Done():
+			return chunk{err: err}
+		}
+	}
+
+	return chunk{err: fmt.Errorf("failed to download bytes %d-%d -> %w", start, end, err)}
+}
+
+// readRange reads the bytes of the image at url from start to end inclusive.
+func (b *chunkedBody) readRange(url, validator string, start, end int64) ([]byte, error) {
+	resp, err := b.client.getRange(b.ctx, url, validator, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) {
+	for len(b.current) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+
+		delivered, ok := <-b.pending
+		if !ok {
+			return 0, io.EOF
+		}
+
+		c := <-delivered
+		b.current, b.err = c.data, c.err
+	}
+
+	n := copy(p, b.current)
+	b.current = b.current[n:]
+
+	return n, nil
+}
+
+// Close stops any downloads that are still running and waits for them to finish.
+func (b *chunkedBody) Close() error {
+	b.cancel()
+	b.wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteChunked(t *testing.T) {
+	chunkSize = 64 << 10
+
+	raw := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(raw)
+
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+	img := compressed.Bytes()
+	sum := sha256.Sum256(img)
+
+	tests := []struct {
+		name         string
+		acceptRanges bool
+		// failRanges fails the first request for each range
+		failRanges bool
+	}{
+		{"chunked", true, false},
+		{"chunks retried", true, true},
+		{"no range support", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			failed := map[string]bool{}
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				rng := r.Header.Get("Range")
+				if !tt.acceptRanges {
+					w.Write(img)
+					return
+				}
+
+				mu.Lock()
+				fail := tt.failRanges && rng != "" && !failed[rng]
+				failed[rng] = true
+				mu.Unlock()
+
+				if fail {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(img))
+			}))
+			defer server.Close()
+
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Write(server.URL+"/image.raw.gz", disk, true, Options{SHA256: hex.EncodeToString(sum[:]), Concurrency: 4, Retries: 5, RetryBackoff: time.Millisecond}); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			written, err := ioutil.ReadFile(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(written, raw) {
+				t.Errorf("Write() wrote %d bytes that don't match the %d byte image", len(written), len(raw))
+			}
+		})
+	}
+}
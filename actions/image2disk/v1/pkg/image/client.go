@@ -0,0 +1,136 @@
+package image
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// client makes the requests for the image and its signature.
+type client struct {
+	http  *http.Client
+	retry retryPolicy
+	// token is sent as a bearer token, or user and pass with basic auth, when either is set.
+	token      string
+	user, pass string
+	// retries counts the requests that were retried, it is reported with the metrics of the write.
+	retries uint64
+}
+
+// newClient returns a client for the options of a write.
+func newClient(opts Options) (*client, error) {
+	if opts.AuthToken != "" && (opts.BasicUser != "" || opts.BasicPass != "") {
+		return nil, errors.New("only one of a bearer token or basic auth can be used")
+	}
+
+	httpClient := http.DefaultClient
+	if opts.CACert != "" || opts.InsecureSkipVerify {
+		tlsConfig, err := newTLSConfig(opts.CACert, opts.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpClient = &http.Client{Transport: transport}
+	}
+
+	return &client{
+		http:  httpClient,
+		retry: retryPolicy{retries: opts.Retries, backoff: opts.RetryBackoff},
+		token: opts.AuthToken,
+		user:  opts.BasicUser,
+		pass:  opts.BasicPass,
+	}, nil
+}
+
+// retried counts a retry of a request, it is safe to call from the chunks of a parallel download.
+func (c *client) retried() {
+	atomic.AddUint64(&c.retries, 1)
+}
+
+// newTLSConfig returns the TLS config for downloads that trust caCert, a PEM encoded bundle or the
+// path to one, on top of the system roots, or that don't verify the server at all.
+func newTLSConfig(caCert string, insecureSkipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecureSkipVerify, //nolint:gosec // explicitly requested by the workflow
+	}
+
+	if insecureSkipVerify {
+		log.Warn("TLS certificates of the image server are not verified")
+	}
+
+	if caCert == "" {
+		return config, nil
+	}
+
+	pemCerts := []byte(caCert)
+	if !strings.Contains(caCert, "-----BEGIN") {
+		var err error
+		if pemCerts, err = ioutil.ReadFile(caCert); err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate -> %w", err)
+		}
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, errors.New("no PEM encoded certificates found in the CA certificate")
+	}
+	config.RootCAs = pool
+
+	return config, nil
+}
+
+// newRequest returns a GET request for url with the credentials of the client.
+func (c *client) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.user != "" || c.pass != "":
+		req.SetBasicAuth(c.user, c.pass)
+	}
+
+	return req, nil
+}
+
+// get requests url and returns the response when it was successful.
+func (c *client) get(url string) (*http.Response, error) {
+	req, err := c.newRequest(context.TODO(), url)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, redactError(err)
+	}
+
+	if resp.StatusCode > 300 {
+		resp.Body.Close()
+
+		// Customise response for the 404 to make degugging simpler
+		if resp.StatusCode == 404 {
+			return nil, &statusError{code: resp.StatusCode, msg: fmt.Sprintf("%s not found", RedactURL(url))}
+		}
+		return nil, &statusError{code: resp.StatusCode, msg: resp.Status}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,135 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAuth(t *testing.T) {
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write([]byte("YourDataHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		want    func(r *http.Request) bool
+		opts    Options
+		wantErr bool
+	}{
+		{
+			name: "bearer token",
+			want: func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer s3cr3t" },
+			opts: Options{AuthToken: "s3cr3t"},
+		},
+		{
+			name: "basic auth",
+			want: func(r *http.Request) bool {
+				user, pass, ok := r.BasicAuth()
+				return ok && user == "deploy" && pass == "s3cr3t"
+			},
+			opts: Options{BasicUser: "deploy", BasicPass: "s3cr3t"},
+		},
+		{
+			name:    "wrong token",
+			want:    func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer s3cr3t" },
+			opts:    Options{AuthToken: "guess"},
+			wantErr: true,
+		},
+		{
+			name:    "token and basic auth",
+			want:    func(r *http.Request) bool { return true },
+			opts:    Options{AuthToken: "s3cr3t", BasicUser: "deploy"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tt.want(r) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write(compressed.Bytes())
+			}))
+			defer server.Close()
+
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Write(server.URL+"/image.raw.gz", disk, true, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			written, err := ioutil.ReadFile(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(written) != "YourDataHere" {
+				t.Errorf("Write() wrote %q, want %q", written, "YourDataHere")
+			}
+		})
+	}
+}
+
+func TestWriteTLS(t *testing.T) {
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write([]byte("YourDataHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caPath, []byte(caCert), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"untrusted", Options{}, true},
+		{"ca cert", Options{CACert: caCert}, false},
+		{"ca cert path", Options{CACert: caPath}, false},
+		{"insecure skip verify", Options{InsecureSkipVerify: true}, false},
+		{"missing ca cert path", Options{CACert: filepath.Join(t.TempDir(), "missing.pem")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Write(server.URL+"/image.raw.gz", disk, true, tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
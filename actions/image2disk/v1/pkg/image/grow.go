@@ -0,0 +1,323 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// Resizing a mounted filesystem, as resize2fs and xfs_growfs do, for which the action has no tools.
+const (
+	// ext4IocResizeFS is EXT4_IOC_RESIZE_FS, _IOW('f', 16, __u64).
+	ext4IocResizeFS = 0x40086610
+	// xfsIocFSGrowFSData is XFS_IOC_FSGROWFSDATA, _IOW('X', 110, struct xfs_growfs_data).
+	xfsIocFSGrowFSData = 0x4010586e
+)
+
+// growMountpoint is where the grown partition is mounted to resize its filesystem.
+var growMountpoint = "/mnt/grow"
+
+// growLastPartition grows the last partition of the GPT or MBR partition table of disk to the end
+// of the disk, and returns its number. It returns 0 when the partition already fills the disk.
+func growLastPartition(disk *os.File) (int, error) {
+	sectorSize, err := unix.IoctlGetInt(int(disk.Fd()), unix.BLKSSZGET)
+	if err != nil || sectorSize <= 0 {
+		sectorSize = 512
+	}
+
+	size, err := disk.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the size of disk [%s] -> %w", disk.Name(), err)
+	}
+	sectors := size / int64(sectorSize)
+
+	mbr := make([]byte, 512)
+	if _, err := disk.ReadAt(mbr, 0); err != nil {
+		return 0, fmt.Errorf("failed to read the partition table -> %w", err)
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return 0, errors.New("disk has no partition table")
+	}
+
+	for i := 0; i < 4; i++ {
+		if mbr[446+i*16+4] == 0xee {
+			return growGPT(disk, mbr, int64(sectorSize), sectors)
+		}
+	}
+
+	return growMBR(disk, mbr, sectors)
+}
+
+// growMBR grows the last primary partition of an MBR partition table, up to the 2TiB it can address.
+func growMBR(disk *os.File, mbr []byte, sectors int64) (int, error) {
+	if sectors > 1<<32-1 {
+		sectors = 1<<32 - 1
+	}
+
+	last, lastStart := -1, int64(-1)
+	for i := 0; i < 4; i++ {
+		entry := mbr[446+i*16 : 446+(i+1)*16]
+		if entry[4] == 0 {
+			continue
+		}
+		if start := int64(binary.LittleEndian.Uint32(entry[8:12])); start > lastStart {
+			last, lastStart = i, start
+		}
+	}
+	if last < 0 {
+		return 0, errors.New("partition table has no partitions")
+	}
+
+	entry := mbr[446+last*16 : 446+(last+1)*16]
+	switch entry[4] {
+	case 0x05, 0x0f, 0x85:
+		return 0, errors.New("growing a logical partition is not supported")
+	}
+
+	count := int64(binary.LittleEndian.Uint32(entry[12:16]))
+	if lastStart+count >= sectors {
+		return 0, nil
+	}
+
+	binary.LittleEndian.PutUint32(entry[12:16], uint32(sectors-lastStart))
+	if _, err := disk.WriteAt(mbr, 0); err != nil {
+		return 0, fmt.Errorf("failed to write the partition table -> %w", err)
+	}
+
+	return last + 1, nil
+}
+
+// growGPT grows the partition that ends last in a GPT. The backup GPT is moved to the end of the
+// disk, as an image written to a larger disk has it part way through.
+func growGPT(disk *os.File, mbr []byte, sectorSize, sectors int64) (int, error) {
+	header := make([]byte, sectorSize)
+	if _, err := disk.ReadAt(header, sectorSize); err != nil {
+		return 0, fmt.Errorf("failed to read the GPT header -> %w", err)
+	}
+	if !bytes.Equal(header[:8], []byte("EFI PART")) {
+		return 0, errors.New("protective MBR without a GPT header")
+	}
+
+	headerSize := binary.LittleEndian.Uint32(header[12:16])
+	entriesLBA := int64(binary.LittleEndian.Uint64(header[72:80]))
+	count := binary.LittleEndian.Uint32(header[80:84])
+	entrySize := binary.LittleEndian.Uint32(header[84:88])
+	if headerSize < 92 || int64(headerSize) > sectorSize || entrySize < 128 || count > 1024 {
+		return 0, errors.New("invalid GPT header")
+	}
+
+	entries := make([]byte, int64(count)*int64(entrySize))
+	if _, err := disk.ReadAt(entries, entriesLBA*sectorSize); err != nil {
+		return 0, fmt.Errorf("failed to read the GPT entries -> %w", err)
+	}
+
+	lastSector := sectors - 1
+	entrySectors := (int64(len(entries)) + sectorSize - 1) / sectorSize
+	lastUsable := lastSector - entrySectors - 1
+
+	last, lastEnd := -1, int64(-1)
+	for i := 0; i < int(count); i++ {
+		entry := entries[i*int(entrySize) : (i+1)*int(entrySize)]
+		if bytes.Equal(entry[:16], make([]byte, 16)) {
+			continue
+		}
+		if end := int64(binary.LittleEndian.Uint64(entry[40:48])); end > lastEnd {
+			last, lastEnd = i, end
+		}
+	}
+	if last < 0 {
+		return 0, errors.New("partition table has no partitions")
+	}
+	if lastEnd >= lastUsable {
+		return 0, nil
+	}
+
+	binary.LittleEndian.PutUint64(entries[last*int(entrySize)+40:], uint64(lastUsable))
+
+	binary.LittleEndian.PutUint64(header[32:40], uint64(lastSector))
+	binary.LittleEndian.PutUint64(header[48:56], uint64(lastUsable))
+	binary.LittleEndian.PutUint32(header[88:92], crc32.ChecksumIEEE(entries))
+	setHeaderCRC(header, headerSize)
+
+	backup := append([]byte{}, header...)
+	binary.LittleEndian.PutUint64(backup[24:32], uint64(lastSector))
+	binary.LittleEndian.PutUint64(backup[32:40], 1)
+	binary.LittleEndian.PutUint64(backup[72:80], uint64(lastSector-entrySectors))
+	setHeaderCRC(backup, headerSize)
+
+	// The protective partition covers the whole disk, as far as an MBR can
+	protective := sectors - 1
+	if protective > 1<<32-1 {
+		protective = 1<<32 - 1
+	}
+	for i := 0; i < 4; i++ {
+		if entry := mbr[446+i*16 : 446+(i+1)*16]; entry[4] == 0xee {
+			binary.LittleEndian.PutUint32(entry[12:16], uint32(protective))
+		}
+	}
+
+	writes := []struct {
+		data   []byte
+		offset int64
+	}{
+		{entries, entriesLBA * sectorSize},
+		{header, sectorSize},
+		{entries, (lastSector - entrySectors) * sectorSize},
+		{backup, lastSector * sectorSize},
+		{mbr, 0},
+	}
+	for _, w := range writes {
+		if _, err := disk.WriteAt(w.data, w.offset); err != nil {
+			return 0, fmt.Errorf("failed to write the partition table -> %w", err)
+		}
+	}
+
+	return last + 1, nil
+}
+
+// setHeaderCRC sets the CRC32 of a GPT header, which is computed with the CRC itself zeroed.
+func setHeaderCRC(header []byte, headerSize uint32) {
+	binary.LittleEndian.PutUint32(header[16:20], 0)
+	binary.LittleEndian.PutUint32(header[16:20], crc32.ChecksumIEEE(header[:headerSize]))
+}
+
+// filesystem is the type of filesystem on a partition and its block size.
+type filesystem struct {
+	fsType    string
+	blockSize int64
+	// imaxpct is the share of an xfs filesystem that inodes may take up, which growing it keeps.
+	imaxpct uint32
+}
+
+// detectFilesystem reads the superblock of the ext4 or xfs filesystem on partition.
+func detectFilesystem(partition io.ReaderAt) (*filesystem, error) {
+	sb := make([]byte, 2048)
+	if _, err := partition.ReadAt(sb, 0); err != nil {
+		return nil, fmt.Errorf("failed to read the superblock -> %w", err)
+	}
+
+	if bytes.Equal(sb[:4], []byte("XFSB")) {
+		return &filesystem{
+			fsType:    "xfs",
+			blockSize: int64(binary.BigEndian.Uint32(sb[4:8])),
+			imaxpct:   uint32(sb[127]),
+		}, nil
+	}
+
+	if binary.LittleEndian.Uint16(sb[1024+56:]) == 0xef53 {
+		return &filesystem{
+			fsType:    "ext4",
+			blockSize: 1024 << binary.LittleEndian.Uint32(sb[1024+24:]),
+		}, nil
+	}
+
+	return nil, errors.New("no ext4 or xfs filesystem found")
+}
+
+// growFilesystem grows the filesystem on partition number of disk to fill the partition, by
+// mounting it and resizing it online.
+func growFilesystem(disk string, number int) error {
+	name := filepath.Base(disk)
+	if resolved, err := filepath.EvalSymlinks(disk); err == nil {
+		name = filepath.Base(resolved)
+	}
+
+	part, err := partitionNode(partitionName(name, number))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fs, err := detectFilesystem(f)
+	if err != nil {
+		return fmt.Errorf("can't grow the filesystem on [%s] -> %w", part, err)
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	blocks := uint64(size / fs.blockSize)
+
+	if err := os.MkdirAll(growMountpoint, 0o755); err != nil {
+		return err
+	}
+	if err := unix.Mount(part, growMountpoint, fs.fsType, 0, ""); err != nil {
+		return fmt.Errorf("mounting [%s] -> [%s] error -> %w", part, growMountpoint, err)
+	}
+	defer unix.Unmount(growMountpoint, 0)
+
+	mnt, err := os.Open(growMountpoint)
+	if err != nil {
+		return err
+	}
+	defer mnt.Close()
+
+	switch fs.fsType {
+	case "ext4":
+		err = ioctlPtr(mnt.Fd(), ext4IocResizeFS, unsafe.Pointer(&blocks))
+	case "xfs":
+		data := struct {
+			newblocks uint64
+			imaxpct   uint32
+			_         uint32
+		}{newblocks: blocks, imaxpct: fs.imaxpct}
+		err = ioctlPtr(mnt.Fd(), xfsIocFSGrowFSData, unsafe.Pointer(&data))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to grow the %s filesystem on [%s] -> %w", fs.fsType, part, err)
+	}
+
+	log.Infof("Grew the %s filesystem on [%s] to %d blocks", fs.fsType, part, blocks)
+
+	return nil
+}
+
+// partitionNode returns the device node of the partition named part, creating it from the device
+// number the kernel lists when the container of the action doesn't have it.
+func partitionNode(part string) (string, error) {
+	node := filepath.Join(devDir, part)
+	if _, err := os.Stat(node); err == nil {
+		return node, nil
+	}
+
+	dev, err := ioutil.ReadFile(filepath.Join(sysBlock, part, "dev"))
+	if err != nil {
+		return "", fmt.Errorf("partition [%s] not found -> %w", part, err)
+	}
+
+	var major, minor uint32
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(dev)), "%d:%d", &major, &minor); err != nil {
+		return "", fmt.Errorf("invalid device number of partition [%s] -> %w", part, err)
+	}
+
+	if err := unix.Mknod(node, unix.S_IFBLK|0o600, int(unix.Mkdev(major, minor))); err != nil {
+		return "", fmt.Errorf("failed to create the device node of partition [%s] -> %w", part, err)
+	}
+
+	return node, nil
+}
+
+func ioctlPtr(fd uintptr, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
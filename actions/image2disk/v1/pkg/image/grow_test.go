@@ -0,0 +1,211 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newGPTDisk returns a disk of sectors 512 byte sectors with a GPT of the partitions, each a first
+// and last sector.
+func newGPTDisk(t *testing.T, sectors int64, parts ...[2]int64) *os.File {
+	t.Helper()
+
+	disk := make([]byte, 34*512)
+	disk[446+4] = 0xee
+	binary.LittleEndian.PutUint32(disk[446+8:], 1)
+	binary.LittleEndian.PutUint32(disk[446+12:], uint32(sectors-1))
+	disk[510], disk[511] = 0x55, 0xaa
+
+	entries := make([]byte, 128*128)
+	for i, p := range parts {
+		entry := entries[i*128:]
+		entry[0] = 0xaf
+		binary.LittleEndian.PutUint64(entry[32:], uint64(p[0]))
+		binary.LittleEndian.PutUint64(entry[40:], uint64(p[1]))
+	}
+	copy(disk[2*512:], entries)
+
+	header := disk[512:1024]
+	copy(header, "EFI PART")
+	binary.LittleEndian.PutUint32(header[8:], 0x00010000)
+	binary.LittleEndian.PutUint32(header[12:], 92)
+	binary.LittleEndian.PutUint64(header[24:], 1)
+	binary.LittleEndian.PutUint64(header[32:], uint64(sectors-1))
+	binary.LittleEndian.PutUint64(header[40:], 34)
+	binary.LittleEndian.PutUint64(header[48:], uint64(sectors-34))
+	binary.LittleEndian.PutUint64(header[72:], 2)
+	binary.LittleEndian.PutUint32(header[80:], 128)
+	binary.LittleEndian.PutUint32(header[84:], 128)
+	binary.LittleEndian.PutUint32(header[88:], crc32.ChecksumIEEE(entries))
+	setHeaderCRC(header, 92)
+
+	f, err := os.OpenFile(filepath.Join(t.TempDir(), "disk"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	if _, err := f.WriteAt(disk, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(sectors * 512); err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+// checkGPTHeader checks the CRC of the GPT header at lba and returns it.
+func checkGPTHeader(t *testing.T, f *os.File, lba int64) []byte {
+	t.Helper()
+
+	header := make([]byte, 92)
+	if _, err := f.ReadAt(header, lba*512); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(header[:8], []byte("EFI PART")) {
+		t.Fatalf("no GPT header at LBA %d", lba)
+	}
+
+	crc := binary.LittleEndian.Uint32(header[16:])
+	check := append([]byte{}, header...)
+	setHeaderCRC(check, 92)
+	if binary.LittleEndian.Uint32(check[16:]) != crc {
+		t.Errorf("GPT header at LBA %d has a bad CRC", lba)
+	}
+
+	return header
+}
+
+func Test_growLastPartition_gpt(t *testing.T) {
+	// An image of 4096 sectors with two partitions, written to a disk of 10000 sectors
+	f := newGPTDisk(t, 4096, [2]int64{2048, 2559}, [2]int64{2560, 4062})
+	if err := f.Truncate(10000 * 512); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := growLastPartition(f)
+	if err != nil {
+		t.Fatalf("growLastPartition() error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("growLastPartition() grew partition %d, want 2", n)
+	}
+
+	primary := checkGPTHeader(t, f, 1)
+	backup := checkGPTHeader(t, f, 9999)
+
+	if got := binary.LittleEndian.Uint64(primary[48:]); got != 9966 {
+		t.Errorf("last usable LBA = %d, want 9966", got)
+	}
+	if got := binary.LittleEndian.Uint64(primary[32:]); got != 9999 {
+		t.Errorf("backup header LBA = %d, want 9999", got)
+	}
+	if got := binary.LittleEndian.Uint64(backup[72:]); got != 9967 {
+		t.Errorf("backup entries LBA = %d, want 9967", got)
+	}
+
+	primaryEntries := make([]byte, 128*128)
+	if _, err := f.ReadAt(primaryEntries, 2*512); err != nil {
+		t.Fatal(err)
+	}
+	backupEntries := make([]byte, 128*128)
+	if _, err := f.ReadAt(backupEntries, 9967*512); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(primaryEntries, backupEntries) {
+		t.Errorf("backup GPT entries don't match the primary ones")
+	}
+	if crc32.ChecksumIEEE(primaryEntries) != binary.LittleEndian.Uint32(primary[88:]) {
+		t.Errorf("GPT entries have a bad CRC")
+	}
+
+	if got := binary.LittleEndian.Uint64(primaryEntries[128+40:]); got != 9966 {
+		t.Errorf("last partition ends at %d, want 9966", got)
+	}
+	if got := binary.LittleEndian.Uint64(primaryEntries[40:]); got != 2559 {
+		t.Errorf("first partition ends at %d, want it untouched at 2559", got)
+	}
+
+	// Growing it again leaves it as it is
+	if n, err := growLastPartition(f); err != nil || n != 0 {
+		t.Errorf("growLastPartition() again = %d, %v, want 0, nil", n, err)
+	}
+}
+
+func Test_growLastPartition_mbr(t *testing.T) {
+	disk := make([]byte, 512)
+	disk[446+4] = 0x83
+	binary.LittleEndian.PutUint32(disk[446+8:], 2048)
+	binary.LittleEndian.PutUint32(disk[446+12:], 2048)
+	disk[446+16+4] = 0x83
+	binary.LittleEndian.PutUint32(disk[446+16+8:], 4096)
+	binary.LittleEndian.PutUint32(disk[446+16+12:], 4096)
+	disk[510], disk[511] = 0x55, 0xaa
+
+	path := filepath.Join(t.TempDir(), "disk")
+	if err := ioutil.WriteFile(path, disk, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(20000 * 512); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := growLastPartition(f)
+	if err != nil {
+		t.Fatalf("growLastPartition() error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("growLastPartition() grew partition %d, want 2", n)
+	}
+
+	if _, err := f.ReadAt(disk, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.LittleEndian.Uint32(disk[446+16+12:]); got != 20000-4096 {
+		t.Errorf("last partition has %d sectors, want %d", got, 20000-4096)
+	}
+}
+
+func Test_detectFilesystem(t *testing.T) {
+	ext4 := make([]byte, 2048)
+	binary.LittleEndian.PutUint32(ext4[1024+24:], 2)
+	binary.LittleEndian.PutUint16(ext4[1024+56:], 0xef53)
+
+	xfs := make([]byte, 2048)
+	copy(xfs, "XFSB")
+	binary.BigEndian.PutUint32(xfs[4:], 4096)
+	xfs[127] = 25
+
+	tests := []struct {
+		name    string
+		sb      []byte
+		want    filesystem
+		wantErr bool
+	}{
+		{"ext4", ext4, filesystem{fsType: "ext4", blockSize: 4096}, false},
+		{"xfs", xfs, filesystem{fsType: "xfs", blockSize: 4096, imaxpct: 25}, false},
+		{"unknown", make([]byte, 2048), filesystem{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := detectFilesystem(bytes.NewReader(tt.sb))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("detectFilesystem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("detectFilesystem() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -3,22 +3,27 @@ package image
 // This package handles the pulling and management of images
 
 import (
+	"bufio"
+	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
-	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
 	log "github.com/sirupsen/logrus"
 	"github.com/ulikunitz/xz"
-	"golang.org/x/sys/unix"
 )
 
 // WriteCounter counts the number of bytes written to it. It implements to the io.Writer interface
@@ -29,116 +34,456 @@ type WriteCounter struct {
 
 func (wc *WriteCounter) Write(p []byte) (int, error) {
 	n := len(p)
-	wc.Total += uint64(n)
+	atomic.AddUint64(&wc.Total, uint64(n))
 	return n, nil
 }
 
-func tickerProgress(byteCounter uint64) {
-	// Clear the line by using a character return to go back to the start and remove
-	// the remaining characters by filling it with spaces
-	fmt.Printf("\r%s", strings.Repeat(" ", 35))
+// Count returns the number of bytes written so far, it is safe to call during a write.
+func (wc *WriteCounter) Count() uint64 {
+	return atomic.LoadUint64(&wc.Total)
+}
 
-	// Return again and print current status of download
-	// We use the humanize package to print the bytes in a meaningful way (e.g. 10 MB)
-	fmt.Printf("\rDownloading... %s complete", humanize.Bytes(byteCounter))
+// Options change how an image is written.
+type Options struct {
+	// SHA256 and SHA512 are hex encoded digests of the source image as it is downloaded, before it
+	// is decompressed. The write fails if the image doesn't match any that is set.
+	SHA256 string
+	SHA512 string
+	// SignatureURL is the URL of a detached signature of the source image, which is verified with
+	// the PEM encoded PublicKey.
+	SignatureURL string
+	PublicKey    string
+	// Concurrency is the number of ranges of the source image that are downloaded at once, the
+	// image is downloaded in a single stream when it is 1 or less.
+	Concurrency int
+	// ProgressInterval is how often the progress of the write is logged, every 10 seconds when
+	// it isn't set.
+	ProgressInterval time.Duration
+	// Retries is how many times a failed request for the image is retried, and an interrupted
+	// download resumed, before the write fails. RetryBackoff is the delay before the first retry,
+	// it doubles on each one after.
+	Retries      int
+	RetryBackoff time.Duration
+	// AuthToken is sent as a bearer token with the requests for the image and its signature, or
+	// BasicUser and BasicPass with basic auth.
+	AuthToken string
+	BasicUser string
+	BasicPass string
+	// CACert is a PEM encoded CA bundle, or the path to one, that is trusted for the image server
+	// along with the system roots. InsecureSkipVerify skips verifying its certificate altogether.
+	CACert             string
+	InsecureSkipVerify bool
+	// WriteLimit caps the rate that the image is written to the disk at, in megabytes per second.
+	// Writes aren't limited when it is 0.
+	WriteLimit float64
+	// Verify reads the disk back once the image is written, and fails the write unless it matches
+	// what was written to it.
+	Verify bool
+	// BmapURL is the bmap file of the image, as made by bmaptool create, so that only the blocks
+	// of the image that it maps are written.
+	BmapURL string
+	// Wipe zeroes the filesystem, RAID and partition table signatures of the disk, and of the
+	// partitions on it, before the image is written.
+	Wipe bool
+	// PartitionTimeout is how long to wait for the partitions of the image to appear once it is
+	// written, DefaultPartitionTimeout when it isn't set.
+	PartitionTimeout time.Duration
+	// GrowPartition grows the last partition of the image to the end of the disk, along with the
+	// ext4 or xfs filesystem on it.
+	GrowPartition bool
+	// CacheDir is a directory that images downloaded from a server are stored in as they are
+	// downloaded, keyed by their URL and digests, so that the next write of the same image reads it
+	// from there instead.
+	CacheDir string
+	// Mirrors are other URLs of the same image, that are tried in order when the source image fails
+	// to download.
+	Mirrors []string
+	// MetricsURL is where a JSON record of how the write went, with how long it took and its
+	// throughput, is posted to once it is done.
+	MetricsURL string
+	// ImageSize is the size of the image once it is decompressed, which the disk is checked to have
+	// room for before it is written. The size of a bmap, or that of an image that isn't compressed,
+	// is checked when it isn't set.
+	ImageSize int64
+	// ChunkStore is the casync chunk store of an image that is a .caibx chunk index, default.castr
+	// next to the index when it isn't set.
+	ChunkStore string
+	// Compression is the compression format of the image, gzip, bzip2, xz, zstd, lz4 or none, which
+	// overrides the format found from the magic bytes at the start of a compressed image.
+	Compression string
+	// DecompressThreads is how many cores gzip and zstd images are decompressed with, all of them
+	// when it isn't set. A gzip image is decompressed on a single core when it is 1.
+	DecompressThreads int
 }
 
 // Write will pull an image and write it to local storage device
 // with compress set to true it will use gzip compression to expand the data before
 // writing to an underlying device.
-func Write(sourceImage, destinationDevice string, compressed bool) error {
-	req, err := http.NewRequestWithContext(context.TODO(), "GET", sourceImage, nil)
+func Write(sourceImage, destinationDevice string, compressed bool, opts Options) error {
+	rec := newMetrics(sourceImage, destinationDevice)
+
+	err := write(sourceImage, destinationDevice, compressed, opts, rec)
+
+	if opts.MetricsURL != "" {
+		rec.report(opts.MetricsURL, err)
+	}
+
+	return err
+}
+
+// write writes the image for Write, and records how it went in rec as it goes.
+func write(sourceImage, destinationDevice string, compressed bool, opts Options, rec *metrics) error {
+	c, err := newClient(opts)
 	if err != nil {
 		return err
 	}
+	rec.client = c
 
-	resp, err := http.DefaultClient.Do(req)
+	switch opts.Compression {
+	case "":
+	case "none":
+		compressed = false
+	default:
+		compressed = true
+	}
+
+	if isChunkIndex(sourceImage) {
+		return writeChunkIndex(c, sourceImage, destinationDevice, opts)
+	}
+
+	sums, err := newChecksums(opts.SHA256, opts.SHA512)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode > 300 {
-		// Customise response for the 404 to make degugging simpler
-		if resp.StatusCode == 404 {
-			return fmt.Errorf("%s not found", sourceImage)
+	// The signature is fetched first so that a missing one fails before the disk is touched
+	var sig *signature
+	if opts.PublicKey != "" && opts.SignatureURL == "" {
+		return errors.New("a signature URL is required to verify the image with the public key")
+	}
+	if opts.SignatureURL != "" {
+		if sig, err = fetchSignature(c, opts.SignatureURL, opts.PublicKey); err != nil {
+			return err
 		}
-		return fmt.Errorf("%s", resp.Status)
 	}
 
+	var bm *bmap
+	if opts.BmapURL != "" {
+		data, err := readLocation(c, opts.BmapURL)
+		if err != nil {
+			return fmt.Errorf("failed to fetch bmap -> %w", err)
+		}
+		if bm, err = parseBmap(data); err != nil {
+			return err
+		}
+	}
+
+	// An image that is cached is read from the cache, one that isn't is stored there as it is downloaded
+	location := sourceImage
+	var cached *cacheEntry
+	if _, local := localPath(sourceImage); opts.CacheDir != "" && !local {
+		path := cachePath(opts.CacheDir, sourceImage, opts.SHA256, opts.SHA512)
+		if _, err := os.Stat(path); err == nil {
+			log.Infof("Reading image [%s] from the cache", imageName(sourceImage))
+			location = path
+		} else if cached, err = newCacheEntry(path); err != nil {
+			return err
+		} else {
+			defer cached.discard()
+		}
+	}
+
+	src, size, err := openImage(c, location, opts.Mirrors, opts.Concurrency)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// The checksums and the signature are computed over the image as it is downloaded, and the
+	// cache stores all of it
+	var digests []io.Writer
+	if len(sums) > 0 {
+		digests = append(digests, checksumWriter(sums))
+	}
+	if sig != nil {
+		digests = append(digests, sig.hash)
+	}
+	if cached != nil {
+		digests = append(digests, cached)
+	}
+	if opts.MetricsURL != "" {
+		digests = append(digests, rec.digest)
+	}
+
+	// Create our progress reporter, it counts the image both as it is downloaded and as it is written
+	prog := newProgress(sourceImage, size)
+	rec.progress = prog
+	body := io.TeeReader(src, io.MultiWriter(append(digests, prog.downloaded)...))
+
 	var out io.Reader
 
-	fileOut, err := os.OpenFile(destinationDevice, os.O_WRONLY, 0o644)
+	fileOut, err := os.OpenFile(destinationDevice, os.O_RDWR, 0o644)
 	if err != nil {
 		return err
 	}
 	defer fileOut.Close()
 
+	if n := uncompressedSize(opts, bm, compressed, size); n > 0 {
+		if err := checkCapacity(fileOut, n); err != nil {
+			return err
+		}
+	}
+
+	if opts.Wipe {
+		if err := wipe(fileOut); err != nil {
+			return err
+		}
+	}
+
 	if !compressed {
 		// Without compression send raw output
-		out = resp.Body
+		out = body
 	} else {
-		// Find compression algorithm based upon extension
-		out, err = findDecompressor(sourceImage, resp.Body)
+		// Find compression algorithm based upon its magic bytes or extension
+		out, err = findDecompressor(sourceImage, opts.Compression, opts.DecompressThreads, body)
 		if err != nil {
 			return err
 		}
 	}
 
-	log.Infof("Beginning write of image [%s] to disk [%s]", filepath.Base(sourceImage), destinationDevice)
-	// Create our progress reporter and pass it to be used alongside our writer
-	ticker := time.NewTicker(500 * time.Millisecond)
-	counter := &WriteCounter{}
+	log.Infof("Beginning write of image [%s] to disk [%s]", imageName(sourceImage), destinationDevice)
+	stopProgress := prog.run(opts.ProgressInterval)
 
-	go func() {
-		for ; true; <-ticker.C {
-			tickerProgress(counter.Total)
-		}
-	}()
-	if _, err = io.Copy(fileOut, io.TeeReader(out, counter)); err != nil {
-		ticker.Stop()
-		return err
+	var dest io.Writer = fileOut
+	if opts.WriteLimit > 0 {
+		log.Infof("Limiting writes to disk [%s] to %g MB/s", destinationDevice, opts.WriteLimit)
+		dest = newThrottledWriter(fileOut, opts.WriteLimit)
+	}
+
+	// The digest of what is written is what the disk is verified against when it is read back
+	written := sha256.New()
+	if opts.Verify {
+		dest = io.MultiWriter(dest, written)
 	}
 
-	count, err := io.Copy(fileOut, out)
+	var (
+		count   int64
+		extents []extent
+	)
+	if bm != nil {
+		log.Infof("Writing the %s of image [%s] that its bmap maps", humanize.Bytes(uint64(bm.mappedSize())), imageName(sourceImage))
+		extents, err = bm.write(fileOut, dest, io.TeeReader(out, prog.written))
+		for _, e := range extents {
+			count += e.length
+		}
+	} else {
+		count, err = io.Copy(dest, io.TeeReader(out, prog.written))
+		extents = []extent{{offset: 0, length: count}}
+	}
+	stopProgress()
 	if err != nil {
-		ticker.Stop()
 		return fmt.Errorf("error writing %d bytes to disk [%s] -> %w", count, destinationDevice, err)
 	}
-	fmt.Printf("\n")
 
-	ticker.Stop()
+	log.Info(prog.status(time.Since(prog.start)))
+
+	if len(digests) > 0 {
+		// A decompressor may stop before the end of the download, which the digests have to cover
+		if _, err := io.Copy(ioutil.Discard, body); err != nil {
+			return fmt.Errorf("error reading the rest of [%s] -> %w", sourceImage, err)
+		}
+	}
+
+	if len(sums) > 0 {
+		if err := verifyChecksums(sourceImage, sums); err != nil {
+			if location != sourceImage {
+				// The cached image is corrupt, the next write downloads it again
+				os.Remove(location)
+			}
+			return err
+		}
+
+		log.Infof("Verified the checksum of image [%s]", imageName(sourceImage))
+	}
+
+	if sig != nil {
+		if err := sig.verify(sourceImage); err != nil {
+			return err
+		}
+
+		log.Infof("Verified the signature of image [%s]", imageName(sourceImage))
+	}
+
+	if cached != nil {
+		if err := cached.commit(); err != nil {
+			// The image is written, it just won't be read from the cache next time
+			log.Warn(err)
+		} else if cached.err == nil {
+			log.Infof("Cached image [%s] in [%s]", imageName(sourceImage), opts.CacheDir)
+		}
+	}
 
 	// Do the equivalent of partprobe on the device
 	if err := fileOut.Sync(); err != nil {
 		return fmt.Errorf("failed to sync the block device")
 	}
 
-	if err := unix.IoctlSetInt(int(fileOut.Fd()), unix.BLKRRPART, 0); err != nil {
+	if opts.Verify {
+		log.Infof("Reading back %s from disk [%s] to verify it", humanize.Bytes(uint64(count)), destinationDevice)
+		if err := verifyWritten(destinationDevice, extents, written.Sum(nil)); err != nil {
+			return err
+		}
+
+		log.Infof("Verified the image written to disk [%s]", destinationDevice)
+	}
+
+	return finishDisk(fileOut, destinationDevice, opts)
+}
+
+// finishDisk grows the last partition of the disk the image was written to when asked to, and
+// waits for its partitions to appear.
+func finishDisk(disk *os.File, destinationDevice string, opts Options) error {
+	var (
+		grown int
+		err   error
+	)
+	if opts.GrowPartition {
+		if grown, err = growLastPartition(disk); err != nil {
+			return fmt.Errorf("failed to grow the last partition of disk [%s] -> %w", destinationDevice, err)
+		}
+		if err := disk.Sync(); err != nil {
+			return fmt.Errorf("failed to sync the block device")
+		}
+		if grown == 0 {
+			log.Infof("The last partition of disk [%s] already fills it", destinationDevice)
+		}
+	}
+
+	if err := settlePartitions(disk, destinationDevice, opts.PartitionTimeout); err != nil {
+		return err
+	}
+
+	if grown > 0 {
+		log.Infof("Grew partition %d of disk [%s] to the end of the disk", grown, destinationDevice)
+		if err := growFilesystem(destinationDevice, grown); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// settlePartitions re-reads the partition table of the disk the image was written to, and waits for
+// its partitions to appear so that the actions after this one can use them straight away.
+func settlePartitions(disk *os.File, destinationDevice string, timeout time.Duration) error {
+	if err := rereadPartitions(disk); err != nil {
 		// Ignore errors since it may be a partition, but log in case it's helpful
 		log.Errorf("error re-probing the partitions for the specified device: %v", err)
+		return nil
+	}
+
+	numbers, err := partitionNumbers(disk)
+	if err != nil {
+		log.Errorf("error reading the partition table of the specified device: %v", err)
+		return nil
+	}
+	if len(numbers) == 0 {
+		return nil
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultPartitionTimeout
+	}
+	if err := waitForPartitions(destinationDevice, numbers, timeout); err != nil {
+		return err
 	}
 
+	log.Infof("Partitions %v of disk [%s] are ready", numbers, destinationDevice)
+
 	return nil
 }
 
-func findDecompressor(imageURL string, r io.Reader) (out io.Reader, err error) {
-	switch filepath.Ext(imageURL) {
-	case ".bzip2":
+// compressionSuffixes are the compression formats of the suffixes of images.
+var compressionSuffixes = map[string]string{
+	".bzip2": "bzip2",
+	".gz":    "gzip",
+	".xz":    "xz",
+	".zst":   "zstd",
+	".zs":    "zstd",
+	".lz4":   "lz4",
+}
+
+// compressionMagics are the magic bytes that streams of each compression format start with.
+var compressionMagics = []struct {
+	format string
+	magic  []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"bzip2", []byte("BZh")},
+	{"lz4", []byte{0x04, 0x22, 0x4d, 0x18}},
+}
+
+// sniffCompression returns the compression format that the magic bytes at the start of r are of, or
+// an empty string when they aren't of any.
+func sniffCompression(r *bufio.Reader) string {
+	// A short stream peeks as much as it has, which doesn't match a longer magic
+	head, _ := r.Peek(6)
+	for _, m := range compressionMagics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.format
+		}
+	}
+
+	return ""
+}
+
+// gzipBlockSize is the size of the blocks that gzip images are read ahead in, and checksummed, while
+// they are inflated.
+const gzipBlockSize = 1 << 20
+
+// findDecompressor returns a reader that decompresses r on up to threads cores, or all of them when
+// it is 0 or less. The compression format is format when it is set, otherwise it is found from the
+// magic bytes at the start of r, then from the suffix of the image when they aren't of a known format.
+func findDecompressor(imageURL, format string, threads int, r io.Reader) (out io.Reader, err error) {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	br := bufio.NewReader(r)
+	if format == "" {
+		format = sniffCompression(br)
+	}
+	if format == "" {
+		format = compressionSuffixes[filepath.Ext(imageURL)]
+	}
+
+	switch format {
+	case "bzip2", "bz2":
 		// With compression run data through gzip writer
-		bzipOUT := bzip2.NewReader(r)
+		bzipOUT := bzip2.NewReader(br)
 		out = bzipOUT
-	case ".gz":
+	case "gzip", "gz":
+		if threads > 1 {
+			// The stream is read ahead and its checksum computed on other cores than it is inflated on
+			zipOUT, gzErr := pgzip.NewReaderN(br, gzipBlockSize, 2*threads)
+			if gzErr != nil {
+				err = fmt.Errorf("[ERROR] New gzip reader: %w", gzErr)
+				return
+			}
+			out = zipOUT
+			break
+		}
 		// With compression run data through gzip writer
-		zipOUT, gzErr := gzip.NewReader(r)
+		zipOUT, gzErr := gzip.NewReader(br)
 		if gzErr != nil {
 			err = fmt.Errorf("[ERROR] New gzip reader: %w", gzErr)
 			return
 		}
-		defer zipOUT.Close()
 		out = zipOUT
-	case ".xz":
-		xzOUT, xzErr := xz.NewReader(r)
+	case "xz":
+		xzOUT, xzErr := xz.NewReader(br)
 		if xzErr != nil {
 			err = fmt.Errorf("[ERROR] New xz reader: %w", xzErr)
 			return
@@ -146,16 +491,20 @@ func findDecompressor(imageURL string, r io.Reader) (out io.Reader, err error) {
 		// The xz reader doesn't implement close()
 		// defer xzOUT.Close()
 		out = xzOUT
-	case ".zs":
-		zsOUT, zsErr := zstd.NewReader(r)
+	case "zstd", "zst":
+		zsOUT, zsErr := zstd.NewReader(br, zstd.WithDecoderConcurrency(threads))
 		if zsErr != nil {
-			err = fmt.Errorf("[ERROR] New zs reader: %w", zsErr)
+			err = fmt.Errorf("[ERROR] New zstd reader: %w", zsErr)
 			return
 		}
-		defer zsOUT.Close()
+		// The decoder can't be closed here as it is read once this returns
 		out = zsOUT
-	default:
+	case "lz4":
+		out = lz4.NewReader(br)
+	case "":
 		err = fmt.Errorf("unknown compression suffix [%s]", filepath.Ext(imageURL))
+	default:
+		err = fmt.Errorf("unknown compression format [%s]", format)
 	}
 	return out, err
 }
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"compress/gzip"
 	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 	"github.com/ulikunitz/xz"
 )
 
@@ -42,10 +45,53 @@ func xzReader(t *testing.T) io.Reader {
 	return rdata
 }
 
+func zstdReader(t *testing.T) io.Reader {
+	t.Helper()
+
+	var b bytes.Buffer
+	zsW, err := zstd.NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zsW.Write([]byte("YourDataHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zsW.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rdata := strings.NewReader(b.String())
+
+	return rdata
+}
+
+func lz4Reader(t *testing.T) io.Reader {
+	t.Helper()
+
+	var b bytes.Buffer
+	lz4W := lz4.NewWriter(&b)
+	if _, err := lz4W.Write([]byte("YourDataHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := lz4W.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rdata := strings.NewReader(b.String())
+
+	return rdata
+}
+
+func rawReader(t *testing.T) io.Reader {
+	t.Helper()
+
+	return strings.NewReader("YourDataHere")
+}
+
 func Test_findDecompressor(t *testing.T) {
 	tests := []struct {
 		name     string
 		imageURL string
+		format   string
+		threads  int
 		reader   func(*testing.T) io.Reader
 		wantOut  io.Reader
 		wantErr  bool
@@ -53,6 +99,8 @@ func Test_findDecompressor(t *testing.T) {
 		{
 			"tar gzip",
 			"http://192.168.0.1/a.tar.gz",
+			"",
+			0,
 			gzipReader,
 			nil,
 			false,
@@ -60,6 +108,8 @@ func Test_findDecompressor(t *testing.T) {
 		{
 			"broken gzip",
 			"http://192.168.0.1/a.gz",
+			"gzip",
+			0,
 			xzReader,
 			nil,
 			true,
@@ -67,26 +117,122 @@ func Test_findDecompressor(t *testing.T) {
 		{
 			"xz",
 			"http://192.168.0.1/a.xz",
+			"",
+			0,
 			xzReader,
 			nil,
 			false,
 		},
+		{
+			"zstd",
+			"http://192.168.0.1/a.img.zst",
+			"",
+			0,
+			zstdReader,
+			nil,
+			false,
+		},
+		{
+			"zstd short suffix",
+			"http://192.168.0.1/a.img.zs",
+			"",
+			0,
+			zstdReader,
+			nil,
+			false,
+		},
+		{
+			"lz4",
+			"http://192.168.0.1/a.img.lz4",
+			"",
+			0,
+			lz4Reader,
+			nil,
+			false,
+		},
 		{
 			"unknown",
 			"http://192.168.0.1/a.abc",
+			"",
+			0,
+			rawReader,
+			nil,
+			true,
+		},
+		{
+			"pre-signed url without a suffix",
+			"http://192.168.0.1/a?X-Amz-Signature=abc",
+			"",
+			0,
 			xzReader,
 			nil,
+			false,
+		},
+		{
+			"magic over suffix",
+			"http://192.168.0.1/a.gz",
+			"",
+			0,
+			zstdReader,
+			nil,
+			false,
+		},
+		{
+			"override",
+			"http://192.168.0.1/a.img",
+			"lz4",
+			0,
+			lz4Reader,
+			nil,
+			false,
+		},
+		{
+			"unknown override",
+			"http://192.168.0.1/a.img",
+			"rar",
+			0,
+			gzipReader,
+			nil,
 			true,
 		},
+		{
+			"gzip on a single core",
+			"http://192.168.0.1/a.gz",
+			"",
+			1,
+			gzipReader,
+			nil,
+			false,
+		},
+		{
+			"zstd on two cores",
+			"http://192.168.0.1/a.zst",
+			"",
+			2,
+			zstdReader,
+			nil,
+			false,
+		},
 		// TODO: Add test cases.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := findDecompressor(tt.imageURL, tt.reader(t))
+			out, err := findDecompressor(tt.imageURL, tt.format, tt.threads, tt.reader(t))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("findDecompressor() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if err != nil {
+				return
+			}
+			data, err := ioutil.ReadAll(out)
+			if err != nil {
+				t.Errorf("reading decompressed data: %v", err)
+				return
+			}
+			if string(data) != "YourDataHere" {
+				t.Errorf("findDecompressor() data = %q, want %q", data, "YourDataHere")
+			}
 		})
 	}
 }
@@ -0,0 +1,100 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Target is an image of a manifest and the disk that it is written to. The checksums, signature and
+// bmap are of that image alone, the rest of the options of the write are shared by all of them.
+type Target struct {
+	Image        string   `json:"image"`
+	Disk         string   `json:"disk"`
+	Compressed   bool     `json:"compressed"`
+	SHA256       string   `json:"sha256"`
+	SHA512       string   `json:"sha512"`
+	SignatureURL string   `json:"signature_url"`
+	BmapURL      string   `json:"bmap_url"`
+	Mirrors      []string `json:"mirrors"`
+}
+
+// ParseManifest parses a JSON manifest, which is a list of targets each written to its own disk.
+func ParseManifest(manifest string) ([]Target, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(manifest)))
+	dec.DisallowUnknownFields()
+
+	var targets []Target
+	if err := dec.Decode(&targets); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest -> %w", err)
+	}
+
+	if len(targets) == 0 {
+		return nil, errors.New("manifest has no images")
+	}
+
+	disks := map[string]bool{}
+	for i, t := range targets {
+		if t.Image == "" || t.Disk == "" {
+			return nil, fmt.Errorf("image %d of the manifest needs both an image and a disk", i+1)
+		}
+		if disks[t.Disk] {
+			return nil, fmt.Errorf("disk [%s] is in the manifest more than once", t.Disk)
+		}
+		disks[t.Disk] = true
+	}
+
+	return targets, nil
+}
+
+// WriteAll writes each of the targets to its disk, one after the other or all at once when parallel
+// is set. All of them are written even when one fails, the errors of all that failed are returned.
+func WriteAll(targets []Target, opts Options, parallel bool) error {
+	errs := make([]error, len(targets))
+
+	write := func(i int) {
+		t := targets[i]
+
+		o := opts
+		o.SHA256, o.SHA512 = t.SHA256, t.SHA512
+		o.SignatureURL, o.BmapURL = t.SignatureURL, t.BmapURL
+		o.Mirrors = t.Mirrors
+
+		if errs[i] = Write(t.Image, t.Disk, t.Compressed, o); errs[i] == nil {
+			log.Infof("Successfully written [%s] to [%s]", RedactURL(t.Image), t.Disk)
+		}
+	}
+
+	if parallel {
+		var wg sync.WaitGroup
+		for i := range targets {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				write(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range targets {
+			write(i)
+		}
+	}
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("[%s] -> [%s]: %v", RedactURL(targets[i].Image), targets[i].Disk, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to write %d of %d images: %s", len(failed), len(targets), strings.Join(failed, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     int
+		wantErr  bool
+	}{
+		{"two disks", `[{"image": "http://192.168.1.2/os.raw.gz", "disk": "/dev/nvme0n1", "compressed": true}, {"image": "http://192.168.1.2/data.raw", "disk": "/dev/sdb"}]`, 2, false},
+		{"empty", `[]`, 0, true},
+		{"missing disk", `[{"image": "http://192.168.1.2/os.raw.gz"}]`, 0, true},
+		{"same disk twice", `[{"image": "http://192.168.1.2/os.raw", "disk": "/dev/sda"}, {"image": "http://192.168.1.2/data.raw", "disk": "/dev/sda"}]`, 0, true},
+		{"unknown field", `[{"image": "http://192.168.1.2/os.raw", "disk": "/dev/sda", "dest": "/dev/sdb"}]`, 0, true},
+		{"not json", `image: os.raw`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseManifest(tt.manifest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ParseManifest() returned %d targets, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write([]byte("YourOSHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/os.raw.gz":
+			w.Write(compressed.Bytes())
+		case "/data.raw":
+			w.Write([]byte("YourDataHere"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		parallel bool
+		missing  bool
+		wantErr  bool
+	}{
+		{"sequential", false, false, false},
+		{"parallel", true, false, false},
+		{"one missing", true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			targets := []Target{
+				{Image: server.URL + "/os.raw.gz?X-Amz-Signature=secret", Disk: filepath.Join(dir, "os"), Compressed: true},
+				{Image: server.URL + "/data.raw", Disk: filepath.Join(dir, "data")},
+			}
+			if tt.missing {
+				targets[1].Image = server.URL + "/missing.raw?X-Amz-Signature=secret"
+			}
+			for _, target := range targets {
+				if err := ioutil.WriteFile(target.Disk, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			err := WriteAll(targets, Options{}, tt.parallel)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "secret") {
+				t.Errorf("WriteAll() error = %v, want the query of the image URLs redacted", err)
+			}
+			if strings.Contains(logs.String(), "secret") {
+				t.Errorf("WriteAll() logged the query of an image URL:\n%s", logs.String())
+			}
+
+			// The images that could be written are written, whether or not another fails
+			written, err := ioutil.ReadFile(targets[0].Disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(written) != "YourOSHere" {
+				t.Errorf("WriteAll() wrote %q, want %q", written, "YourOSHere")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// metricsTimeout bounds posting the metrics of a write, so that a dead endpoint doesn't hold up the
+// action once the image is written.
+const metricsTimeout = 10 * time.Second
+
+// metrics records how a write went, so that how long imaging takes can be tracked across machines.
+type metrics struct {
+	image, disk string
+	start       time.Time
+	// client and progress are set once the write gets to them, and digest is fed the image as it
+	// is downloaded.
+	client   *client
+	progress *progress
+	digest   hash.Hash
+}
+
+func newMetrics(sourceImage, destinationDevice string) *metrics {
+	return &metrics{
+		image:  sourceImage,
+		disk:   destinationDevice,
+		start:  time.Now(),
+		digest: sha256.New(),
+	}
+}
+
+// metricsRecord is the JSON record that is posted for a write.
+type metricsRecord struct {
+	Hostname        string  `json:"hostname"`
+	Image           string  `json:"image"`
+	Disk            string  `json:"disk"`
+	Success         bool    `json:"success"`
+	Error           string  `json:"error,omitempty"`
+	BytesDownloaded uint64  `json:"bytes_downloaded"`
+	BytesWritten    uint64  `json:"bytes_written"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	ThroughputMBps  float64 `json:"throughput_mbps"`
+	Retries         uint64  `json:"retries"`
+	// ImageSHA256 is the digest of the image as it was downloaded, it is only set for a successful
+	// write as the image may not have been downloaded in full otherwise.
+	ImageSHA256 string `json:"image_sha256,omitempty"`
+}
+
+// record returns the record of the write, which failed with err unless it is nil.
+func (m *metrics) record(err error, elapsed time.Duration) metricsRecord {
+	r := metricsRecord{
+		Image:           RedactURL(m.image),
+		Disk:            m.disk,
+		Success:         err == nil,
+		DurationSeconds: elapsed.Seconds(),
+	}
+	r.Hostname, _ = os.Hostname()
+
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.ImageSHA256 = hex.EncodeToString(m.digest.Sum(nil))
+	}
+	if m.client != nil {
+		r.Retries = atomic.LoadUint64(&m.client.retries)
+	}
+	if m.progress != nil {
+		r.BytesDownloaded, r.BytesWritten = m.progress.downloaded.Count(), m.progress.written.Count()
+	}
+	if elapsed > 0 {
+		r.ThroughputMBps = float64(r.BytesWritten) / elapsed.Seconds() / 1e6
+	}
+
+	return r
+}
+
+// report logs the record of the write and posts it to url. Failing to post it is only logged, as the
+// write itself is done by then.
+func (m *metrics) report(url string, err error) {
+	data, jsonErr := json.Marshal(m.record(err, time.Since(m.start)))
+	if jsonErr != nil {
+		log.Warnf("Failed to encode the metrics of the write -> %v", jsonErr)
+		return
+	}
+	log.Infof("Metrics of the write of [%s]: %s", filepath.Base(RedactURL(m.image)), data)
+
+	if postErr := postMetrics(url, data); postErr != nil {
+		log.Warnf("Failed to post the metrics of the write to [%s] -> %v", RedactURL(url), redactError(postErr))
+	}
+}
+
+func postMetrics(url string, data []byte) error {
+	c := &http.Client{Timeout: metricsTimeout}
+
+	resp, err := c.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
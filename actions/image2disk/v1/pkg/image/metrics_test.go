@@ -0,0 +1,60 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	image := []byte("YourDataHere")
+	sum := sha256.Sum256(image)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(image)
+	}))
+	defer server.Close()
+
+	records := make(chan metricsRecord, 2)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rec metricsRecord
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&rec) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		records <- rec
+	}))
+	defer endpoint.Close()
+
+	disk := filepath.Join(t.TempDir(), "disk")
+	if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Write(server.URL+"/image.raw?sig=secret", disk, false, Options{MetricsURL: endpoint.URL}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	rec := <-records
+	if !rec.Success || rec.BytesWritten != uint64(len(image)) || rec.BytesDownloaded != uint64(len(image)) {
+		t.Errorf("Write() reported %+v, want a successful write of %d bytes", rec, len(image))
+	}
+	if rec.ImageSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Write() reported image digest %q, want %q", rec.ImageSHA256, hex.EncodeToString(sum[:]))
+	}
+	if want := server.URL + "/image.raw?sig=redacted"; rec.Image != want {
+		t.Errorf("Write() reported image %q, want %q", rec.Image, want)
+	}
+
+	if err := Write(server.URL+"/image.raw", disk+".missing", false, Options{MetricsURL: endpoint.URL}); err == nil {
+		t.Fatal("Write() to a missing disk succeeded")
+	}
+	rec = <-records
+	if rec.Success || rec.Error == "" || rec.ImageSHA256 != "" {
+		t.Errorf("Write() reported %+v, want a failed write", rec)
+	}
+}
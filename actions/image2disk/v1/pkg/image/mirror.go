@@ -0,0 +1,56 @@
+package image
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// getMirrors requests the first of urls that responds, which are mirrors of the same image, and
+// returns its response along with its URL. Each mirror is tried once in order, and those that failed
+// with transient errors are tried again as the client is set to retry. How each mirror did is logged
+// so that flaky mirrors can be spotted.
+func (c *client) getMirrors(urls []string) (*http.Response, string, error) {
+	if len(urls) == 1 {
+		resp, err := c.getWithRetry(urls[0])
+		return resp, urls[0], err
+	}
+
+	remaining := urls
+	failed := map[string]error{}
+
+	for retry := 0; len(remaining) > 0 && retry <= c.retry.retries; retry++ {
+		if retry > 0 {
+			log.Warnf("No mirror of the image responded, retrying %d of them in %s (%d/%d)", len(remaining), c.retry.delay(retry), retry, c.retry.retries)
+			time.Sleep(c.retry.delay(retry))
+			c.retried()
+		}
+
+		var transient []string
+		for _, url := range remaining {
+			start := time.Now()
+			resp, err := c.get(url)
+			if err == nil {
+				log.Infof("Mirror [%s] responded in %s", RedactURL(url), time.Since(start).Round(time.Millisecond))
+				return resp, url, nil
+			}
+
+			log.Warnf("Mirror [%s] failed after %s: %v", RedactURL(url), time.Since(start).Round(time.Millisecond), err)
+			failed[url] = err
+			if isTransient(err) {
+				transient = append(transient, url)
+			}
+		}
+		remaining = transient
+	}
+
+	errs := make([]string, 0, len(urls))
+	for _, url := range urls {
+		errs = append(errs, fmt.Sprintf("[%s]: %v", RedactURL(url), failed[url]))
+	}
+
+	return nil, "", fmt.Errorf("failed to download the image from any of its %d mirrors -> %s", len(urls), strings.Join(errs, "; "))
+}
@@ -0,0 +1,74 @@
+package image
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_client_getMirrors(t *testing.T) {
+	var flakyRequests int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&flakyRequests, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("YourDataHere"))
+	}))
+	defer flaky.Close()
+
+	var missingRequests int32
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&missingRequests, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer missing.Close()
+
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("YourDataHere"))
+	}))
+	defer ok.Close()
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	c, err := newClient(Options{Retries: 2, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		urls    []string
+		want    string
+		wantErr bool
+	}{
+		{"first responds", []string{ok.URL, dead.URL}, ok.URL, false},
+		{"dead and missing mirrors are skipped", []string{dead.URL, missing.URL, ok.URL}, ok.URL, false},
+		{"flaky mirror is retried", []string{missing.URL, flaky.URL}, flaky.URL, false},
+		{"no mirror responds", []string{dead.URL, missing.URL}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, url, err := c.getMirrors(tt.urls)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getMirrors() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+
+			if url != tt.want {
+				t.Errorf("getMirrors() = %q, want %q", url, tt.want)
+			}
+		})
+	}
+
+	// Each round only retries the mirrors that failed with transient errors
+	if n := atomic.LoadInt32(&missingRequests); n != 3 {
+		t.Errorf("missing mirror was requested %d times, want once per test that reached it", n)
+	}
+}
@@ -0,0 +1,191 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultPartitionTimeout is how long to wait for the partitions of the image to appear.
+const DefaultPartitionTimeout = 30 * time.Second
+
+// rereadAttempts is how many times the partition table is re-read while the disk is busy.
+const rereadAttempts = 5
+
+// devDir is where the device nodes of the partitions appear.
+var devDir = "/dev"
+
+// errNotPartitionable is returned when the disk has no partition table the kernel can re-read, such
+// as when the image was written to a partition or a file.
+var errNotPartitionable = errors.New("device can't be partitioned")
+
+// rereadPartitions asks the kernel to re-read the partition table of disk, the equivalent of
+// partprobe. It retries while the disk is busy, as it can be just after it is written.
+func rereadPartitions(disk *os.File) error {
+	var err error
+
+	for attempt := 1; attempt <= rereadAttempts; attempt++ {
+		if err = unix.IoctlSetInt(int(disk.Fd()), unix.BLKRRPART, 0); err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, unix.EBUSY) {
+			if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTTY) {
+				return errNotPartitionable
+			}
+			return err
+		}
+
+		log.Warnf("Disk [%s] is busy re-reading its partition table, attempt %d", disk.Name(), attempt)
+		time.Sleep(time.Duration(attempt) * 200 * time.Millisecond)
+	}
+
+	return err
+}
+
+// partitionNumbers returns the numbers of the partitions in the GPT or MBR partition table of disk.
+func partitionNumbers(disk *os.File) ([]int, error) {
+	sectorSize, err := unix.IoctlGetInt(int(disk.Fd()), unix.BLKSSZGET)
+	if err != nil || sectorSize <= 0 {
+		sectorSize = 512
+	}
+
+	mbr := make([]byte, 512)
+	if _, err := disk.ReadAt(mbr, 0); err != nil {
+		return nil, fmt.Errorf("failed to read the partition table -> %w", err)
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return nil, nil
+	}
+
+	// Like the kernel, a boot sector whose entries don't all have a valid boot indicator, such as
+	// that of a filesystem, has no partition table
+	for i := 0; i < 4; i++ {
+		if boot := mbr[446+i*16]; boot != 0x00 && boot != 0x80 {
+			return nil, nil
+		}
+	}
+
+	size, err := disk.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the size of the disk -> %w", err)
+	}
+	sectors := uint64(size) / uint64(sectorSize)
+
+	var numbers []int
+	for i := 0; i < 4; i++ {
+		entry := mbr[446+i*16 : 446+(i+1)*16]
+		start := uint64(binary.LittleEndian.Uint32(entry[8:12]))
+		length := uint64(binary.LittleEndian.Uint32(entry[12:16]))
+
+		switch {
+		case entry[4] == 0x00:
+		case entry[4] == 0xee:
+			// A protective MBR, the partitions are in the GPT
+			return gptPartitionNumbers(disk, int64(sectorSize))
+		case length == 0, start == 0, start >= sectors:
+			// The kernel skips empty entries and those starting beyond the end of the disk
+		default:
+			numbers = append(numbers, i+1)
+		}
+	}
+
+	return numbers, nil
+}
+
+// gptPartitionNumbers returns the numbers of the partitions in the GPT of disk, which are the index
+// of their entry in the partition table counting from 1.
+func gptPartitionNumbers(disk *os.File, sectorSize int64) ([]int, error) {
+	header := make([]byte, 92)
+	if _, err := disk.ReadAt(header, sectorSize); err != nil {
+		return nil, fmt.Errorf("failed to read the GPT header -> %w", err)
+	}
+	if !bytes.Equal(header[:8], []byte("EFI PART")) {
+		return nil, errors.New("protective MBR without a GPT header")
+	}
+
+	entriesLBA := int64(binary.LittleEndian.Uint64(header[72:80]))
+	count := binary.LittleEndian.Uint32(header[80:84])
+	entrySize := binary.LittleEndian.Uint32(header[84:88])
+	if entrySize < 16 || count > 1024 {
+		return nil, fmt.Errorf("invalid GPT with %d entries of %d bytes", count, entrySize)
+	}
+
+	entries := make([]byte, int64(count)*int64(entrySize))
+	if _, err := disk.ReadAt(entries, entriesLBA*sectorSize); err != nil {
+		return nil, fmt.Errorf("failed to read the GPT entries -> %w", err)
+	}
+
+	var numbers []int
+	for i := 0; i < int(count); i++ {
+		typeGUID := entries[i*int(entrySize) : i*int(entrySize)+16]
+		if !bytes.Equal(typeGUID, make([]byte, 16)) {
+			numbers = append(numbers, i+1)
+		}
+	}
+
+	return numbers, nil
+}
+
+// partitionName returns the name of partition n of the disk named disk, such as sda1 or nvme0n1p1.
+func partitionName(disk string, n int) string {
+	if r := []rune(disk); len(r) > 0 && unicode.IsDigit(r[len(r)-1]) {
+		return fmt.Sprintf("%sp%d", disk, n)
+	}
+
+	return fmt.Sprintf("%s%d", disk, n)
+}
+
+// waitForPartitions waits up to timeout for the kernel to list the partitions numbers of disk and
+// for their device nodes to appear.
+func waitForPartitions(disk string, numbers []int, timeout time.Duration) error {
+	name := filepath.Base(disk)
+	if resolved, err := filepath.EvalSymlinks(disk); err == nil {
+		name = filepath.Base(resolved)
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		var missingKernel, missingNode []string
+		for _, n := range numbers {
+			part := partitionName(name, n)
+			if _, err := os.Stat(filepath.Join(sysBlock, part)); err != nil {
+				missingKernel = append(missingKernel, part)
+			} else if _, err := os.Stat(filepath.Join(devDir, part)); err != nil {
+				missingNode = append(missingNode, part)
+			}
+		}
+
+		if len(missingKernel) == 0 && len(missingNode) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if len(missingKernel) == len(numbers) {
+				// The partition table was read wrong, or the kernel doesn't support it, rather than
+				// the partitions being slow to appear
+				log.Warnf("Kernel lists none of the partitions %v of disk [%s] after %s", missingKernel, disk, timeout)
+				return nil
+			}
+			if len(missingKernel) > 0 {
+				return fmt.Errorf("partitions %v of disk [%s] didn't appear within %s", missingKernel, disk, timeout)
+			}
+
+			// The kernel knows of the partitions, the nodes appear in the containers of later actions
+			log.Warnf("Device nodes of partitions %v of disk [%s] didn't appear within %s", missingNode, disk, timeout)
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,137 @@
+package image
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_partitionName(t *testing.T) {
+	tests := []struct {
+		disk string
+		n    int
+		want string
+	}{
+		{"sda", 1, "sda1"},
+		{"vdb", 12, "vdb12"},
+		{"nvme0n1", 2, "nvme0n1p2"},
+		{"mmcblk0", 1, "mmcblk0p1"},
+	}
+	for _, tt := range tests {
+		if got := partitionName(tt.disk, tt.n); got != tt.want {
+			t.Errorf("partitionName(%q, %d) = %q, want %q", tt.disk, tt.n, got, tt.want)
+		}
+	}
+}
+
+func Test_partitionNumbers(t *testing.T) {
+	// mbr returns a disk of 128 sectors with an MBR of partitions of types, each of 8 sectors
+	mbr := func(types ...byte) []byte {
+		disk := make([]byte, 64<<10)
+		for i, typ := range types {
+			entry := disk[446+i*16:]
+			entry[4] = typ
+			binary.LittleEndian.PutUint32(entry[8:], uint32(8+i*8))
+			binary.LittleEndian.PutUint32(entry[12:], 8)
+		}
+		disk[510], disk[511] = 0x55, 0xaa
+		return disk
+	}
+
+	bootable := mbr(0x83, 0x83)
+	bootable[446] = 0x80
+
+	// A FAT boot sector has the signature of an MBR, but its entries are code and data
+	fat := mbr(0x83)
+	copy(fat[446:], "invalid boot indicators")
+
+	empty := mbr(0x83, 0x83)
+	binary.LittleEndian.PutUint32(empty[446+16+12:], 0)
+
+	beyond := mbr(0x83, 0x83)
+	binary.LittleEndian.PutUint32(beyond[446+16+8:], 128)
+
+	gpt := mbr(0xee)
+	copy(gpt[512:], "EFI PART")
+	binary.LittleEndian.PutUint64(gpt[512+72:], 2)
+	binary.LittleEndian.PutUint32(gpt[512+80:], 128)
+	binary.LittleEndian.PutUint32(gpt[512+84:], 128)
+	// Entries 1, 2 and 5 are used
+	for _, i := range []int{0, 1, 4} {
+		gpt[1024+i*128] = 0xaf
+	}
+
+	tests := []struct {
+		name string
+		disk []byte
+		want []int
+	}{
+		{"mbr", mbr(0x83, 0x82, 0x00, 0x83), []int{1, 2, 4}},
+		{"gpt", gpt, []int{1, 2, 5}},
+		{"no partition table", make([]byte, 4096), nil},
+		{"bootable", bootable, []int{1, 2}},
+		{"invalid boot indicator", fat, nil},
+		{"empty entry", empty, []int{1}},
+		{"entry beyond the end of the disk", beyond, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(path, tt.disk, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			got, err := partitionNumbers(f)
+			if err != nil {
+				t.Fatalf("partitionNumbers() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("partitionNumbers() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("partitionNumbers() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_waitForPartitions(t *testing.T) {
+	defer func(sys, dev string) { sysBlock, devDir = sys, dev }(sysBlock, devDir)
+	sysBlock, devDir = t.TempDir(), t.TempDir()
+
+	for _, dir := range []string{filepath.Join(sysBlock, "nvme0n1p1"), filepath.Join(devDir, "nvme0n1p1")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second partition appears after a while
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		os.MkdirAll(filepath.Join(sysBlock, "nvme0n1p2"), 0o755)
+		os.MkdirAll(filepath.Join(devDir, "nvme0n1p2"), 0o755)
+	}()
+
+	if err := waitForPartitions("/dev/nvme0n1", []int{1, 2}, 5*time.Second); err != nil {
+		t.Errorf("waitForPartitions() error = %v", err)
+	}
+
+	if err := waitForPartitions("/dev/nvme0n1", []int{1, 3}, 200*time.Millisecond); err == nil {
+		t.Errorf("waitForPartitions() returned no error for a partition that doesn't appear")
+	}
+
+	// The kernel listing none of the partitions only warns
+	if err := waitForPartitions("/dev/nvme0n1", []int{3, 4}, 200*time.Millisecond); err != nil {
+		t.Errorf("waitForPartitions() error = %v for partitions the kernel lists none of", err)
+	}
+}
@@ -0,0 +1,85 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultProgressInterval is how often the progress of a write is logged when no interval is set.
+const defaultProgressInterval = 10 * time.Second
+
+// progress reports how far writing an image has got. The percentage and ETA are of the download
+// against its Content-Length, as a compressed image decompresses to an unknown size.
+type progress struct {
+	image string
+	// size is the Content-Length of the download, or 0 or less when it is unknown.
+	size       int64
+	downloaded *WriteCounter
+	written    *WriteCounter
+	start      time.Time
+}
+
+func newProgress(sourceImage string, size int64) *progress {
+	return &progress{
+		image:      imageName(sourceImage),
+		size:       size,
+		downloaded: &WriteCounter{},
+		written:    &WriteCounter{},
+		start:      time.Now(),
+	}
+}
+
+// run logs the progress every interval until the returned function is called.
+func (p *progress) run(interval time.Duration) func() {
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				log.Info(p.status(time.Since(p.start)))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// status describes the progress after elapsed time.
+func (p *progress) status(elapsed time.Duration) string {
+	downloaded, written := p.downloaded.Count(), p.written.Count()
+
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(written) / elapsed.Seconds()
+	}
+
+	parts := []string{fmt.Sprintf("Written %s of [%s] at %.1f MB/s", humanize.Bytes(written), p.image, rate/1e6)}
+
+	if p.size > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f%% of %s downloaded", float64(downloaded)*100/float64(p.size), humanize.Bytes(uint64(p.size))))
+
+		if downloaded > 0 && downloaded < uint64(p.size) {
+			remaining := time.Duration(float64(elapsed) * float64(uint64(p.size)-downloaded) / float64(downloaded))
+			parts = append(parts, fmt.Sprintf("ETA %s", remaining.Round(time.Second)))
+		}
+	} else {
+		parts = append(parts, fmt.Sprintf("%s downloaded", humanize.Bytes(downloaded)))
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,33 @@
+package image
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_progress_status(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int64
+		downloaded uint64
+		written    uint64
+		elapsed    time.Duration
+		want       string
+	}{
+		{"started", 1000000, 0, 0, 0, "Written 0 B of [image.raw.gz] at 0.0 MB/s, 0.0% of 1.0 MB downloaded"},
+		{"half way", 1000000, 500000, 2000000, 2 * time.Second, "Written 2.0 MB of [image.raw.gz] at 1.0 MB/s, 50.0% of 1.0 MB downloaded, ETA 2s"},
+		{"done", 1000000, 1000000, 4000000, 4 * time.Second, "Written 4.0 MB of [image.raw.gz] at 1.0 MB/s, 100.0% of 1.0 MB downloaded"},
+		{"unknown size", -1, 500000, 2000000, 2 * time.Second, "Written 2.0 MB of [image.raw.gz] at 1.0 MB/s, 500 kB downloaded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProgress("http://192.168.1.2/image.raw.gz?X-Amz-Signature=secret", tt.size)
+			p.downloaded.Total = tt.downloaded
+			p.written.Total = tt.written
+
+			if got := p.status(tt.elapsed); got != tt.want {
+				t.Errorf("status() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package image
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+)
+
+// RedactURL returns rawURL with the password of its user info and the values of its query redacted,
+// so that the URLs of images, which can be pre-signed, can be logged.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<unparseable url>"
+	}
+
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query.Set(key, "redacted")
+		}
+
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
+
+// imageName returns the file name of the image at sourceImage for logging, leaving out the query of a
+// pre-signed URL along with the rest of the URL.
+func imageName(sourceImage string) string {
+	u, err := url.Parse(sourceImage)
+	if err != nil || u.Path == "" {
+		return filepath.Base(RedactURL(sourceImage))
+	}
+
+	return filepath.Base(u.Path)
+}
+
+// redactError redacts the URL of the request that err failed, which the HTTP client includes in its
+// errors.
+func redactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = RedactURL(urlErr.URL)
+	}
+
+	return err
+}
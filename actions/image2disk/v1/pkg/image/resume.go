@@ -0,0 +1,160 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errImageChanged is returned when the image changed since its download started, so that the
+// download can't be resumed.
+var errImageChanged = errors.New("the image changed since the download started")
+
+// resumableBody reads the body of an image download and, when the connection fails part way,
+// reconnects with a Range request to continue from the byte it stopped at. As the download
+// continues where it stopped, whatever reads it carries on writing at the right offset.
+type resumableBody struct {
+	client *client
+	url    string
+	body   io.ReadCloser
+	offset int64
+	// validator is the ETag or Last-Modified of the image, so that a resumed download fails rather
+	// than mixing two versions of the image.
+	validator string
+	// resumable is set when the server accepts Range requests for the image.
+	resumable bool
+}
+
+// newResumableBody returns the body of resp, the response to downloading url.
+func newResumableBody(c *client, url string, resp *http.Response) *resumableBody {
+	return &resumableBody{
+		client:    c,
+		url:       url,
+		body:      resp.Body,
+		validator: rangeValidator(resp),
+		resumable: acceptsRanges(resp),
+	}
+}
+
+// rangeValidator returns the ETag or Last-Modified of resp, which later Range requests send as
+// If-Range to make sure that they get the same version of the image.
+func rangeValidator(resp *http.Response) string {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		// A weak ETag can't be used with If-Range
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	return validator
+}
+
+// acceptsRanges reports whether the server that sent resp accepts Range requests.
+func acceptsRanges(resp *http.Response) bool {
+	return resp.Header.Get("Accept-Ranges") == "bytes"
+}
+
+func (b *resumableBody) Read(p []byte) (int, error) {
+	for {
+		n, err := b.body.Read(p)
+		b.offset += int64(n)
+
+		if err == nil || err == io.EOF || !b.resumable {
+			return n, err
+		}
+
+		log.Warnf("Download of [%s] interrupted after %d bytes: %v", RedactURL(b.url), b.offset, err)
+
+		if resumeErr := b.resume(); resumeErr != nil {
+			return n, fmt.Errorf("%w, and resuming the download failed -> %v", err, resumeErr)
+		}
+
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume reconnects to continue the download from offset, trying up to as many times as the client
+// retries. The retries start over whenever the download makes progress again.
+func (b *resumableBody) resume() error {
+	b.body.Close()
+
+	err := errors.New("no retries left")
+
+	for attempt := 1; attempt <= b.client.retry.retries; attempt++ {
+		time.Sleep(b.client.retry.delay(attempt))
+		b.client.retried()
+
+		var resp *http.Response
+		if resp, err = b.client.getRange(context.TODO(), b.url, b.validator, b.offset, -1); err == nil {
+			log.Infof("Resumed download of [%s] at %d bytes", RedactURL(b.url), b.offset)
+			b.body = resp.Body
+
+			return nil
+		}
+
+		if !isTransient(err) {
+			break
+		}
+
+		log.Warnf("Attempt %d to resume download of [%s] failed: %v", attempt, RedactURL(b.url), err)
+	}
+
+	// Close is still called on the body once the download is given up on
+	b.body = ioutil.NopCloser(strings.NewReader(""))
+
+	return err
+}
+
+// getRange requests the bytes of the image at url from start to end inclusive, or to the end of the
+// image when end is negative. The response must be of the version of the image that validator is of.
+func (c *client) getRange(ctx context.Context, url, validator string, start, end int64) (*http.Response, error) {
+	req, err := c.newRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	if end < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, redactError(err)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		resp.Body.Close()
+
+		// If-Range returns the whole image when it no longer matches the validator
+		return nil, errImageChanged
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, &statusError{code: resp.StatusCode, msg: fmt.Sprintf("%s, expected %d bytes onwards", resp.Status, start)}
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", start)) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected Content-Range [%s], expected %d bytes onwards", resp.Header.Get("Content-Range"), start)
+	}
+
+	return resp, nil
+}
+
+func (b *resumableBody) Close() error {
+	return b.body.Close()
+}
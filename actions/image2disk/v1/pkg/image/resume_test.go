@@ -0,0 +1,120 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer serves img, cutting the connection off after cutAfter bytes of each of the first
+// failures responses. Range requests are served by http.ServeContent.
+func flakyServer(t *testing.T, img []byte, cutAfter int, failures int32, acceptRanges bool, etag func() string) *httptest.Server {
+	t.Helper()
+
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptRanges && r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+
+		w.Header().Set("ETag", etag())
+
+		if atomic.AddInt32(&requests, 1) > failures {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(img))
+			return
+		}
+
+		start := 0
+		if rng := r.Header.Get("Range"); rng != "" {
+			// Only the "bytes=N-" form the resumed download uses
+			start, _ = strconv.Atoi(rng[len("bytes=") : len(rng)-1])
+			w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-"+strconv.Itoa(len(img)-1)+"/"+strconv.Itoa(len(img)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(img)-start))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			if acceptRanges {
+				w.Header().Set("Accept-Ranges", "bytes")
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(img)))
+		}
+
+		w.Write(img[start : start+cutAfter])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestWriteResume(t *testing.T) {
+	raw := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(raw)
+
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+	img := compressed.Bytes()
+	sum := sha256.Sum256(img)
+
+	fixedETag := func() string { return `"v1"` }
+	var version int32
+	changingETag := func() string { return `"v` + strconv.Itoa(int(atomic.AddInt32(&version, 1))) + `"` }
+
+	tests := []struct {
+		name         string
+		failures     int32
+		acceptRanges bool
+		etag         func() string
+		wantErr      bool
+	}{
+		{"uninterrupted", 0, true, fixedETag, false},
+		{"resumed once", 1, true, fixedETag, false},
+		{"resumed twice", 2, true, fixedETag, false},
+		{"no range support", 1, false, fixedETag, true},
+		{"image changed", 1, true, changingETag, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := flakyServer(t, img, len(img)/4, tt.failures, tt.acceptRanges, tt.etag)
+
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Write(server.URL+"/image.raw.gz", disk, true, Options{SHA256: hex.EncodeToString(sum[:]), Retries: 5, RetryBackoff: time.Millisecond})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			written, err := ioutil.ReadFile(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(written, raw) {
+				t.Errorf("Write() wrote %d bytes that don't match the %d byte image", len(written), len(raw))
+			}
+		})
+	}
+}
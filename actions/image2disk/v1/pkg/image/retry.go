@@ -0,0 +1,97 @@
+package image
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRetries and DefaultRetryBackoff are how often, and after how long at first, a failed
+// download is retried unless told otherwise. Retries are opt-in, so that a failed download fails the
+// write straight away as it always has.
+const (
+	DefaultRetries      = 0
+	DefaultRetryBackoff = time.Second
+)
+
+// maxRetryBackoff caps the delay between retries as it doubles.
+const maxRetryBackoff = time.Minute
+
+// retryPolicy is how often a failed request is retried, and how long to wait before each retry.
+type retryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+// delay returns the delay before the given retry, starting at 1. It doubles on each retry.
+func (r retryPolicy) delay(retry int) time.Duration {
+	d := r.backoff
+	for i := 1; i < retry && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+
+	return d
+}
+
+// statusError is returned for a response with an unexpected status.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// isTransient reports whether a request that failed with err may succeed when it is retried, which
+// is the case for server errors, rate limiting and errors of the connection other than TLS
+// verification failing.
+func isTransient(err error) bool {
+	if errors.Is(err, errImageChanged) {
+		return false
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500 || statusErr.code == http.StatusTooManyRequests || statusErr.code == http.StatusRequestTimeout
+	}
+
+	// A certificate that can't be verified won't be on a retry either
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalid          x509.CertificateInvalidError
+		hostname         x509.HostnameError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname) {
+		return false
+	}
+
+	return true
+}
+
+// getWithRetry requests url as get does, retrying it as the client is set to while it fails with
+// transient errors.
+func (c *client) getWithRetry(url string) (*http.Response, error) {
+	resp, err := c.get(url)
+
+	for retry := 1; err != nil && isTransient(err) && retry <= c.retry.retries; retry++ {
+		log.Warnf("Request for [%s] failed: %v, retrying in %s (%d/%d)", RedactURL(url), err, c.retry.delay(retry), retry, c.retry.retries)
+		time.Sleep(c.retry.delay(retry))
+		c.retried()
+
+		resp, err = c.get(url)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to download [%s] -> %w", RedactURL(url), err)
+	}
+
+	return resp, nil
+}
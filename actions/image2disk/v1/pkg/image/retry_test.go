@@ -0,0 +1,116 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_retryPolicy_delay(t *testing.T) {
+	policy := retryPolicy{retries: 10, backoff: 10 * time.Second}
+
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{10, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.delay(tt.retry); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.retry, got, tt.want)
+		}
+	}
+}
+
+func Test_isTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad gateway", &statusError{code: http.StatusBadGateway}, true},
+		{"too many requests", &statusError{code: http.StatusTooManyRequests}, true},
+		{"not found", &statusError{code: http.StatusNotFound}, false},
+		{"image changed", errImageChanged, false},
+		{"connection reset", errors.New("connection reset by peer"), true},
+		{"unknown authority", fmt.Errorf("Get: %w", x509.UnknownAuthorityError{}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteRetry(t *testing.T) {
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write([]byte("YourDataHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		status   int
+		failures int32
+		retries  int
+		wantErr  bool
+	}{
+		{"no failures", http.StatusBadGateway, 0, 0, false},
+		{"retried bad gateway", http.StatusBadGateway, 2, 3, false},
+		{"out of retries", http.StatusBadGateway, 4, 3, true},
+		{"not found is not retried", http.StatusNotFound, 1, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Write(compressed.Bytes())
+			}))
+			defer server.Close()
+
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Write(server.URL+"/image.raw.gz", disk, true, Options{Retries: tt.retries, RetryBackoff: time.Millisecond})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			written, err := ioutil.ReadFile(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(written) != "YourDataHere" {
+				t.Errorf("Write() wrote %q, want %q", written, "YourDataHere")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// Share is an NFS or SMB share that golden images are read from, it is mounted read only within
+// the action.
+type Share struct {
+	// Type is the filesystem of the share, nfs or cifs.
+	Type string
+	// Source is server:/export/path for NFS, or //server/share for SMB.
+	Source string
+	// Options are mount options of the filesystem, such as vers=4.1 or username=admin for SMB.
+	Options string
+}
+
+// mountArgs returns the source and data to mount the share with. The action has no mount helpers,
+// so the address of the server that they would normally resolve is passed to the kernel.
+func (s Share) mountArgs() (string, string, error) {
+	var server, source string
+	opts := []string{}
+
+	switch s.Type {
+	case "nfs":
+		i := strings.Index(s.Source, ":/")
+		if i <= 0 {
+			return "", "", fmt.Errorf("NFS share [%s] must be server:/export/path", s.Source)
+		}
+		server = strings.Trim(s.Source[:i], "[]")
+		source = s.Source
+		// There is no rpc.statd to take locks with in the action
+		opts = append(opts, "nolock")
+	case "cifs":
+		if !strings.HasPrefix(s.Source, "//") || len(strings.SplitN(s.Source[2:], "/", 2)) != 2 {
+			return "", "", fmt.Errorf("SMB share [%s] must be //server/share", s.Source)
+		}
+		server = strings.SplitN(s.Source[2:], "/", 2)[0]
+		source = s.Source
+	default:
+		return "", "", fmt.Errorf("unsupported share type [%s]", s.Type)
+	}
+
+	addr, err := resolveServer(server)
+	if err != nil {
+		return "", "", err
+	}
+
+	if s.Type == "nfs" {
+		opts = append(opts, "addr="+addr)
+	} else {
+		opts = append(opts, "ip="+addr)
+	}
+	if s.Options != "" {
+		opts = append(opts, s.Options)
+	}
+
+	return source, strings.Join(opts, ","), nil
+}
+
+// resolveServer returns the IP address of server, which may already be one.
+func resolveServer(server string) (string, error) {
+	if net.ParseIP(server) != nil {
+		return server, nil
+	}
+
+	addrs, err := net.LookupHost(server)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve share server [%s] -> %w", server, err)
+	}
+	if len(addrs) == 0 {
+		return "", errors.New("no addresses found for share server " + server)
+	}
+
+	return addrs[0], nil
+}
+
+// MountShare mounts the share read only at target and returns a function that unmounts it.
+func MountShare(s Share, target string) (func() error, error) {
+	source, data, err := s.mountArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating the share mountpoint [%s] -> %w", target, err)
+	}
+
+	if err := unix.Mount(source, target, s.Type, unix.MS_RDONLY, data); err != nil {
+		return nil, fmt.Errorf("mounting [%s] -> [%s] error -> %w", source, target, err)
+	}
+	log.Infof("Mounted [%s] -> [%s]", source, target)
+
+	return func() error {
+		return unix.Unmount(target, 0)
+	}, nil
+}
@@ -0,0 +1,58 @@
+package image
+
+import "testing"
+
+func TestShare_mountArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		share      Share
+		wantSource string
+		wantData   string
+		wantErr    bool
+	}{
+		{
+			name:       "nfs",
+			share:      Share{Type: "nfs", Source: "192.168.1.2:/export/images"},
+			wantSource: "192.168.1.2:/export/images",
+			wantData:   "nolock,addr=192.168.1.2",
+		},
+		{
+			name:       "nfs ipv6 with options",
+			share:      Share{Type: "nfs", Source: "[fd00::2]:/export/images", Options: "vers=4.1"},
+			wantSource: "[fd00::2]:/export/images",
+			wantData:   "nolock,addr=fd00::2,vers=4.1",
+		},
+		{
+			name:       "smb",
+			share:      Share{Type: "cifs", Source: "//192.168.1.2/images", Options: "username=deploy,password=s3cr3t"},
+			wantSource: "//192.168.1.2/images",
+			wantData:   "ip=192.168.1.2,username=deploy,password=s3cr3t",
+		},
+		{
+			name:    "nfs without export",
+			share:   Share{Type: "nfs", Source: "192.168.1.2"},
+			wantErr: true,
+		},
+		{
+			name:    "smb without share",
+			share:   Share{Type: "cifs", Source: "//192.168.1.2"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported",
+			share:   Share{Type: "ceph", Source: "192.168.1.2:/"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, data, err := tt.share.mountArgs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mountArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if source != tt.wantSource || data != tt.wantData {
+				t.Errorf("mountArgs() = %q, %q, want %q, %q", source, data, tt.wantSource, tt.wantData)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package image
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// signature is a detached signature of the source image, such as one made by cosign sign-blob. It is
+// checked against the SHA256 digest of the image, computed as the image is streamed.
+type signature struct {
+	key  crypto.PublicKey
+	sig  []byte
+	hash hash.Hash
+}
+
+// newSignature parses the PEM encoded public key and the signature, which may be base64 encoded as
+// cosign writes it or raw.
+func newSignature(publicKey string, sig []byte) (*signature, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key -> %w", err)
+	}
+
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T, must be ECDSA or RSA", key)
+	}
+
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+
+	return &signature{key: key, sig: sig, hash: sha256.New()}, nil
+}
+
+// fetchSignature downloads the signature at signatureURL, or reads it when it is a local path, and
+// parses it along with publicKey.
+func fetchSignature(c *client, signatureURL, publicKey string) (*signature, error) {
+	if publicKey == "" {
+		return nil, errors.New("a public key is required to verify the image signature")
+	}
+
+	sig, err := readLocation(c, signatureURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signature -> %w", err)
+	}
+
+	return newSignature(publicKey, sig)
+}
+
+// verify checks the signature against the digest of what was streamed.
+func (s *signature) verify(sourceImage string) error {
+	digest := s.hash.Sum(nil)
+
+	var valid bool
+
+	switch key := s.key.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest, s.sig)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, s.sig) == nil
+	}
+
+	if !valid {
+		return fmt.Errorf("signature verification failed for [%s]", RedactURL(sourceImage))
+	}
+
+	return nil
+}
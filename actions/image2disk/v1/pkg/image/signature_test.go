@@ -0,0 +1,108 @@
+package image
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestWriteSignature(t *testing.T) {
+	img := []byte("YourDataHere")
+	digest := sha256.Sum256(img)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cosign sign-blob writes the signature base64 encoded
+	signatures := map[string][]byte{
+		"/image.raw.sig":     []byte(base64.StdEncoding.EncodeToString(ecSig) + "\n"),
+		"/image.raw.rsa.sig": rsaSig,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/image.raw" {
+			w.Write(img)
+			return
+		}
+		sig, ok := signatures[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(sig)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"ecdsa", Options{SignatureURL: server.URL + "/image.raw.sig", PublicKey: publicKeyPEM(t, &ecKey.PublicKey)}, false},
+		{"rsa raw signature", Options{SignatureURL: server.URL + "/image.raw.rsa.sig", PublicKey: publicKeyPEM(t, &rsaKey.PublicKey)}, false},
+		{"wrong key", Options{SignatureURL: server.URL + "/image.raw.sig", PublicKey: publicKeyPEM(t, &otherKey.PublicKey)}, true},
+		{"missing signature", Options{SignatureURL: server.URL + "/missing.sig", PublicKey: publicKeyPEM(t, &ecKey.PublicKey)}, true},
+		{"missing key", Options{SignatureURL: server.URL + "/image.raw.sig"}, true},
+		{"key without signature", Options{PublicKey: publicKeyPEM(t, &ecKey.PublicKey)}, true},
+		{"invalid key", Options{SignatureURL: server.URL + "/image.raw.sig", PublicKey: "not a key"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Write(server.URL+"/image.raw?X-Amz-Signature=secret", disk, false, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "secret") {
+				t.Errorf("Write() error = %v, want the query of the image URL redacted", err)
+			}
+		})
+	}
+}
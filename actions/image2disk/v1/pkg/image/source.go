@@ -0,0 +1,98 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// localPath returns the path of location when it is a file:// URL or a bare path rather than a URL,
+// such as an image staged on a local cache disk or an NFS mount.
+func localPath(location string) (string, bool) {
+	if strings.HasPrefix(location, "file://") {
+		u, err := url.Parse(location)
+		if err != nil {
+			// A path that isn't a valid URL is opened as it is
+			return strings.TrimPrefix(location, "file://"), true
+		}
+
+		return u.Path, true
+	}
+
+	return location, !strings.Contains(location, "://")
+}
+
+// Stdin is the source image that is read from standard input.
+const Stdin = "-"
+
+// openImage opens the source image for reading and returns its size, or 0 or less when it is
+// unknown. An image from a server is downloaded resumably, in parallel chunks when concurrency is
+// more than 1, from the first of it and its mirrors that responds.
+func openImage(c *client, sourceImage string, mirrors []string, concurrency int) (io.ReadCloser, int64, error) {
+	if sourceImage == Stdin {
+		log.Info("Reading image from standard input")
+
+		// The size of a stream isn't known, and standard input is left open for the action
+		return ioutil.NopCloser(os.Stdin), 0, nil
+	}
+
+	if path, ok := localPath(sourceImage); ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to open image -> %w", err)
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, fmt.Errorf("failed to open image -> %w", err)
+		}
+
+		log.Infof("Reading image from local file [%s]", path)
+
+		return f, info.Size(), nil
+	}
+
+	resp, sourceImage, err := c.getMirrors(append([]string{sourceImage}, mirrors...))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// An interrupted download is resumed where it stopped, the digests and the decompressor carry
+	// on as if it never was
+	var src io.ReadCloser = newResumableBody(c, sourceImage, resp)
+	if concurrency > 1 {
+		if chunked, ok := newChunkedBody(c, sourceImage, resp, concurrency); ok {
+			src = chunked
+		}
+	}
+
+	return src, resp.ContentLength, nil
+}
+
+// readLocation reads all of location, a URL or a local path.
+func readLocation(c *client, location string) ([]byte, error) {
+	var r io.ReadCloser
+
+	if path, ok := localPath(location); ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	} else {
+		resp, err := c.getWithRetry(location)
+		if err != nil {
+			return nil, err
+		}
+		r = resp.Body
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,123 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_localPath(t *testing.T) {
+	tests := []struct {
+		location string
+		want     string
+		wantOK   bool
+	}{
+		{"file:///mnt/cache/ubuntu.raw.gz", "/mnt/cache/ubuntu.raw.gz", true},
+		{"file:///mnt/cache/ubuntu%2022.04.raw", "/mnt/cache/ubuntu 22.04.raw", true},
+		{"/mnt/cache/ubuntu.raw.gz", "/mnt/cache/ubuntu.raw.gz", true},
+		{"http://192.168.1.2/ubuntu.raw.gz", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			got, ok := localPath(tt.location)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("localPath() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestWriteLocal(t *testing.T) {
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write([]byte("YourDataHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	img := filepath.Join(t.TempDir(), "image.raw.gz")
+	if err := ioutil.WriteFile(img, compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		sourceImage string
+		wantErr     bool
+	}{
+		{"file url", "file://" + img, false},
+		{"bare path", img, false},
+		{"missing", img + ".missing", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Write(tt.sourceImage, disk, true, Options{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			written, err := ioutil.ReadFile(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(written) != "YourDataHere" {
+				t.Errorf("Write() wrote %q, want %q", written, "YourDataHere")
+			}
+		})
+	}
+}
+
+func TestWriteStdin(t *testing.T) {
+	// A compressed stream is found to be by its magic bytes, as it has no name
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write([]byte("YourDataHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := filepath.Join(t.TempDir(), "stdin")
+	if err := ioutil.WriteFile(stdin, compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	defer func(f *os.File) { os.Stdin = f }(os.Stdin)
+	os.Stdin = f
+
+	disk := filepath.Join(t.TempDir(), "disk")
+	if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Write(Stdin, disk, true, Options{}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	written, err := ioutil.ReadFile(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != "YourDataHere" {
+		t.Errorf("Write() wrote %q, want %q", written, "YourDataHere")
+	}
+}
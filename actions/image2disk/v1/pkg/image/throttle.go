@@ -0,0 +1,74 @@
+package image
+
+import (
+	"io"
+	"time"
+)
+
+// throttledWriter limits the rate of the writes to w with a token bucket, which holds up to a second
+// of writes so that short stalls of the download don't lose their share of the rate.
+type throttledWriter struct {
+	w io.Writer
+	// rate is in bytes per second.
+	rate   float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// newThrottledWriter limits the writes to w to limit megabytes per second.
+func newThrottledWriter(w io.Writer, limit float64) *throttledWriter {
+	rate := limit * 1e6
+
+	return &throttledWriter{
+		w:      w,
+		rate:   rate,
+		tokens: rate,
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+
+	for len(p) > 0 {
+		// Writes larger than the bucket are split so that they are spread over time
+		n := len(p)
+		if float64(n) > t.rate {
+			n = int(t.rate)
+		}
+		if n == 0 {
+			n = 1
+		}
+
+		t.wait(n)
+
+		m, err := t.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// wait takes n tokens from the bucket, sleeping until the bucket refills if it runs out.
+func (t *throttledWriter) wait(n int) {
+	now := t.now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.rate {
+		t.tokens = t.rate
+	}
+	t.last = now
+
+	t.tokens -= float64(n)
+	if t.tokens < 0 {
+		t.sleep(time.Duration(-t.tokens / t.rate * float64(time.Second)))
+	}
+}
@@ -0,0 +1,51 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_throttledWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit float64
+		size  int
+		want  time.Duration
+	}{
+		{"within the burst", 1, 1e6, 0},
+		{"twice the burst", 1, 2e6, time.Second},
+		{"ten times the burst", 2, 20e6, 9 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := newThrottledWriter(&buf, tt.limit)
+
+			// A fake clock that only moves on when the writer sleeps
+			clock := time.Unix(0, 0)
+			w.last = clock
+			w.now = func() time.Time { return clock }
+			w.sleep = func(d time.Duration) { clock = clock.Add(d) }
+
+			// Written in blocks the size io.Copy uses
+			data := make([]byte, tt.size)
+			for off := 0; off < len(data); off += 32 << 10 {
+				end := off + 32<<10
+				if end > len(data) {
+					end = len(data)
+				}
+				if _, err := w.Write(data[off:end]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if buf.Len() != tt.size {
+				t.Errorf("wrote %d bytes, want %d", buf.Len(), tt.size)
+			}
+			if got := clock.Sub(time.Unix(0, 0)); got.Round(time.Millisecond) != tt.want {
+				t.Errorf("took %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// verifyWritten reads back the extents of device that were written, in order, and checks that their
+// SHA256 digest is want, the digest of what was written to them.
+func verifyWritten(device string, extents []extent, want []byte) error {
+	f, err := os.Open(device)
+	if err != nil {
+		return fmt.Errorf("failed to open disk [%s] for verification -> %w", device, err)
+	}
+	defer f.Close()
+
+	// The written data is dropped from the page cache so that it is read back from the disk itself
+	if err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED); err != nil {
+		log.Warnf("Failed to drop the page cache of disk [%s], verification may read it from memory: %v", device, err)
+	}
+
+	h := sha256.New()
+	for _, e := range extents {
+		if n, err := io.Copy(h, io.NewSectionReader(f, e.offset, e.length)); err != nil || n != e.length {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("error reading back %d of %d bytes at %d from disk [%s] -> %w", n, e.length, e.offset, device, err)
+		}
+	}
+
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Errorf("verification failed for disk [%s], read back sha256 %x but wrote %x", device, got, want)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func Test_verifyWritten(t *testing.T) {
+	disk := filepath.Join(t.TempDir(), "disk")
+	// The disk is larger than the image written to it
+	if err := ioutil.WriteFile(disk, []byte("YourDataHere and the rest of the disk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	written := sha256.Sum256([]byte("YourDataHere"))
+	other := sha256.Sum256([]byte("YourDataThere"))
+
+	tests := []struct {
+		name    string
+		extents []extent
+		want    []byte
+		wantErr bool
+	}{
+		{"matches", []extent{{0, 12}}, written[:], false},
+		{"matches in extents", []extent{{0, 4}, {4, 8}}, written[:], false},
+		{"corrupted", []extent{{0, 12}}, other[:], true},
+		{"shorter than written", []extent{{0, 100}}, written[:], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyWritten(disk, tt.extents, tt.want); (err != nil) != tt.wantErr {
+				t.Errorf("verifyWritten() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteVerify(t *testing.T) {
+	var compressed bytes.Buffer
+	gzW := gzip.NewWriter(&compressed)
+	if _, err := gzW.Write([]byte("YourDataHere")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzW.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	disk := filepath.Join(t.TempDir(), "disk")
+	if err := ioutil.WriteFile(disk, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Write(server.URL+"/image.raw.gz", disk, true, Options{Verify: true}); err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+}
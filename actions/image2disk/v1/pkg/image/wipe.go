@@ -0,0 +1,124 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// wipeSize is how much is zeroed at the start and the end of the disk and of each partition on it.
+// It covers the MBR and both GPT headers, mdraid superblocks of every version, LVM labels, the
+// superblocks of ext, xfs and btrfs, and the four ZFS labels.
+const wipeSize = 1 << 20
+
+// btrfsMirrors are the offsets of the copies of the btrfs superblock beyond the start of the device.
+var btrfsMirrors = []int64{64 << 20, 256 << 30}
+
+// sysBlock is where the kernel lists block devices and their partitions.
+var sysBlock = "/sys/class/block"
+
+// region is a range of bytes of the disk.
+type region struct {
+	offset, length int64
+}
+
+// wipe zeroes the filesystem, RAID and partition table signatures of the disk and of the partitions
+// it currently has, so that none of them are left over to be found on the new image.
+func wipe(disk *os.File) error {
+	size, err := disk.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to find the size of disk [%s] -> %w", disk.Name(), err)
+	}
+
+	regions := signatureRegions(0, size)
+	parts := partitions(disk.Name())
+	for _, p := range parts {
+		regions = append(regions, signatureRegions(p.offset, p.length)...)
+	}
+
+	zeros := make([]byte, wipeSize)
+	for _, r := range regions {
+		if r.offset+r.length > size {
+			continue
+		}
+		if _, err := disk.WriteAt(zeros[:r.length], r.offset); err != nil {
+			return fmt.Errorf("failed to wipe %d bytes at %d of disk [%s] -> %w", r.length, r.offset, disk.Name(), err)
+		}
+	}
+
+	if _, err := disk.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := disk.Sync(); err != nil {
+		return fmt.Errorf("failed to sync disk [%s] -> %w", disk.Name(), err)
+	}
+
+	log.Infof("Wiped the signatures of disk [%s] and its %d partitions", disk.Name(), len(parts))
+
+	return nil
+}
+
+// signatureRegions returns where the signatures of a device at offset of size bytes may be.
+func signatureRegions(offset, size int64) []region {
+	length := int64(wipeSize)
+	if size <= 2*length {
+		// The whole of a small device is wiped
+		return []region{{offset: offset, length: size / 2}, {offset: offset + size/2, length: size - size/2}}
+	}
+
+	regions := []region{
+		{offset: offset, length: length},
+		{offset: offset + size - length, length: length},
+	}
+	for _, mirror := range btrfsMirrors {
+		if mirror+length <= size-length {
+			regions = append(regions, region{offset: offset + mirror, length: length})
+		}
+	}
+
+	return regions
+}
+
+// partitions returns the regions of the partitions of disk that the kernel knows of.
+func partitions(disk string) []region {
+	name := filepath.Base(disk)
+	if resolved, err := filepath.EvalSymlinks(disk); err == nil {
+		name = filepath.Base(resolved)
+	}
+
+	starts, _ := filepath.Glob(filepath.Join(sysBlock, name, name+"*", "start"))
+
+	var parts []region
+	for _, start := range starts {
+		dir := filepath.Dir(start)
+
+		first, err := readSectors(start)
+		if err != nil {
+			continue
+		}
+		count, err := readSectors(filepath.Join(dir, "size"))
+		if err != nil {
+			continue
+		}
+
+		// sysfs counts in 512 byte sectors whatever the sector size of the disk
+		parts = append(parts, region{offset: first * 512, length: count * 512})
+	}
+
+	return parts
+}
+
+func readSectors(path string) (int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
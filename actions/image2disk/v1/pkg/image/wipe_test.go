@@ -0,0 +1,91 @@
+package image
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_wipe(t *testing.T) {
+	const size = 128 << 20
+
+	// A disk with one partition from 8MiB to 40MiB, as the kernel would list it
+	defer func(dir string) { sysBlock = dir }(sysBlock)
+	sysBlock = t.TempDir()
+	part := filepath.Join(sysBlock, "disk", "disk1")
+	if err := os.MkdirAll(part, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(part, "start"), []byte("16384\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(part, "size"), []byte("65536\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	disk, err := os.OpenFile(filepath.Join(t.TempDir(), "disk"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+	if err := disk.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+
+	signature := []byte("signature")
+	tests := []struct {
+		name   string
+		offset int64
+		wiped  bool
+	}{
+		{"mbr", 0, true},
+		{"ext superblock", 1024, true},
+		{"btrfs superblock", 64 << 10, true},
+		{"gpt backup header", size - 512, true},
+		{"mdraid 1.0 superblock", size - 8<<10, true},
+		{"btrfs mirror", 64 << 20, true},
+		{"partition lvm label", 8<<20 + 512, true},
+		{"partition mdraid 0.9 superblock", 40<<20 - 64<<10, true},
+		{"data", 4 << 20, false},
+		{"partition data", 16 << 20, false},
+	}
+	for _, tt := range tests {
+		if _, err := disk.WriteAt(signature, tt.offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := wipe(disk); err != nil {
+		t.Fatalf("wipe() error = %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]byte, len(signature))
+			if _, err := disk.ReadAt(got, tt.offset); err != nil {
+				t.Fatal(err)
+			}
+			if wiped := !bytes.Equal(got, signature); wiped != tt.wiped {
+				t.Errorf("wipe() wiped the %s = %v, want %v", tt.name, wiped, tt.wiped)
+			}
+		})
+	}
+}
+
+func Test_signatureRegions(t *testing.T) {
+	// A device too small to have separate regions at its start and end is wiped whole
+	regions := signatureRegions(4096, 3<<19)
+
+	var total int64
+	for _, r := range regions {
+		if r.length > wipeSize {
+			t.Errorf("region of %d bytes is larger than %d", r.length, wipeSize)
+		}
+		total += r.length
+	}
+	if total != 3<<19 {
+		t.Errorf("signatureRegions() covers %d bytes, want %d", total, 3<<19)
+	}
+}
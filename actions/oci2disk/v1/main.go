@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/tinkerbell/hub/actions/oci2disk/v1/pkg/image"
@@ -18,10 +20,67 @@ func main() {
 	// We can ignore the error and default compressed to false.
 	cmp, _ := strconv.ParseBool(compressedEnv)
 
+	opts := image.Options{
+		Username:     os.Getenv("REGISTRY_USERNAME"),
+		Password:     os.Getenv("REGISTRY_PASSWORD"),
+		RegistryHost: os.Getenv("REGISTRY_HOST"),
+		AuthFile:     os.Getenv("REGISTRY_AUTH_FILE"),
+		Arch:         os.Getenv("ARCH"),
+		Cosign: image.Cosign{
+			PublicKey:      readPEM("COSIGN_PUBLIC_KEY"),
+			Identity:       os.Getenv("COSIGN_CERTIFICATE_IDENTITY"),
+			Issuer:         os.Getenv("COSIGN_CERTIFICATE_OIDC_ISSUER"),
+			FulcioRoots:    readPEM("COSIGN_FULCIO_ROOT"),
+			RekorPublicKey: readPEM("COSIGN_REKOR_PUBLIC_KEY"),
+		},
+		CACert: readPEM("REGISTRY_CA_CERT"),
+	}
+	if insecure := os.Getenv("REGISTRY_INSECURE"); insecure != "" {
+		// It is either true for all registries, or the list of the insecure registries
+		if all, err := strconv.ParseBool(insecure); err == nil {
+			if all {
+				opts.Insecure = []string{"*"}
+			}
+		} else {
+			opts.Insecure = splitList(insecure)
+		}
+	}
+	if mirrors := os.Getenv("REGISTRY_MIRRORS"); mirrors != "" {
+		opts.Mirrors = splitList(mirrors)
+	}
+
 	// Write the image to disk
-	err := image.Write(img, disk, cmp)
+	err := image.Write(img, disk, cmp, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Infof("Successfully written [%s] to [%s]", img, disk)
 }
+
+// readPEM returns the PEM in the environment variable name, which is either the PEM or the path of a
+// file with it.
+func readPEM(name string) []byte {
+	value := os.Getenv(name)
+	if value == "" || strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value)
+	}
+
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		log.Fatalf("Reading the file of environment variable [%s] failed.  %v", name, err)
+	}
+
+	return data
+}
+
+// splitList returns the items of a comma separated list.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
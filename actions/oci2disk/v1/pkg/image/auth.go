@@ -0,0 +1,166 @@
+package image
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/reference"
+)
+
+// DefaultAuthFile is where a docker config with the credentials of registries is read from when no
+// other file is set, such as a mounted kubernetes.io/dockerconfigjson secret.
+const DefaultAuthFile = "/root/.docker/config.json"
+
+// dockerConfig is the part of a docker config.json, or of a .dockerconfigjson, with the credentials
+// of registries.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+}
+
+// credentials are the credentials of registries for the resolver. They are the username and
+// password of the options for the registry that they are for, then those of the registry in the
+// docker config, then a token exchanged for the AWS credentials of the environment for an ECR
+// registry. The username and password aren't sent to the other registries, such as the mirrors.
+type credentials struct {
+	username, password string
+	host               string
+	authFile           string
+
+	mu  sync.Mutex
+	ecr map[string][2]string
+}
+
+// newCredentials returns the credentials of the pull of sourceImage.
+func newCredentials(opts Options, sourceImage string) (*credentials, error) {
+	authFile := opts.AuthFile
+	if authFile == "" {
+		authFile = DefaultAuthFile
+	}
+
+	host := opts.RegistryHost
+	if host == "" && (opts.Username != "" || opts.Password != "") {
+		spec, err := reference.Parse(sourceImage)
+		if err != nil {
+			return nil, err
+		}
+		host = spec.Hostname()
+	}
+
+	return &credentials{
+		username: opts.Username,
+		password: opts.Password,
+		host:     configHost(host),
+		authFile: authFile,
+		ecr:      map[string][2]string{},
+	}, nil
+}
+
+// lookup returns the username and secret for host, the secret is an identity token when the
+// username is empty.
+func (c *credentials) lookup(host string) (string, string, error) {
+	if (c.username != "" || c.password != "") && host == c.host {
+		return c.username, c.password, nil
+	}
+
+	user, secret, err := dockerConfigAuth(c.authFile, host)
+	if err != nil || user != "" || secret != "" {
+		return user, secret, err
+	}
+
+	if region, ok := ecrRegion(host); ok {
+		return c.ecrAuth(host, region)
+	}
+
+	return "", "", nil
+}
+
+// ecrAuth returns the credentials for an ECR registry, they are exchanged for a token once and then
+// used for all the requests of the pull.
+func (c *credentials) ecrAuth(host, region string) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if auth, ok := c.ecr[host]; ok {
+		return auth[0], auth[1], nil
+	}
+
+	keys, ok := awsKeysFromEnv()
+	if !ok {
+		return "", "", nil
+	}
+
+	user, pass, err := ecrToken(keys, region)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get an ECR token for [%s] -> %w", host, err)
+	}
+	c.ecr[host] = [2]string{user, pass}
+
+	return user, pass, nil
+}
+
+// dockerConfigAuth returns the credentials of host in the docker config at path, or none when the
+// file doesn't exist or has none for host.
+func dockerConfigAuth(path, host string) (string, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read registry auth file -> %w", err)
+	}
+
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", "", fmt.Errorf("failed to parse registry auth file [%s] -> %w", path, err)
+	}
+
+	for key, auth := range config.Auths {
+		if configHost(key) != host {
+			continue
+		}
+
+		if auth.IdentityToken != "" {
+			return "", auth.IdentityToken, nil
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return "", "", fmt.Errorf("invalid auth of registry [%s] in [%s] -> %w", key, path, err)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return "", "", fmt.Errorf("invalid auth of registry [%s] in [%s]", key, path)
+			}
+			return parts[0], parts[1], nil
+		}
+
+		return auth.Username, auth.Password, nil
+	}
+
+	return "", "", nil
+}
+
+// configHost returns the host that a key of the auths of a docker config is for. The keys may be
+// URLs, and Docker Hub is known by the name of its index.
+func configHost(key string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+
+	switch host {
+	case "docker.io", "index.docker.io":
+		return "registry-1.docker.io"
+	}
+
+	return host
+}
@@ -0,0 +1,133 @@
+package image
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_dockerConfigAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("hub:secret")) + `"},
+		"registry.example.com": {"username": "user", "password": "pass"},
+		"token.example.com": {"identitytoken": "token"}
+	}}`
+	if err := ioutil.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		host     string
+		wantUser string
+		wantPass string
+		wantErr  bool
+	}{
+		{"docker hub", path, "registry-1.docker.io", "hub", "secret", false},
+		{"username and password", path, "registry.example.com", "user", "pass", false},
+		{"identity token", path, "token.example.com", "", "token", false},
+		{"unknown registry", path, "other.example.com", "", "", false},
+		{"no config", filepath.Join(t.TempDir(), "missing.json"), "registry.example.com", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, pass, err := dockerConfigAuth(tt.path, tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dockerConfigAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if user != tt.wantUser || pass != tt.wantPass {
+				t.Errorf("dockerConfigAuth() = %q, %q, want %q, %q", user, pass, tt.wantUser, tt.wantPass)
+			}
+		})
+	}
+}
+
+func Test_credentialsLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"auths": {"registry.example.com": {"username": "user", "password": "pass"}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		image    string
+		host     string
+		wantUser string
+		wantPass string
+	}{
+		{"options", Options{Username: "env", Password: "envpass"}, "registry.example.com/debian:raw", "registry.example.com", "env", "envpass"},
+		{"options aren't sent to a mirror", Options{Username: "env", Password: "envpass"}, "registry.example.com/debian:raw", "mirror.example.com", "", ""},
+		{"options of Docker Hub", Options{Username: "env", Password: "envpass"}, "docker.io/library/debian:raw", "registry-1.docker.io", "env", "envpass"},
+		{"options for another registry", Options{Username: "env", Password: "envpass", RegistryHost: "mirror.example.com"}, "registry.example.com/debian:raw", "mirror.example.com", "env", "envpass"},
+		{"auth file", Options{}, "registry.example.com/debian:raw", "registry.example.com", "user", "pass"},
+		{"auth file when the options are for another registry", Options{Username: "env", Password: "envpass"}, "other.example.com/debian:raw", "registry.example.com", "user", "pass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.AuthFile = path
+			creds, err := newCredentials(tt.opts, tt.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			user, pass, err := creds.lookup(tt.host)
+			if err != nil || user != tt.wantUser || pass != tt.wantPass {
+				t.Errorf("lookup() = %q, %q, %v, want %q, %q", user, pass, err, tt.wantUser, tt.wantPass)
+			}
+		})
+	}
+}
+
+func Test_ecrToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.HasSuffix(r.Header.Get("X-Amz-Target"), ".GetAuthorizationToken") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		token := base64.StdEncoding.EncodeToString([]byte("AWS:ecrpass"))
+		_, _ = w.Write([]byte(`{"authorizationData": [{"authorizationToken": "` + token + `"}]}`))
+	}))
+	defer server.Close()
+
+	endpoint := ecrEndpoint
+	defer func() { ecrEndpoint = endpoint }()
+	ecrEndpoint = func(string) string { return server.URL + "/" }
+
+	for name, value := range map[string]string{"AWS_ACCESS_KEY_ID": "AKID", "AWS_SECRET_ACCESS_KEY": "secret"} {
+		old, ok := os.LookupEnv(name)
+		os.Setenv(name, value)
+		defer func(name string) {
+			if ok {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		}(name)
+	}
+
+	host := "123456789012.dkr.ecr.us-west-2.amazonaws.com"
+	if region, ok := ecrRegion(host); !ok || region != "us-west-2" {
+		t.Fatalf("ecrRegion() = %q, %v, want us-west-2", region, ok)
+	}
+	if _, ok := ecrRegion("registry.example.com"); ok {
+		t.Error("ecrRegion() of a registry that isn't ECR succeeded")
+	}
+
+	creds, err := newCredentials(Options{AuthFile: filepath.Join(t.TempDir(), "missing.json")}, host+"/debian:raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, pass, err := creds.lookup(host)
+	if err != nil || user != "AWS" || pass != "ecrpass" {
+		t.Errorf("lookup() = %q, %q, %v, want the ECR token", user, pass, err)
+	}
+}
@@ -0,0 +1,400 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// The media type and annotations of the layers of a cosign signature.
+const (
+	cosignPayloadMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	cosignSignature        = "dev.cosignproject.cosign/signature"
+	cosignCertificate      = "dev.sigstore.cosign/certificate"
+	cosignChain            = "dev.sigstore.cosign/chain"
+	cosignBundle           = "dev.sigstore.cosign/bundle"
+	// maxSignatureSize is the largest signature manifest or payload that is read.
+	maxSignatureSize = 1 << 20
+)
+
+// The extensions of a Fulcio certificate with the OIDC issuer of its identity.
+var (
+	oidFulcioIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// Cosign is how the cosign signatures of images are verified. The image is verified with a public
+// key, or keyless with the identity of the certificate that Fulcio issued for its signature.
+type Cosign struct {
+	// PublicKey is the PEM of the public key that the image is signed with.
+	PublicKey []byte
+	// Identity and Issuer are the email or URI of the signer in the certificate of a keyless
+	// signature, and the OIDC issuer that the signer logged in with.
+	Identity string
+	Issuer   string
+	// FulcioRoots is the PEM of the certificates of Fulcio, that the certificates of keyless signatures
+	// are issued by, and RekorPublicKey is the PEM of the key of the Rekor log that they are in.
+	FulcioRoots    []byte
+	RekorPublicKey []byte
+}
+
+// enabled reports whether signatures are verified.
+func (c Cosign) enabled() bool {
+	return len(c.PublicKey) > 0 || c.Identity != "" || c.Issuer != ""
+}
+
+// simpleSigning is the payload that cosign signs, of the digest of the manifest of the image.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// rekorBundle is the proof that a keyless signature is in the Rekor log, signed by Rekor.
+type rekorBundle struct {
+	SignedEntryTimestamp []byte
+	Payload              rekorPayload
+}
+
+// rekorPayload is the entry of the Rekor log, its fields are in the order of their canonical JSON.
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// hashedRekord is the body of a Rekor entry of a signature.
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content []byte `json:"content"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// verifySignature verifies that the image desc of name has a cosign signature that is valid for
+// opts. It fails when the image has no signature, or none of them are valid.
+func verifySignature(ctx context.Context, resolver remotes.Resolver, name string, desc ocispec.Descriptor, opts Cosign) error {
+	spec, err := reference.Parse(name)
+	if err != nil {
+		return err
+	}
+	sigRef := spec.Locator + ":" + strings.Replace(desc.Digest.String(), ":", "-", 1) + ".sig"
+
+	_, sigDesc, err := resolver.Resolve(ctx, sigRef)
+	if err != nil {
+		return fmt.Errorf("image [%s] has no cosign signature [%s] -> %w", name, sigRef, err)
+	}
+	fetcher, err := resolver.Fetcher(ctx, sigRef)
+	if err != nil {
+		return err
+	}
+
+	data, err := fetchVerified(ctx, fetcher, sigDesc, maxSignatureSize)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the cosign signature [%s] -> %w", sigRef, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to parse the cosign signature [%s] -> %w", sigRef, err)
+	}
+
+	var failures []string
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != cosignPayloadMediaType {
+			continue
+		}
+
+		payload, err := fetchVerified(ctx, fetcher, layer, maxSignatureSize)
+		if err == nil {
+			err = verifyPayload(payload, layer.Annotations, desc.Digest, opts)
+		}
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+
+		log.Infof("Verified the cosign signature of image [%s] (%s)", name, desc.Digest)
+		return nil
+	}
+
+	if len(failures) == 0 {
+		return fmt.Errorf("image [%s] has no cosign signature in [%s]", name, sigRef)
+	}
+
+	return fmt.Errorf("image [%s] has no valid cosign signature: %s", name, strings.Join(failures, "; "))
+}
+
+// fetchVerified fetches the blob desc, of up to limit bytes, and checks that it has the digest of desc.
+func fetchVerified(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor, limit int64) ([]byte, error) {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(rc, limit))
+	if err != nil {
+		return nil, err
+	}
+	if dgst := digest.FromBytes(data); dgst != desc.Digest {
+		return nil, fmt.Errorf("blob has digest %s, expected %s", dgst, desc.Digest)
+	}
+
+	return data, nil
+}
+
+// verifyPayload verifies the signature in the annotations of the signed payload, and that the
+// payload is of the image with manifest digest.
+func verifyPayload(payload []byte, annotations map[string]string, manifest digest.Digest, opts Cosign) error {
+	sig, err := base64.StdEncoding.DecodeString(annotations[cosignSignature])
+	if err != nil || len(sig) == 0 {
+		return errors.New("signature is missing or invalid")
+	}
+
+	if len(opts.PublicKey) > 0 {
+		pub, err := parsePublicKey(opts.PublicKey)
+		if err != nil {
+			return err
+		}
+		if err := verifyBlob(pub, payload, sig); err != nil {
+			return fmt.Errorf("signature isn't of the public key -> %w", err)
+		}
+	} else if err := verifyKeyless(payload, sig, annotations, opts); err != nil {
+		return err
+	}
+
+	var signed simpleSigning
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return fmt.Errorf("invalid signed payload -> %w", err)
+	}
+	if signed.Critical.Image.DockerManifestDigest != manifest.String() {
+		return fmt.Errorf("signature is of the image %s, not of %s", signed.Critical.Image.DockerManifestDigest, manifest)
+	}
+
+	return nil
+}
+
+// verifyKeyless verifies a keyless signature: the certificate that it was signed with is issued by
+// Fulcio to the identity, the signature is of that certificate, and it is in the Rekor log from the
+// time that the certificate was valid.
+func verifyKeyless(payload, sig []byte, annotations map[string]string, opts Cosign) error {
+	if opts.Identity == "" || opts.Issuer == "" {
+		return errors.New("keyless signatures are verified with both an identity and an issuer")
+	}
+	if len(opts.FulcioRoots) == 0 || len(opts.RekorPublicKey) == 0 {
+		return errors.New("keyless signatures are verified with the Fulcio roots and the Rekor public key")
+	}
+
+	certs, err := parseCertificates([]byte(annotations[cosignCertificate]))
+	if err != nil || len(certs) == 0 {
+		return errors.New("keyless signature has no certificate")
+	}
+	cert := certs[0]
+
+	// The certificate is only valid for minutes, so it is verified at the time the signature was logged
+	integrated, err := verifyBundle([]byte(annotations[cosignBundle]), payload, sig, opts.RekorPublicKey)
+	if err != nil {
+		return err
+	}
+
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	fulcio, err := parseCertificates(opts.FulcioRoots)
+	if err != nil || len(fulcio) == 0 {
+		return errors.New("invalid Fulcio roots")
+	}
+	for _, c := range fulcio {
+		if bytes.Equal(c.RawIssuer, c.RawSubject) {
+			roots.AddCert(c)
+		} else {
+			intermediates.AddCert(c)
+		}
+	}
+	chain, _ := parseCertificates([]byte(annotations[cosignChain]))
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   integrated,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("certificate isn't issued by Fulcio -> %w", err)
+	}
+
+	if identity := certIdentities(cert); !contains(identity, opts.Identity) {
+		return fmt.Errorf("certificate is of [%s], not of [%s]", strings.Join(identity, ", "), opts.Identity)
+	}
+	if issuer := certIssuer(cert); issuer != opts.Issuer {
+		return fmt.Errorf("certificate is issued for [%s], not for [%s]", issuer, opts.Issuer)
+	}
+
+	if err := verifyBlob(cert.PublicKey, payload, sig); err != nil {
+		return fmt.Errorf("signature isn't of the certificate -> %w", err)
+	}
+
+	return nil
+}
+
+// verifyBundle verifies that the Rekor bundle is signed by Rekor and is of the signature of payload,
+// and returns the time that it was logged.
+func verifyBundle(data, payload, sig, rekorKey []byte) (time.Time, error) {
+	var bundle rekorBundle
+	if len(data) == 0 {
+		return time.Time{}, errors.New("keyless signature has no Rekor bundle")
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return time.Time{}, fmt.Errorf("invalid Rekor bundle -> %w", err)
+	}
+
+	var canonical bytes.Buffer
+	enc := json.NewEncoder(&canonical)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(bundle.Payload); err != nil {
+		return time.Time{}, err
+	}
+	pub, err := parsePublicKey(rekorKey)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid Rekor public key -> %w", err)
+	}
+	if err := verifyBlob(pub, bytes.TrimSuffix(canonical.Bytes(), []byte("\n")), bundle.SignedEntryTimestamp); err != nil {
+		return time.Time{}, fmt.Errorf("Rekor bundle isn't signed by Rekor -> %w", err)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid Rekor entry -> %w", err)
+	}
+	var entry hashedRekord
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, fmt.Errorf("invalid Rekor entry -> %w", err)
+	}
+	payloadHash := sha256.Sum256(payload)
+	if entry.Kind != "hashedrekord" || entry.Spec.Data.Hash.Algorithm != "sha256" ||
+		entry.Spec.Data.Hash.Value != hex.EncodeToString(payloadHash[:]) || !bytes.Equal(entry.Spec.Signature.Content, sig) {
+		return time.Time{}, errors.New("Rekor entry isn't of the signature")
+	}
+
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
+
+// verifyBlob verifies that sig is the signature of data with the key pub.
+func verifyBlob(pub crypto.PublicKey, data, sig []byte) error {
+	hash := sha256.Sum256(data)
+
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hash[:], sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, sig) {
+			return errors.New("invalid ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	return nil
+}
+
+// parsePublicKey parses the PEM of a public key.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key isn't PEM encoded")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// parseCertificates parses the PEM certificates in data.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// certIdentities returns the emails and URIs that cert is issued to.
+func certIdentities(cert *x509.Certificate) []string {
+	identities := append([]string{}, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		identities = append(identities, u.String())
+	}
+
+	return identities
+}
+
+// certIssuer returns the OIDC issuer of the identity that Fulcio issued cert to.
+func certIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(oidFulcioIssuer):
+			return string(ext.Value)
+		}
+	}
+
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,227 @@
+package image
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func newKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, data []byte) []byte {
+	t.Helper()
+
+	hash := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+// signedPayload returns the payload that cosign signs for the image with manifest digest.
+func signedPayload(manifest digest.Digest) []byte {
+	return []byte(`{"critical":{"identity":{"docker-reference":"images/debian"},"image":{"docker-manifest-digest":"` +
+		manifest.String() + `"},"type":"cosign container image signature"},"optional":null}`)
+}
+
+// addSignature adds the cosign signature of the image with manifest digest, with the annotations of
+// its payload.
+func (r *testRegistry) addSignature(t *testing.T, manifest digest.Digest, payload []byte, annotations map[string]string) {
+	t.Helper()
+
+	config := []byte("{}")
+	r.blobs[digest.FromBytes(config)] = config
+	r.blobs[digest.FromBytes(payload)] = payload
+
+	r.addManifest(t, strings.Replace(manifest.String(), ":", "-", 1)+".sig", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Config: ocispec.Descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: digest.FromBytes(config), Size: 2},
+		Layers: []ocispec.Descriptor{{
+			MediaType:   cosignPayloadMediaType,
+			Digest:      digest.FromBytes(payload),
+			Size:        int64(len(payload)),
+			Annotations: annotations,
+		}},
+	})
+}
+
+func TestWriteCosign(t *testing.T) {
+	layer := []byte("the disk image")
+	key, pub := newKey(t)
+	_, otherPub := newKey(t)
+
+	tests := []struct {
+		name    string
+		sign    bool
+		payload func(digest.Digest) []byte
+		key     []byte
+		tamper  bool
+		wantErr bool
+	}{
+		{"signed", true, signedPayload, pub, false, false},
+		{"signed with another key", true, signedPayload, otherPub, false, true},
+		{"not signed", false, signedPayload, pub, false, true},
+		{"signature of another image", true, func(digest.Digest) []byte { return signedPayload(digest.FromString("other")) }, pub, false, true},
+		{"layer doesn't match the signed manifest", true, signedPayload, pub, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newTestRegistry(t, "application/vnd.oci.image.layer.v1.tar", layer)
+			if tt.sign {
+				manifest := digest.FromBytes(registry.manifests["raw"].data)
+				payload := tt.payload(manifest)
+				registry.addSignature(t, manifest, payload, map[string]string{
+					cosignSignature: base64.StdEncoding.EncodeToString(sign(t, key, payload)),
+				})
+			}
+
+			if tt.tamper {
+				registry.blobs[digest.FromBytes(layer)] = []byte("tampered image")
+			}
+
+			disk := filepath.Join(t.TempDir(), "disk")
+			err := Write(registry.host()+"/images/debian:raw", disk, false, Options{Cosign: Cosign{PublicKey: tt.key}, CACert: registry.caCert()})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// A layer that doesn't match is only found once it is written
+			written, _ := ioutil.ReadFile(disk)
+			if tt.wantErr && !tt.tamper && len(written) != 0 {
+				t.Errorf("Write() wrote %q to the disk of an image that isn't verified", written)
+			}
+			if !tt.wantErr && !bytes.Equal(written, layer) {
+				t.Errorf("Write() wrote %q, want %q", written, layer)
+			}
+		})
+	}
+}
+
+// keylessSignature returns the annotations of a keyless signature of payload, with a certificate
+// for identity and issuer from the Fulcio root, and the bundle of its Rekor entry.
+func keylessSignature(t *testing.T, payload []byte, identity, issuer string) (annotations map[string]string, fulcioRoot, rekorPub []byte) {
+	t.Helper()
+
+	now := time.Now()
+	rootKey, _ := newKey(t)
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fulcio"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := x509.ParseCertificate(rootDER)
+
+	signer, _ := newKey(t)
+	issuerExt, _ := asn1.Marshal(issuer)
+	uri, _ := url.Parse(identity)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       now.Add(-time.Minute),
+		NotAfter:        now.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{uri},
+		ExtraExtensions: []pkix.Extension{{Id: oidFulcioIssuerV2, Value: issuerExt}},
+	}, root, &signer.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := sign(t, signer, payload)
+	payloadHash := sha256.Sum256(payload)
+	var entry hashedRekord
+	entry.Kind = "hashedrekord"
+	entry.Spec.Data.Hash.Algorithm = "sha256"
+	entry.Spec.Data.Hash.Value = hex.EncodeToString(payloadHash[:])
+	entry.Spec.Signature.Content = sig
+	body, _ := json.Marshal(entry)
+
+	rekorKey, rekorPub := newKey(t)
+	bundle := rekorBundle{Payload: rekorPayload{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: now.Unix(),
+		LogID:          "c0d23d6ad406973f9559f3ba2d1ca01f84147d8ffc5b8445c224f98b9591801d",
+		LogIndex:       42,
+	}}
+	canonical, _ := json.Marshal(bundle.Payload)
+	bundle.SignedEntryTimestamp = sign(t, rekorKey, canonical)
+	bundleJSON, _ := json.Marshal(bundle)
+
+	return map[string]string{
+		cosignSignature:   base64.StdEncoding.EncodeToString(sig),
+		cosignCertificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})),
+		cosignBundle:      string(bundleJSON),
+	}, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}), rekorPub
+}
+
+func Test_verifyPayloadKeyless(t *testing.T) {
+	manifest := digest.FromString("manifest")
+	payload := signedPayload(manifest)
+	identity := "https://github.com/example/images/.github/workflows/release.yaml@refs/heads/main"
+	issuer := "https://token.actions.githubusercontent.com"
+	annotations, fulcioRoot, rekorPub := keylessSignature(t, payload, identity, issuer)
+	_, otherPub := newKey(t)
+
+	tests := []struct {
+		name    string
+		opts    Cosign
+		payload []byte
+		wantErr bool
+	}{
+		{"valid", Cosign{Identity: identity, Issuer: issuer, FulcioRoots: fulcioRoot, RekorPublicKey: rekorPub}, payload, false},
+		{"another identity", Cosign{Identity: "https://github.com/other", Issuer: issuer, FulcioRoots: fulcioRoot, RekorPublicKey: rekorPub}, payload, true},
+		{"another issuer", Cosign{Identity: identity, Issuer: "https://accounts.google.com", FulcioRoots: fulcioRoot, RekorPublicKey: rekorPub}, payload, true},
+		{"another Rekor", Cosign{Identity: identity, Issuer: issuer, FulcioRoots: fulcioRoot, RekorPublicKey: otherPub}, payload, true},
+		{"no roots", Cosign{Identity: identity, Issuer: issuer}, payload, true},
+		{"tampered payload", Cosign{Identity: identity, Issuer: issuer, FulcioRoots: fulcioRoot, RekorPublicKey: rekorPub}, append([]byte(" "), payload...), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyPayload(tt.payload, annotations, manifest, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyPayload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package image
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ecrHost matches the hosts of ECR registries, <account>.dkr.ecr.<region>.amazonaws.com.
+var ecrHost = regexp.MustCompile(`^\d{12}\.dkr\.ecr(-fips)?\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// ecrEndpoint returns the ECR API endpoint of region, it is replaced in tests.
+var ecrEndpoint = func(region string) string {
+	if strings.HasPrefix(region, "cn-") {
+		return "https://api.ecr." + region + ".amazonaws.com.cn/"
+	}
+	return "https://api.ecr." + region + ".amazonaws.com/"
+}
+
+// awsKeys are the AWS credentials that the token of an ECR registry is requested with.
+type awsKeys struct {
+	accessKeyID, secretAccessKey, sessionToken string
+}
+
+// awsKeysFromEnv returns the AWS credentials of the environment, when there are any.
+func awsKeysFromEnv() (awsKeys, bool) {
+	keys := awsKeys{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	return keys, keys.accessKeyID != "" && keys.secretAccessKey != ""
+}
+
+// ecrRegion returns the region of host when it is an ECR registry.
+func ecrRegion(host string) (string, bool) {
+	m := ecrHost.FindStringSubmatch(host)
+	if m == nil {
+		return "", false
+	}
+
+	return m[2], true
+}
+
+// ecrToken exchanges the AWS credentials for the username and password of the ECR registries of
+// region, with the GetAuthorizationToken API.
+func ecrToken(keys awsKeys, region string) (string, string, error) {
+	body := []byte("{}")
+
+	req, err := http.NewRequest(http.MethodPost, ecrEndpoint(region), bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken")
+	signV4(req, body, keys, region, "ecr", time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var out struct {
+		AuthorizationData []struct {
+			AuthorizationToken string `json:"authorizationToken"`
+		} `json:"authorizationData"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", "", fmt.Errorf("failed to parse the token -> %w", err)
+	}
+	if len(out.AuthorizationData) == 0 {
+		return "", "", errors.New("no token was returned")
+	}
+
+	// The token is the base64 of AWS:<password>
+	decoded, err := base64.StdEncoding.DecodeString(out.AuthorizationData[0].AuthorizationToken)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid token -> %w", err)
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("invalid token")
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// signV4 signs req, which has body and only the headers it is signed with, with AWS Signature
+// Version 4 for service in region.
+func signV4(req *http.Request, body []byte, keys awsKeys, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if keys.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", keys.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+keys.secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		keys.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
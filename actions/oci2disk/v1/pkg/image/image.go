@@ -6,7 +6,6 @@ import (
 	"compress/bzip2"
 	"compress/gzip"
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -35,30 +34,71 @@ func (wc *WriteCounter) Write(p []byte) (int, error) {
 	return n, nil
 }
 
+// Options are the options of the pull of an image.
+type Options struct {
+	// Username and Password are the credentials of the registry of the image, they are only sent
+	// to RegistryHost.
+	Username string
+	Password string
+	// RegistryHost is the registry that Username and Password are for. It is the registry of the
+	// image reference when it isn't set.
+	RegistryHost string
+	// AuthFile is a docker config.json, or a .dockerconfigjson secret, with the credentials of
+	// registries. It is DefaultAuthFile when it isn't set.
+	AuthFile string
+	// Mirrors are registry mirrors, or pull-through caches, that images are pulled from before the
+	// registry of their reference. They are tried in order.
+	Mirrors []string
+	// Arch is the architecture, such as amd64 or arm64, whose manifest is pulled when the image is
+	// an index of the images for several architectures. It is that of the machine when it isn't set.
+	Arch string
+	// Cosign is how the signature of the image is verified before it is written, it isn't verified
+	// when Cosign has neither a public key nor an identity.
+	Cosign Cosign
+	// CACert is the PEM of CA certificates that the TLS certificates of registries are verified
+	// against, as well as those of the system.
+	CACert []byte
+	// Insecure are the registries, host[:port], whose TLS certificates aren't verified and that are
+	// pulled from over plain HTTP when they don't serve HTTPS. It is all registries when it has "*".
+	Insecure []string
+}
+
 // Write will pull an image and write it to local storage device
 // with compress set to true it will use gzip compression to expand the data before
 // writing to an underlying device.
-func Write(sourceImage, destinationDevice string, compressed bool) error {
+func Write(sourceImage, destinationDevice string, compressed bool, opts Options) error {
 	ctx := context.Background()
-	client := http.DefaultClient
-	opts := docker.ResolverOptions{}
-	client.Transport = &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true, //nolint:gosec // GA402 TODO
-		},
+	transport, err := newRegistryTransport(opts.CACert, opts.Insecure)
+	if err != nil {
+		return err
 	}
+	client := &http.Client{Transport: transport}
 
-	opts.Client = client
+	platform, err := platformSpec(opts.Arch)
+	if err != nil {
+		return err
+	}
 
-	resolver := docker.NewResolver(opts)
+	creds, err := newCredentials(opts, sourceImage)
+	if err != nil {
+		return err
+	}
+	authorizer := docker.NewDockerAuthorizer(docker.WithAuthClient(client), docker.WithAuthCreds(creds.lookup))
+	hosts, err := registryHosts(opts.Mirrors, client, authorizer)
+	if err != nil {
+		return err
+	}
+	resolver := resumingResolver{docker.NewResolver(docker.ResolverOptions{Hosts: hosts})}
 
 	fileOut, err := os.OpenFile(destinationDevice, os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
 		return err
 	}
 	defer fileOut.Close()
-	customMediaType := "application/vnd.oci.image.layer.v1.tar"
-	allowedMediaTypes := []string{customMediaType}
+	allowedMediaTypes := make([]string, 0, len(layerMediaTypes))
+	for mediaType := range layerMediaTypes {
+		allowedMediaTypes = append(allowedMediaTypes, mediaType)
+	}
 
 	f := NewDiskImageStore(sourceImage, compressed, fileOut)
 
@@ -67,7 +107,7 @@ func Write(sourceImage, destinationDevice string, compressed bool) error {
 		oras.WithAllowedMediaTypes(allowedMediaTypes),
 		oras.WithPullStatusTrack(os.Stdout),
 	}
-	_, _, err = oras.Pull(ctx, resolver, sourceImage, f, pullOpts...)
+	name, desc, err := resolver.Resolve(ctx, sourceImage)
 	if err != nil {
 		if errors.Is(err, reference.ErrObjectRequired) {
 			return fmt.Errorf("image reference format is invalid. Please specify <name:tag|name@digest>")
@@ -75,6 +115,22 @@ func Write(sourceImage, destinationDevice string, compressed bool) error {
 		return err
 	}
 
+	// The image is verified before any of it is written to the disk, and then pulled by the digest
+	// that was verified
+	if opts.Cosign.enabled() {
+		if err := verifySignature(ctx, resolver, name, desc, opts.Cosign); err != nil {
+			return fmt.Errorf("cosign verification failed, the image isn't written -> %w", err)
+		}
+	}
+
+	ref, err := resolvePlatform(ctx, resolver, name, desc, platform)
+	if err != nil {
+		return err
+	}
+	if _, _, err = oras.Pull(ctx, resolver, ref, f, pullOpts...); err != nil {
+		return err
+	}
+
 	// Do the equivalent of partprobe on the device
 	if err := fileOut.Sync(); err != nil {
 		log.Warnf("Failed to sync the block device")
@@ -111,14 +167,14 @@ func findDecompressor(imageURL string, r io.Reader) (out io.Reader, err error) {
 		// The xz reader doesn't implement close()
 		// defer xzOUT.Close()
 		out = xzOUT
-	case ".zs":
+	case ".zs", ".zst":
 		zsOUT, zsErr := zstd.NewReader(r)
 		if zsErr != nil {
 			err = fmt.Errorf("[ERROR] New zs reader: %w", zsErr)
 			return
 		}
-		defer zsOUT.Close()
-		out = zsOUT
+		// The decoder is closed by the reader once it has been read, closing it here would stop it
+		out = zsOUT.IOReadCloser()
 	default:
 		err = fmt.Errorf("unknown compression suffix [%s]", filepath.Ext(imageURL))
 	}
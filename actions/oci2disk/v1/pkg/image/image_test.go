@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"compress/gzip"
 	"io"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
 )
 
@@ -42,6 +45,18 @@ func xzReader(t *testing.T) io.Reader {
 	return rdata
 }
 
+func zstdReader(t *testing.T) io.Reader {
+	t.Helper()
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	return bytes.NewReader(enc.EncodeAll([]byte("YourDataHere"), nil))
+}
+
 func Test_findDecompressor(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -71,6 +86,20 @@ func Test_findDecompressor(t *testing.T) {
 			nil,
 			false,
 		},
+		{
+			"zstd",
+			"http://192.168.0.1/a.zst",
+			zstdReader,
+			nil,
+			false,
+		},
+		{
+			"zstd with the short suffix",
+			"http://192.168.0.1/a.zs",
+			zstdReader,
+			nil,
+			false,
+		},
 		{
 			"unknown",
 			"http://192.168.0.1/a.abc",
@@ -82,11 +111,55 @@ func Test_findDecompressor(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := findDecompressor(tt.imageURL, tt.reader(t))
+			out, err := findDecompressor(tt.imageURL, tt.reader(t))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("findDecompressor() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if err != nil {
+				return
+			}
+			if data, err := ioutil.ReadAll(out); err != nil || string(data) != "YourDataHere" {
+				t.Errorf("findDecompressor() read %q, %v, want the data", data, err)
+			}
+		})
+	}
+}
+
+func TestWriteLayerMediaTypes(t *testing.T) {
+	var gz bytes.Buffer
+	gzW := gzip.NewWriter(&gz)
+	_, _ = gzW.Write([]byte("YourDataHere"))
+	_ = gzW.Close()
+	zs, _ := ioutil.ReadAll(zstdReader(t))
+
+	tests := []struct {
+		name      string
+		mediaType string
+		layer     []byte
+	}{
+		{"raw", "application/vnd.oci.image.layer.v1.tar", []byte("YourDataHere")},
+		{"gzip", "application/vnd.oci.image.layer.v1.tar+gzip", gz.Bytes()},
+		{"zstd", "application/vnd.oci.image.layer.v1.tar+zstd", zs},
+		{"zstd blob", "application/zstd", zs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newTestRegistry(t, tt.mediaType, tt.layer)
+
+			// The media type says how the layer is compressed, without COMPRESSED or a suffix
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := Write(registry.host()+"/images/debian:raw", disk, false, Options{CACert: registry.caCert()}); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			written, err := ioutil.ReadFile(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(written) != "YourDataHere" {
+				t.Errorf("Write() wrote %q, want the decompressed layer", written)
+			}
 		})
 	}
 }
@@ -0,0 +1,67 @@
+package image
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/containerd/containerd/remotes/docker"
+)
+
+// parseMirror returns the registry host of a mirror, which is a host with an optional scheme and
+// path, such as mirror.example.com:5000 or http://cache.example.com/dockerhub.
+func parseMirror(mirror string) (docker.RegistryHost, error) {
+	if !strings.Contains(mirror, "://") {
+		mirror = "https://" + mirror
+	}
+
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return docker.RegistryHost{}, fmt.Errorf("invalid registry mirror [%s] -> %w", mirror, err)
+	}
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return docker.RegistryHost{}, fmt.Errorf("invalid registry mirror [%s]", mirror)
+	}
+
+	return docker.RegistryHost{
+		Host:   u.Host,
+		Scheme: u.Scheme,
+		Path:   strings.TrimSuffix(u.Path, "/") + "/v2",
+		// The mirrors are private mirrors, trusted to resolve tags as well as to serve blobs
+		Capabilities: docker.HostCapabilityPull | docker.HostCapabilityResolve,
+	}, nil
+}
+
+// registryHosts returns the hosts that the images of a registry are pulled from. They are the
+// mirrors in order and then the registry itself, and the resolver falls back to the next one when a
+// host can't be reached or doesn't have the image. The images of a mirror are only pulled from it.
+func registryHosts(mirrors []string, client *http.Client, authorizer docker.Authorizer) (docker.RegistryHosts, error) {
+	upstream := docker.ConfigureDefaultRegistries(docker.WithClient(client), docker.WithAuthorizer(authorizer))
+
+	var hosts []docker.RegistryHost
+	for _, m := range mirrors {
+		host, err := parseMirror(m)
+		if err != nil {
+			return nil, err
+		}
+		host.Client = client
+		host.Authorizer = authorizer
+		hosts = append(hosts, host)
+	}
+
+	return func(registry string) ([]docker.RegistryHost, error) {
+		for _, host := range hosts {
+			if host.Host == registry {
+				return upstream(registry)
+			}
+		}
+
+		registryHosts, err := upstream(registry)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(append([]docker.RegistryHost{}, hosts...), registryHosts...), nil
+	}, nil
+}
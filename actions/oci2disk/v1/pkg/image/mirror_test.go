@@ -0,0 +1,217 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testRegistry is a registry that serves images, by tag and by digest.
+type testRegistry struct {
+	*httptest.Server
+	manifests map[string]testManifest
+	blobs     map[digest.Digest][]byte
+	requests  int32
+	// interruptions is how many more downloads of blobs are cut off halfway.
+	interruptions int32
+	// ranges are the ranges that blobs were requested with.
+	mu     sync.Mutex
+	ranges []string
+}
+
+// testManifest is a manifest, or an index, of the test registry.
+type testManifest struct {
+	mediaType string
+	data      []byte
+}
+
+// testModTime is the modification time of the content of the test registry.
+var testModTime = time.Unix(0, 0)
+
+// newTestRegistry returns a registry with the image images/debian:raw, with a layer of data.
+func newTestRegistry(t *testing.T, layerMediaType string, layer []byte) *testRegistry {
+	t.Helper()
+
+	r := &testRegistry{manifests: map[string]testManifest{}, blobs: map[digest.Digest][]byte{}}
+	r.addImage(t, "raw", layerMediaType, layer)
+
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+// addImage adds an image with a layer of data as tag, and returns the descriptor of its manifest.
+func (r *testRegistry) addImage(t *testing.T, tag, layerMediaType string, layer []byte) ocispec.Descriptor {
+	t.Helper()
+
+	config := []byte("{}")
+	r.blobs[digest.FromBytes(config)] = config
+	r.blobs[digest.FromBytes(layer)] = layer
+
+	return r.addManifest(t, tag, ocispec.MediaTypeImageManifest, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ocispec.MediaTypeImageManifest,
+		"config": map[string]interface{}{
+			"mediaType": "application/vnd.unknown.config.v1+json",
+			"digest":    digest.FromBytes(config),
+			"size":      len(config),
+		},
+		"layers": []map[string]interface{}{{
+			"mediaType":   layerMediaType,
+			"digest":      digest.FromBytes(layer),
+			"size":        len(layer),
+			"annotations": map[string]string{"org.opencontainers.image.title": "disk.raw"},
+		}},
+	})
+}
+
+// addManifest adds the manifest, or index, v as tag and returns its descriptor.
+func (r *testRegistry) addManifest(t *testing.T, tag, mediaType string, v interface{}) ocispec.Descriptor {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+	r.manifests[tag] = testManifest{mediaType: mediaType, data: data}
+	r.manifests[desc.Digest.String()] = r.manifests[tag]
+
+	return desc
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&r.requests, 1)
+	name := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+
+	switch {
+	case strings.Contains(req.URL.Path, "/manifests/"):
+		m, ok := r.manifests[name]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(m.data).String())
+		http.ServeContent(w, req, "", testModTime, bytes.NewReader(m.data))
+	case strings.Contains(req.URL.Path, "/blobs/"):
+		blob, ok := r.blobs[digest.Digest(name)]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		r.mu.Lock()
+		r.ranges = append(r.ranges, req.Header.Get("Range"))
+		r.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if req.Method == http.MethodGet && atomic.AddInt32(&r.interruptions, -1) >= 0 {
+			// The connection is closed after half of the blob
+			w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+			_, _ = w.Write(blob[:len(blob)/2])
+			w.(http.Flusher).Flush()
+			if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		http.ServeContent(w, req, "", testModTime, bytes.NewReader(blob))
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// caCert returns the PEM of the CA certificate of the registry.
+func (r *testRegistry) caCert() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.Certificate().Raw})
+}
+
+// host returns the host of the registry, as it is in image references.
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "https://")
+}
+
+func Test_parseMirror(t *testing.T) {
+	tests := []struct {
+		name       string
+		mirror     string
+		wantHost   string
+		wantScheme string
+		wantPath   string
+		wantErr    bool
+	}{
+		{"host", "mirror.example.com:5000", "mirror.example.com:5000", "https", "/v2", false},
+		{"http with a path", "http://cache.example.com/dockerhub/", "cache.example.com", "http", "/dockerhub/v2", false},
+		{"unsupported scheme", "ftp://mirror.example.com", "", "", "", true},
+		{"no host", "https://", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, err := parseMirror(tt.mirror)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMirror() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if host.Host != tt.wantHost || host.Scheme != tt.wantScheme || host.Path != tt.wantPath {
+				t.Errorf("parseMirror() = %s://%s%s, want %s://%s%s", host.Scheme, host.Host, host.Path, tt.wantScheme, tt.wantHost, tt.wantPath)
+			}
+		})
+	}
+}
+
+func Test_registryHosts(t *testing.T) {
+	hosts, err := registryHosts([]string{"mirror-a.example.com", "http://mirror-b.example.com"}, http.DefaultClient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := hosts("docker.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, h := range got {
+		names = append(names, h.Host)
+	}
+	if strings.Join(names, ",") != "mirror-a.example.com,mirror-b.example.com,registry-1.docker.io" {
+		t.Errorf("hosts(docker.io) = %v, want the mirrors in order and then the registry", names)
+	}
+
+	if got, _ := hosts("mirror-b.example.com"); len(got) != 1 {
+		t.Errorf("hosts() of a mirror = %d hosts, want only the mirror", len(got))
+	}
+}
+
+func TestWriteMirrors(t *testing.T) {
+	layer := []byte("the disk image")
+	registry := newTestRegistry(t, "application/vnd.oci.image.layer.v1.tar", layer)
+
+	// The first mirror can't be reached and the registry doesn't exist, the image comes from the second mirror
+	disk := filepath.Join(t.TempDir(), "disk")
+	opts := Options{Mirrors: []string{"127.0.0.1:1", registry.host()}, CACert: registry.caCert()}
+	if err := Write("registry.invalid/images/debian:raw", disk, false, opts); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	written, err := ioutil.ReadFile(disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, layer) {
+		t.Errorf("Write() wrote %q, want %q", written, layer)
+	}
+}
@@ -0,0 +1,94 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxIndexSize is the largest image index that is read, indexes are a few KiB.
+const maxIndexSize = 4 << 20
+
+// platformSpec returns the platform that images are pulled for, which is that of the machine unless
+// arch is set, such as amd64, arm64 or linux/arm/v7.
+func platformSpec(arch string) (ocispec.Platform, error) {
+	if arch == "" {
+		return platforms.DefaultSpec(), nil
+	}
+
+	spec, err := platforms.Parse(arch)
+	if err != nil {
+		return ocispec.Platform{}, fmt.Errorf("invalid architecture [%s] -> %w", arch, err)
+	}
+
+	return spec, nil
+}
+
+// resolvePlatform returns the reference that the image desc of name is pulled with, which is pinned
+// to its digest. When it is an image index, of an image for several architectures, that is the
+// reference of the manifest of the image for platform.
+func resolvePlatform(ctx context.Context, resolver remotes.Resolver, name string, desc ocispec.Descriptor, platform ocispec.Platform) (string, error) {
+	spec, err := reference.Parse(name)
+	if err != nil {
+		return "", err
+	}
+	if desc.MediaType != ocispec.MediaTypeImageIndex && desc.MediaType != images.MediaTypeDockerSchema2ManifestList {
+		return spec.Locator + "@" + desc.Digest.String(), nil
+	}
+
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	data, err := fetchVerified(ctx, fetcher, desc, maxIndexSize)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the image index -> %w", err)
+	}
+
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return "", fmt.Errorf("failed to parse the image index -> %w", err)
+	}
+
+	manifest, err := selectManifest(index, platform)
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("Image [%s] is an index, pulling the manifest [%s] for [%s]", name, manifest.Digest, platforms.Format(platform))
+
+	return spec.Locator + "@" + manifest.Digest.String(), nil
+}
+
+// selectManifest returns the first manifest of the index for platform. The platforms of the
+// manifests may have no OS, as disk images are for any OS of the architecture.
+func selectManifest(index ocispec.Index, platform ocispec.Platform) (ocispec.Descriptor, error) {
+	matcher := platforms.NewMatcher(platform)
+
+	var available []string
+	for _, m := range index.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+
+		p := *m.Platform
+		if p.OS == "" {
+			p.OS = platform.OS
+		}
+		if matcher.Match(p) {
+			return m, nil
+		}
+		available = append(available, platforms.Format(p))
+	}
+
+	return ocispec.Descriptor{}, fmt.Errorf("image index has no manifest for [%s], it has [%s]",
+		platforms.Format(platform), strings.Join(available, ", "))
+}
@@ -0,0 +1,76 @@
+package image
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func Test_selectManifest(t *testing.T) {
+	index := ocispec.Index{Manifests: []ocispec.Descriptor{
+		{Digest: "sha256:a", Platform: &ocispec.Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: "sha256:b", Platform: &ocispec.Platform{Architecture: "arm64"}},
+		{Digest: "sha256:c", Platform: &ocispec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+	}}
+
+	tests := []struct {
+		name    string
+		arch    string
+		want    string
+		wantErr bool
+	}{
+		{"amd64", "amd64", "sha256:a", false},
+		{"arm64 without an OS", "arm64", "sha256:b", false},
+		{"arm64 with a variant", "linux/arm64/v8", "sha256:b", false},
+		{"arm", "linux/arm/v7", "sha256:c", false},
+		{"missing", "ppc64le", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platform, err := platformSpec(tt.arch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := selectManifest(index, platform)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Digest.String() != tt.want {
+				t.Errorf("selectManifest() = %s, want %s", got.Digest, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	mediaType := "application/vnd.oci.image.layer.v1.tar"
+	registry := newTestRegistry(t, mediaType, []byte("unused"))
+
+	amd64 := registry.addImage(t, "amd64", mediaType, []byte("the amd64 disk image"))
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := registry.addImage(t, "arm64", mediaType, []byte("the arm64 disk image"))
+	arm64.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	registry.addManifest(t, "multiarch", ocispec.MediaTypeImageIndex, ocispec.Index{
+		Manifests: []ocispec.Descriptor{amd64, arm64},
+	})
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		t.Run(arch, func(t *testing.T) {
+			disk := filepath.Join(t.TempDir(), "disk")
+			if err := Write(registry.host()+"/images/debian:multiarch", disk, false, Options{Arch: arch, CACert: registry.caCert()}); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			written, err := ioutil.ReadFile(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []byte("the " + arch + " disk image"); !bytes.Equal(written, want) {
+				t.Errorf("Write() wrote %q, want %q", written, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/dustin/go-humanize"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxResumes is how many times in a row the download of a blob is resumed without any progress
+// before it fails.
+const maxResumes = 5
+
+// resumeBackoff is how long to wait before the download of a blob is resumed, it grows with the
+// number of attempts in a row.
+var resumeBackoff = time.Second
+
+// resumingResolver is a resolver whose fetchers resume the downloads of blobs that are interrupted,
+// rather than failing the pull.
+type resumingResolver struct {
+	remotes.Resolver
+}
+
+// Fetcher returns a fetcher for ref, that resumes downloads.
+func (r resumingResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := r.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return resumingFetcher{fetcher}, nil
+}
+
+// resumingFetcher fetches blobs with readers that resume their downloads.
+type resumingFetcher struct {
+	remotes.Fetcher
+}
+
+// Fetch returns a reader of the blob desc.
+func (f resumingFetcher) Fetch(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	rc, err := f.Fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resumingReader{ctx: ctx, fetcher: f.Fetcher, desc: desc, rc: rc}, nil
+}
+
+// resumingReader reads a blob, and when its download is interrupted it fetches the blob again from
+// the offset that was read up to with a range request. Everything before the offset has already been
+// passed on to be written to the disk, so the disk picks up where it stopped.
+type resumingReader struct {
+	ctx     context.Context
+	fetcher remotes.Fetcher
+	desc    ocispec.Descriptor
+	rc      io.ReadCloser
+
+	offset   int64
+	attempts int
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.rc.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			r.attempts = 0
+		}
+
+		// The blob ended before its size when the connection was closed early
+		if err == io.EOF && r.desc.Size > 0 && r.offset < r.desc.Size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		if resumeErr := r.resume(err); resumeErr != nil {
+			return n, resumeErr
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume fetches the rest of the blob after its download failed with err.
+func (r *resumingReader) resume(err error) error {
+	if r.attempts >= maxResumes || r.ctx.Err() != nil {
+		return fmt.Errorf("download of blob [%s] failed at %s of %s -> %w",
+			r.desc.Digest, humanize.Bytes(uint64(r.offset)), humanize.Bytes(uint64(r.desc.Size)), err)
+	}
+	r.attempts++
+
+	log.Warnf("Download of blob [%s] was interrupted at %s of %s, resuming (attempt %d/%d) -> %v",
+		r.desc.Digest, humanize.Bytes(uint64(r.offset)), humanize.Bytes(uint64(r.desc.Size)), r.attempts, maxResumes, err)
+	r.rc.Close()
+
+	select {
+	case <-time.After(time.Duration(r.attempts) * resumeBackoff):
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	}
+
+	rc, fetchErr := r.fetcher.Fetch(r.ctx, r.desc)
+	if fetchErr != nil {
+		return fmt.Errorf("failed to resume the download of blob [%s] -> %w", r.desc.Digest, fetchErr)
+	}
+	seeker, ok := rc.(io.Seeker)
+	if !ok {
+		rc.Close()
+		return errors.New("the download of the blob can't be resumed from an offset")
+	}
+	if _, err := seeker.Seek(r.offset, io.SeekStart); err != nil {
+		rc.Close()
+		return fmt.Errorf("failed to resume the download of blob [%s] -> %w", r.desc.Digest, err)
+	}
+	r.rc = rc
+
+	return nil
+}
+
+// Close closes the download of the blob.
+func (r *resumingReader) Close() error {
+	return r.rc.Close()
+}
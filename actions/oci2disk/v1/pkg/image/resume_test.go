@@ -0,0 +1,55 @@
+package image
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteResume(t *testing.T) {
+	backoff := resumeBackoff
+	defer func() { resumeBackoff = backoff }()
+	resumeBackoff = time.Millisecond
+
+	layer := bytes.Repeat([]byte("the disk image "), 64<<10)
+
+	tests := []struct {
+		name          string
+		interruptions int32
+		wantErr       bool
+	}{
+		{"interrupted once", 1, false},
+		{"interrupted until it fails", maxResumes + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newTestRegistry(t, "application/vnd.oci.image.layer.v1.tar", layer)
+			registry.interruptions = tt.interruptions
+
+			disk := filepath.Join(t.TempDir(), "disk")
+			err := Write(registry.host()+"/images/debian:raw", disk, false, Options{CACert: registry.caCert()})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			written, err := ioutil.ReadFile(disk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(written, layer) {
+				t.Errorf("Write() wrote %d bytes, want the %d bytes of the image", len(written), len(layer))
+			}
+
+			// The blob was requested again from where it was cut off, rather than from the start
+			if last := registry.ranges[len(registry.ranges)-1]; !strings.HasPrefix(last, "bytes=") || last == "bytes=0-" {
+				t.Errorf("Write() resumed the download with range [%s], want the rest of the blob", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package image
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// registryTransport is the transport of the requests to registries. The TLS certificates of
+// registries are verified against the CA certificates of the system and the extra ones, except for
+// the insecure registries, which are also pulled from over plain HTTP when they don't serve HTTPS.
+type registryTransport struct {
+	secure   *http.Transport
+	insecure *http.Transport
+	// insecureHosts are the insecure registries, or "*" for all registries.
+	insecureHosts []string
+}
+
+// newRegistryTransport returns the transport of the requests to registries, that trusts the PEM CA
+// certificates of caCert and doesn't verify the insecure registries.
+func newRegistryTransport(caCert []byte, insecure []string) (*registryTransport, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	if len(caCert) > 0 && !roots.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("the registry CA certificate has no PEM certificates")
+	}
+
+	t := &registryTransport{
+		secure:        http.DefaultTransport.(*http.Transport).Clone(),
+		insecure:      http.DefaultTransport.(*http.Transport).Clone(),
+		insecureHosts: insecure,
+	}
+	t.secure.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	t.insecure.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // the registry is set as insecure
+
+	return t, nil
+}
+
+// isInsecure reports whether host is an insecure registry. The registries may be set without their
+// port.
+func (t *registryTransport) isInsecure(host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	for _, h := range t.insecureHosts {
+		if h == "*" || h == host || h == hostname {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RoundTrip sends the request to the registry.
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.isInsecure(req.URL.Host) {
+		return t.secure.RoundTrip(req)
+	}
+
+	resp, err := t.insecure.RoundTrip(req)
+	if err == nil || req.URL.Scheme != "https" || !isHTTPResponseError(err) || req.Body != nil {
+		return resp, err
+	}
+
+	log.Warnf("Registry [%s] doesn't serve HTTPS, pulling from it over plain HTTP", req.URL.Host)
+	plain := req.Clone(req.Context())
+	plain.URL.Scheme = "http"
+
+	return t.insecure.RoundTrip(plain)
+}
+
+// isHTTPResponseError reports whether err is that of a server that replied to HTTPS over plain HTTP.
+func isHTTPResponseError(err error) bool {
+	var header tls.RecordHeaderError
+	if errors.As(err, &header) {
+		return true
+	}
+
+	return strings.Contains(err.Error(), "server gave HTTP response to HTTPS client")
+}
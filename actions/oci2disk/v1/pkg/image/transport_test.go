@@ -0,0 +1,56 @@
+package image
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryTransport(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+
+	tlsCA := (&testRegistry{Server: tlsServer}).caCert()
+	tlsHost := strings.TrimPrefix(tlsServer.URL, "https://")
+	plainHost := strings.TrimPrefix(plainServer.URL, "http://")
+
+	tests := []struct {
+		name     string
+		url      string
+		caCert   []byte
+		insecure []string
+		wantErr  bool
+	}{
+		{"unknown CA", "https://" + tlsHost + "/v2/", nil, nil, true},
+		{"registry CA", "https://" + tlsHost + "/v2/", tlsCA, nil, false},
+		{"insecure registry", "https://" + tlsHost + "/v2/", nil, []string{tlsHost}, false},
+		{"insecure registry without its port", "https://" + tlsHost + "/v2/", nil, []string{"127.0.0.1"}, false},
+		{"all registries insecure", "https://" + tlsHost + "/v2/", nil, []string{"*"}, false},
+		{"plain HTTP", "https://" + plainHost + "/v2/", nil, nil, true},
+		{"insecure plain HTTP", "https://" + plainHost + "/v2/", nil, []string{plainHost}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := newRegistryTransport(tt.caCert, tt.insecure)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := (&http.Client{Transport: transport}).Get(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		})
+	}
+
+	if _, err := newRegistryTransport([]byte("not a certificate"), nil); err == nil {
+		t.Error("newRegistryTransport() with an invalid CA certificate succeeded")
+	}
+}
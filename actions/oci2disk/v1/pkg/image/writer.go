@@ -12,6 +12,16 @@ import (
 	"github.com/opencontainers/go-digest"
 )
 
+// layerMediaTypes are the media types of the layers that are written to the disk, with the suffix
+// of the compression that the media type says the layer has. The layers of the plain media type are
+// decompressed when the image is set as compressed, by the suffix of the image.
+var layerMediaTypes = map[string]string{
+	"application/vnd.oci.image.layer.v1.tar":      "",
+	"application/vnd.oci.image.layer.v1.tar+gzip": ".gz",
+	"application/vnd.oci.image.layer.v1.tar+zstd": ".zst",
+	"application/zstd":                            ".zst",
+}
+
 // DiskImageStore -.
 type DiskImageStore struct {
 	sourceImage string
@@ -125,7 +135,11 @@ func (d DiskImageStore) Writer(_ context.Context, opts ...ctrcontent.WriterOpt)
 	if desc.Annotations["org.opencontainers.image.title"] == "" {
 		return content.NewIoContentWriter(ioutil.Discard, content.WithOutputHash(desc.Digest)), nil
 	}
-	if !d.compressed {
+	compression := layerMediaTypes[desc.MediaType]
+	if compression == "" && d.compressed {
+		compression = d.sourceImage
+	}
+	if compression == "" {
 		// Without compression send raw output
 		f = func(r io.Reader, w io.Writer, done chan<- error) {
 			var err error
@@ -136,17 +150,20 @@ func (d DiskImageStore) Writer(_ context.Context, opts ...ctrcontent.WriterOpt)
 	} else {
 		f = func(r io.Reader, w io.Writer, done chan<- error) {
 			var err error
-			decompressReader, err := findDecompressor(d.sourceImage, r)
+			decompressReader, err := findDecompressor(compression, r)
 			if err != nil {
 				log.Fatalf(err.Error()) //nolint:revive // this is fine
 			}
 			b := make([]byte, wOpts.Blocksize)
 			_, err = io.CopyBuffer(w, decompressReader, b)
+			if closer, ok := decompressReader.(io.Closer); ok {
+				closer.Close()
+			}
 			done <- err
 		}
 	}
 	writerOpts := []content.WriterOpt{}
-	return content.NewPassthroughWriter(di, f, writerOpts...), nil
+	return verifyingWriter{Writer: content.NewPassthroughWriter(di, f, writerOpts...), expected: desc.Digest}, nil
 	// return nil, err
 }
 
@@ -197,3 +214,22 @@ func (w *DiskImage) Status() (ctrcontent.Status, error) {
 func (w *DiskImage) Truncate(int64) error {
 	return nil
 }
+
+// verifyingWriter fails the commit of a blob that doesn't have the digest of its descriptor, as the
+// blob is streamed to the disk rather than to a content store that would verify it.
+type verifyingWriter struct {
+	ctrcontent.Writer
+	expected digest.Digest
+}
+
+// Commit commits the blob, and checks the digest of what was written.
+func (w verifyingWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...ctrcontent.Opt) error {
+	if err := w.Writer.Commit(ctx, size, expected, opts...); err != nil {
+		return err
+	}
+	if dgst := w.Writer.Digest(); w.expected != "" && dgst != w.expected {
+		return fmt.Errorf("blob written to disk has digest %s, expected %s", dgst, w.expected)
+	}
+
+	return nil
+}
@@ -1,12 +1,12 @@
 module github.com/tinkerbell/hub/actions/writefile/v1
 
-go 1.15
+go 1.19
 
 require (
-	github.com/kr/text v0.2.0 // indirect
 	github.com/sirupsen/logrus v1.7.0
-	github.com/stretchr/testify v1.7.0 // indirect
-	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c // indirect
-	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	github.com/tetratelabs/wazero v1.5.0
+	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
+
+require github.com/stretchr/testify v1.7.0 // indirect
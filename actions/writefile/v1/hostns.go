@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// hostMountNamespaceEnv is set on the re-executed action to the mount namespace it has to enter,
+// the namespace is entered before the Go runtime starts (see hostns_cgo.go) as a multi-threaded
+// process can't change its mount namespace.
+const hostMountNamespaceEnv = "_WRITEFILE_MNTNS_PATH"
+
+// inHostMountNamespace reports whether this process is the re-executed action.
+func inHostMountNamespace() bool {
+	return os.Getenv(hostMountNamespaceEnv) != ""
+}
+
+// reexecInMountNamespace runs the action again inside the mount namespace of pid, waits for it to
+// finish and returns its exit code. The namespace of this process is never changed, so nothing
+// needs restoring once the re-executed action exits.
+func reexecInMountNamespace(pid int) (int, error) {
+	if !mountNamespaceSupported {
+		return 0, errors.New("entering a mount namespace requires the action to be built with cgo")
+	}
+
+	nsPath := fmt.Sprintf("/proc/%d/ns/mnt", pid)
+	if _, err := os.Stat(nsPath); err != nil {
+		return 0, fmt.Errorf("failed to find mount namespace of pid %d: %w", pid, err)
+	}
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Args = os.Args
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", hostMountNamespaceEnv, nsPath))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to re-execute in mount namespace %s: %w", nsPath, err)
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,38 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+/*
+#define _GNU_SOURCE
+#include <fcntl.h>
+#include <sched.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <unistd.h>
+
+// enter_mount_namespace runs before the Go runtime has started any threads, which is the only
+// point at which setns(2) can move the process into another mount namespace.
+__attribute__((constructor)) static void enter_mount_namespace(void) {
+	const char *path = getenv("_WRITEFILE_MNTNS_PATH");
+	if (path == NULL || *path == '\0') {
+		return;
+	}
+
+	int fd = open(path, O_RDONLY | O_CLOEXEC);
+	if (fd < 0) {
+		perror("writefile: failed to open mount namespace");
+		exit(1);
+	}
+
+	if (setns(fd, CLONE_NEWNS) < 0) {
+		perror("writefile: failed to enter mount namespace");
+		exit(1);
+	}
+
+	close(fd);
+}
+*/
+import "C"
+
+const mountNamespaceSupported = true
@@ -0,0 +1,321 @@
+// Package config parses the environment variables of the writefile action into its configuration.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/hegel"
+)
+
+// The defaults of the CONTENT_WASM limits.
+const (
+	DefaultContentWASMTimeout  = 30 * time.Second
+	DefaultContentWASMMemoryMB = 64
+)
+
+// Config is the configuration of the action. Values such as modes, ACLs and manifests are kept as
+// they are given, they are parsed along with the files they apply to.
+type Config struct {
+	// DestDisk is the block device, or the LABEL=, UUID= or PARTLABEL= of one, the files are written to.
+	DestDisk string
+	FSType   string
+	DestPath string
+	DestRoot string
+
+	Contents           string
+	CmdlineContentsKey string
+	ContentsURL        string
+	LinkTarget         string
+	Delete             bool
+	Manifest           string
+	ManifestURL        string
+	MetadataManifest   string
+
+	// The defaults of every file written, a manifest file can override them.
+	UID              string
+	GID              string
+	User             string
+	Group            string
+	Mode             string
+	DirMode          string
+	ParentDirMode    string
+	DirSpec          string
+	WriteMode        string
+	ContentsEncoding string
+	SELinuxContext   string
+	Xattrs           string
+	ACL              string
+
+	InheritParentOwner bool
+	Atomic             bool
+	LinkForce          bool
+	Backup             bool
+	SkipUnchanged      bool
+	VerifyMetadata     bool
+	StrictPerms        bool
+	DryRun             bool
+
+	MountType     string
+	MountOptions  string
+	OverlayLower  string
+	OverlayUpper  string
+	OverlayWork   string
+	AbortFlagFile string
+
+	LUKSPassphrase      string
+	LUKSKeyfileContents string
+
+	HegelURLs               []string
+	HegelRetries            int
+	HegelTimeout            time.Duration
+	HegelCACert             string
+	HegelClientCert         string
+	HegelClientKey          string
+	HegelInsecureSkipVerify bool
+	HegelHeaders            http.Header
+	HegelAuthToken          string
+	HegelPath               string
+	UserDataSHA256          string
+
+	Template            bool
+	ContentWASM         string
+	ContentWASMTimeout  time.Duration
+	ContentWASMMemoryMB int
+	NullTerminate       bool
+	// PadToBytes is the size the contents are padded to with zeros, nil when they aren't padded.
+	PadToBytes *int
+}
+
+// env looks up the variables of an environment.
+type env map[string]string
+
+func (e env) bool(key string, value *bool) error {
+	raw, exists := e[key]
+	if !exists {
+		return nil
+	}
+
+	parsed, err := strconv.ParseBool(raw)
+	if err != nil {
+		return fmt.Errorf("parsing failed for environment variable [%s].  %v", key, err)
+	}
+
+	*value = parsed
+
+	return nil
+}
+
+// int parses key into value when it is set, it must be at least min.
+func (e env) int(key string, value *int, min int, unit string) error {
+	raw, exists := e[key]
+	if !exists {
+		return nil
+	}
+
+	parsed, err := strconv.Atoi(raw)
+	if err != nil || parsed < min {
+		qualifier := "positive"
+		if min == 0 {
+			qualifier = "non-negative"
+		}
+
+		return fmt.Errorf("parsing failed for environment variable [%s], must be a %s number of %s", key, qualifier, unit)
+	}
+
+	*value = parsed
+
+	return nil
+}
+
+// FromEnv returns the configuration of the action from environ, a list of KEY=VALUE variables as
+// os.Environ returns them. It only validates what can be told from the variables themselves, such as
+// values that don't parse and variables that can't be combined.
+func FromEnv(environ []string) (*Config, error) {
+	e := env{}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			e[parts[0]] = parts[1]
+		}
+	}
+
+	strictEnv := false
+	if err := e.bool("STRICT_ENV", &strictEnv); err != nil {
+		return nil, err
+	}
+
+	if strictEnv {
+		if err := checkStrictEnv(environ); err != nil {
+			return nil, fmt.Errorf("strict environment check failed: %v", err)
+		}
+	}
+
+	c := &Config{
+		DestDisk:            e["DEST_DISK"],
+		FSType:              e["FS_TYPE"],
+		DestPath:            e["DEST_PATH"],
+		DestRoot:            e["DEST_ROOT"],
+		Contents:            e["CONTENTS"],
+		CmdlineContentsKey:  e["CMDLINE_CONTENTS_KEY"],
+		ContentsURL:         e["CONTENTS_URL"],
+		LinkTarget:          e["LINK_TARGET"],
+		Manifest:            e["MANIFEST"],
+		ManifestURL:         e["MANIFEST_URL"],
+		MetadataManifest:    e["METADATA_MANIFEST"],
+		UID:                 e["UID"],
+		GID:                 e["GID"],
+		User:                e["USER"],
+		Group:               e["GROUP"],
+		Mode:                e["MODE"],
+		DirMode:             e["DIRMODE"],
+		ParentDirMode:       e["PARENT_DIR_MODE"],
+		DirSpec:             e["DIRSPEC"],
+		WriteMode:           e["WRITE_MODE"],
+		ContentsEncoding:    e["CONTENTS_ENCODING"],
+		SELinuxContext:      e["SELINUX_CONTEXT"],
+		Xattrs:              e["XATTRS"],
+		ACL:                 e["ACL"],
+		MountType:           e["MOUNT_TYPE"],
+		MountOptions:        e["MOUNT_OPTIONS"],
+		OverlayLower:        e["OVERLAY_LOWER"],
+		OverlayUpper:        e["OVERLAY_UPPER"],
+		OverlayWork:         e["OVERLAY_WORK"],
+		AbortFlagFile:       e["ABORT_FLAG_FILE"],
+		LUKSPassphrase:      e["LUKS_PASSPHRASE"],
+		LUKSKeyfileContents: e["LUKS_KEYFILE_CONTENTS"],
+		HegelURLs:           hegel.ParseURLs(e["HEGEL_URLS"]),
+		HegelCACert:         e["HEGEL_CA_CERT"],
+		HegelClientCert:     e["HEGEL_CLIENT_CERT"],
+		HegelClientKey:      e["HEGEL_CLIENT_KEY"],
+		HegelAuthToken:      e["HEGEL_AUTH_TOKEN"],
+		HegelPath:           e["HEGEL_PATH"],
+		UserDataSHA256:      e["USERDATA_SHA256"],
+		ContentWASM:         e["CONTENT_WASM"],
+		ContentWASMTimeout:  DefaultContentWASMTimeout,
+		ContentWASMMemoryMB: DefaultContentWASMMemoryMB,
+	}
+
+	for _, b := range []struct {
+		key   string
+		value *bool
+	}{
+		{"DRY_RUN", &c.DryRun},
+		{"INHERIT_PARENT_OWNER", &c.InheritParentOwner},
+		{"ATOMIC", &c.Atomic},
+		{"DELETE", &c.Delete},
+		{"LINK_FORCE", &c.LinkForce},
+		{"BACKUP", &c.Backup},
+		{"SKIP_UNCHANGED", &c.SkipUnchanged},
+		{"VERIFY_METADATA", &c.VerifyMetadata},
+		{"STRICT_PERMS", &c.StrictPerms},
+		{"HEGEL_INSECURE_SKIP_VERIFY", &c.HegelInsecureSkipVerify},
+		{"TEMPLATE", &c.Template},
+		{"NULL_TERMINATE", &c.NullTerminate},
+	} {
+		if err := e.bool(b.key, b.value); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.DestDisk == "" {
+		return nil, errors.New("no block device specified with environment variable [DEST_DISK]")
+	}
+
+	switch c.MountType {
+	case "":
+	case "overlay":
+		if c.OverlayLower == "" || c.OverlayUpper == "" || c.OverlayWork == "" {
+			return nil, errors.New("MOUNT_TYPE [overlay] requires [OVERLAY_LOWER], [OVERLAY_UPPER] and [OVERLAY_WORK] to be set")
+		}
+	default:
+		return nil, fmt.Errorf("unknown mount type [%s], MOUNT_TYPE must be empty or [overlay]", c.MountType)
+	}
+
+	if c.UID != "" && c.User != "" {
+		return nil, errors.New("only one of [UID] and [USER] may be set")
+	}
+
+	if c.GID != "" && c.Group != "" {
+		return nil, errors.New("only one of [GID] and [GROUP] may be set")
+	}
+
+	if err := e.int("HEGEL_RETRIES", &c.HegelRetries, 0, "retries"); err != nil {
+		return nil, err
+	}
+
+	hegelTimeoutSeconds := 0
+	if err := e.int("HEGEL_TIMEOUT", &hegelTimeoutSeconds, 1, "seconds"); err != nil {
+		return nil, err
+	}
+
+	c.HegelTimeout = time.Duration(hegelTimeoutSeconds) * time.Second
+
+	if raw := e["HEGEL_HEADERS"]; raw != "" {
+		headers, err := hegel.ParseHeaders(raw)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse [HEGEL_HEADERS]: %v", err)
+		}
+
+		c.HegelHeaders = headers
+	}
+
+	if c.DestRoot == "" {
+		c.DestRoot = "/"
+	}
+
+	if !filepath.IsAbs(c.DestRoot) {
+		return nil, errors.New("provided DEST_ROOT must be an absolute path")
+	}
+
+	if rel := filepath.Clean(strings.TrimLeft(c.DestRoot, "/")); rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil, errors.New("provided DEST_ROOT must not climb above the root directory with ..")
+	}
+
+	if c.CmdlineContentsKey != "" && c.Contents != "" {
+		return nil, errors.New("only one of [CONTENTS] and [CMDLINE_CONTENTS_KEY] may be set")
+	}
+
+	if c.ContentsURL != "" && (c.Contents != "" || c.CmdlineContentsKey != "") {
+		return nil, errors.New("only one of [CONTENTS], [CMDLINE_CONTENTS_KEY] and [CONTENTS_URL] may be set")
+	}
+
+	if c.Manifest != "" || c.ManifestURL != "" {
+		if c.Manifest != "" && c.ManifestURL != "" {
+			return nil, errors.New("only one of [MANIFEST] and [MANIFEST_URL] may be set")
+		}
+
+		if c.DestPath != "" || c.Contents != "" || c.CmdlineContentsKey != "" || c.ContentsURL != "" || c.LinkTarget != "" || c.Delete {
+			return nil, errors.New("a manifest can't be combined with [DEST_PATH], [CONTENTS], [CMDLINE_CONTENTS_KEY], [CONTENTS_URL], [LINK_TARGET] or [DELETE]")
+		}
+	}
+
+	if c.ContentWASM != "" {
+		timeoutSeconds := int(DefaultContentWASMTimeout / time.Second)
+		if err := e.int("CONTENT_WASM_TIMEOUT_SECONDS", &timeoutSeconds, 1, "seconds"); err != nil {
+			return nil, err
+		}
+
+		c.ContentWASMTimeout = time.Duration(timeoutSeconds) * time.Second
+
+		if err := e.int("CONTENT_WASM_MEMORY_MB", &c.ContentWASMMemoryMB, 1, "megabytes"); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, exists := e["PAD_TO_BYTES"]; exists {
+		padToBytes := 0
+		if err := e.int("PAD_TO_BYTES", &padToBytes, 0, "bytes"); err != nil {
+			return nil, err
+		}
+
+		c.PadToBytes = &padToBytes
+	}
+
+	return c, nil
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFromEnv(t *testing.T) {
+	padded := 512
+
+	tests := []struct {
+		name    string
+		environ []string
+		want    *Config
+		wantErr bool
+	}{
+		{
+			name:    "defaults",
+			environ: []string{"DEST_DISK=/dev/sda1"},
+			want: &Config{
+				DestDisk:            "/dev/sda1",
+				DestRoot:            "/",
+				ContentWASMTimeout:  DefaultContentWASMTimeout,
+				ContentWASMMemoryMB: DefaultContentWASMMemoryMB,
+			},
+		},
+		{
+			name: "file",
+			environ: []string{
+				"DEST_DISK=UUID=1234", "FS_TYPE=ext4", "DEST_PATH=/etc/hostname", "CONTENTS=host=a=b",
+				"UID=0", "GID=0", "MODE=0644", "ATOMIC=true", "BACKUP=1", "DRY_RUN=false", "PAD_TO_BYTES=512",
+			},
+			want: &Config{
+				DestDisk:            "UUID=1234",
+				FSType:              "ext4",
+				DestPath:            "/etc/hostname",
+				DestRoot:            "/",
+				Contents:            "host=a=b",
+				UID:                 "0",
+				GID:                 "0",
+				Mode:                "0644",
+				Atomic:              true,
+				Backup:              true,
+				PadToBytes:          &padded,
+				ContentWASMTimeout:  DefaultContentWASMTimeout,
+				ContentWASMMemoryMB: DefaultContentWASMMemoryMB,
+			},
+		},
+		{
+			name: "hegel",
+			environ: []string{
+				"DEST_DISK=/dev/sda1", "HEGEL_URLS=http://10.0.0.1:50061, http://10.0.0.2:50061", "HEGEL_RETRIES=0",
+				"HEGEL_TIMEOUT=5", "HEGEL_HEADERS=X-Api-Key: abc", "HEGEL_INSECURE_SKIP_VERIFY=true",
+			},
+			want: &Config{
+				DestDisk:                "/dev/sda1",
+				DestRoot:                "/",
+				HegelURLs:               []string{"http://10.0.0.1:50061", "http://10.0.0.2:50061"},
+				HegelTimeout:            5 * time.Second,
+				HegelHeaders:            http.Header{"X-Api-Key": {"abc"}},
+				HegelInsecureSkipVerify: true,
+				ContentWASMTimeout:      DefaultContentWASMTimeout,
+				ContentWASMMemoryMB:     DefaultContentWASMMemoryMB,
+			},
+		},
+		{
+			name:    "wasm limits",
+			environ: []string{"DEST_DISK=/dev/sda1", "CONTENT_WASM=/transform.wasm", "CONTENT_WASM_TIMEOUT_SECONDS=5", "CONTENT_WASM_MEMORY_MB=16"},
+			want: &Config{
+				DestDisk:            "/dev/sda1",
+				DestRoot:            "/",
+				ContentWASM:         "/transform.wasm",
+				ContentWASMTimeout:  5 * time.Second,
+				ContentWASMMemoryMB: 16,
+			},
+		},
+		{name: "no disk", environ: []string{"DEST_PATH=/etc/hostname"}, wantErr: true},
+		{name: "invalid bool", environ: []string{"DEST_DISK=/dev/sda1", "ATOMIC=maybe"}, wantErr: true},
+		{name: "uid and user", environ: []string{"DEST_DISK=/dev/sda1", "UID=0", "USER=root"}, wantErr: true},
+		{name: "gid and group", environ: []string{"DEST_DISK=/dev/sda1", "GID=0", "GROUP=root"}, wantErr: true},
+		{name: "unknown mount type", environ: []string{"DEST_DISK=/dev/sda1", "MOUNT_TYPE=bind"}, wantErr: true},
+		{name: "overlay without dirs", environ: []string{"DEST_DISK=/dev/sda1", "MOUNT_TYPE=overlay", "OVERLAY_LOWER=/lower"}, wantErr: true},
+		{name: "negative retries", environ: []string{"DEST_DISK=/dev/sda1", "HEGEL_RETRIES=-1"}, wantErr: true},
+		{name: "zero timeout", environ: []string{"DEST_DISK=/dev/sda1", "HEGEL_TIMEOUT=0"}, wantErr: true},
+		{name: "invalid headers", environ: []string{"DEST_DISK=/dev/sda1", "HEGEL_HEADERS=X-Api-Key abc"}, wantErr: true},
+		{name: "relative dest root", environ: []string{"DEST_DISK=/dev/sda1", "DEST_ROOT=etc"}, wantErr: true},
+		{name: "dest root above the root", environ: []string{"DEST_DISK=/dev/sda1", "DEST_ROOT=/../etc"}, wantErr: true},
+		{name: "contents and cmdline", environ: []string{"DEST_DISK=/dev/sda1", "CONTENTS=a", "CMDLINE_CONTENTS_KEY=url"}, wantErr: true},
+		{name: "contents and url", environ: []string{"DEST_DISK=/dev/sda1", "CONTENTS=a", "CONTENTS_URL=http://a/b"}, wantErr: true},
+		{name: "both manifests", environ: []string{"DEST_DISK=/dev/sda1", "MANIFEST={}", "MANIFEST_URL=http://a/b"}, wantErr: true},
+		{name: "manifest and dest path", environ: []string{"DEST_DISK=/dev/sda1", "MANIFEST={}", "DEST_PATH=/a"}, wantErr: true},
+		{name: "manifest and delete", environ: []string{"DEST_DISK=/dev/sda1", "MANIFEST={}", "DELETE=true"}, wantErr: true},
+		{name: "zero wasm memory", environ: []string{"DEST_DISK=/dev/sda1", "CONTENT_WASM=/t.wasm", "CONTENT_WASM_MEMORY_MB=0"}, wantErr: true},
+		{name: "negative padding", environ: []string{"DEST_DISK=/dev/sda1", "PAD_TO_BYTES=-1"}, wantErr: true},
+		{name: "strict env with a typo", environ: []string{"DEST_DISK=/dev/sda1", "STRICT_ENV=true", "DEST_DIR=/etc"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromEnv(tt.environ)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// recognizedEnv is every environment variable the action reads.
+var recognizedEnv = []string{
+	"ABORT_FLAG_FILE",
+	"ACL",
+	"ATOMIC",
+	"BACKUP",
+	"CMDLINE_CONTENTS_KEY",
+	"CONTENTS",
+	"CONTENTS_ENCODING",
+	"CONTENTS_URL",
+	"CONTENT_WASM",
+	"CONTENT_WASM_MEMORY_MB",
+	"CONTENT_WASM_TIMEOUT_SECONDS",
+	"DELETE",
+	"DEST_DISK",
+	"DEST_PATH",
+	"DEST_ROOT",
+	"DIRMODE",
+	"DIRSPEC",
+	"DRY_RUN",
+	"FS_TYPE",
+	"GID",
+	"GROUP",
+	"HEGEL_AUTH_TOKEN",
+	"HEGEL_CA_CERT",
+	"HEGEL_CLIENT_CERT",
+	"HEGEL_CLIENT_KEY",
+	"HEGEL_HEADERS",
+	"HEGEL_INSECURE_SKIP_VERIFY",
+	"HEGEL_PATH",
+	"HEGEL_RETRIES",
+	"HEGEL_TIMEOUT",
+	"HEGEL_URLS",
+	"HOST_DEV",
+	"INHERIT_PARENT_OWNER",
+	"LINK_FORCE",
+	"LINK_TARGET",
+	"LOG_FORMAT",
+	"LOG_LEVEL",
+	"LUKS_KEYFILE_CONTENTS",
+	"LUKS_PASSPHRASE",
+	"MANIFEST",
+	"MANIFEST_URL",
+	"METADATA_MANIFEST",
+	"MNTNS_PID",
+	"MODE",
+	"MOUNT_OPTIONS",
+	"MOUNT_TYPE",
+	"NULL_TERMINATE",
+	"OVERLAY_LOWER",
+	"OVERLAY_UPPER",
+	"OVERLAY_WORK",
+	"PAD_TO_BYTES",
+	"PARENT_DIR_MODE",
+	"SELINUX_CONTEXT",
+	"SKIP_UNCHANGED",
+	"STRICT_ENV",
+	"STRICT_PERMS",
+	"TEMPLATE",
+	"UID",
+	"USER",
+	"USERDATA_SHA256",
+	"VERIFY_METADATA",
+	"WRITE_MODE",
+	"XATTRS",
+}
+
+// strictEnvPrefixes are the prefixes of environment variables that are checked in strict mode. They
+// cover the prefixes of the recognized variables so that typos such as CONTENT or DEST_DIR are caught,
+// without tripping over the variables set by the container runtime.
+var strictEnvPrefixes = []string{
+	"WRITEFILE_",
+	"ABORT_",
+	"ACL",
+	"ATOMIC",
+	"BACKUP",
+	"CMDLINE_",
+	"CONTENT",
+	"DELETE",
+	"DEST_",
+	"DRY_",
+	"HEGEL_",
+	"HOST_",
+	"INHERIT_",
+	"LINK_",
+	"LOG_",
+	"LUKS_",
+	"MANIFEST",
+	"METADATA_",
+	"MNTNS_",
+	"MOUNT_",
+	"NULL_",
+	"OVERLAY_",
+	"PAD_",
+	"PARENT_",
+	"SELINUX_",
+	"SKIP_",
+	"STRICT_",
+	"TEMPLATE",
+	"VERIFY_",
+	"WRITE_",
+	"XATTR",
+}
+
+// checkStrictEnv returns an error naming every variable in environ that looks like it is meant for
+// the action, but isn't one that it recognizes.
+func checkStrictEnv(environ []string) error {
+	recognized := make(map[string]bool, len(recognizedEnv))
+	for _, name := range recognizedEnv {
+		recognized[name] = true
+	}
+
+	var unknown []string
+
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if recognized[name] {
+			continue
+		}
+
+		for _, prefix := range strictEnvPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				unknown = append(unknown, name)
+				break
+			}
+		}
+	}
+
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+
+	return fmt.Errorf("unrecognized environment variables [%s], recognized variables are [%s]",
+		strings.Join(unknown, ", "), strings.Join(recognizedEnv, ", "))
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckStrictEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		// wantUnknown are the variables the error names, none when the check passes.
+		wantUnknown string
+	}{
+		{
+			name:    "recognized variables",
+			environ: []string{"DEST_DISK=/dev/sda1", "DEST_PATH=/etc/hostname", "CONTENTS=a=b", "STRICT_ENV=true"},
+		},
+		{
+			name:    "runtime variables",
+			environ: []string{"PATH=/usr/bin", "HOSTNAME=worker", "HOME=/root", "TERM=xterm", "container=docker"},
+		},
+		{
+			name:    "recognized variables without a strict prefix",
+			environ: []string{"UID=0", "USER=root", "MODE=0644", "FS_TYPE=ext4", "GROUP=root"},
+		},
+		{
+			name:        "typo of a recognized variable",
+			environ:     []string{"DEST_DISK=/dev/sda1", "DEST_DIR=/etc"},
+			wantUnknown: "DEST_DIR",
+		},
+		{
+			name:        "prefix that is a whole variable",
+			environ:     []string{"CONTENT=a", "CONTENTS_URLS=http://a", "ATOMICALLY=true"},
+			wantUnknown: "ATOMICALLY, CONTENT, CONTENTS_URLS",
+		},
+		{
+			name:        "action prefix",
+			environ:     []string{"WRITEFILE_DEST_DISK=/dev/sda1"},
+			wantUnknown: "WRITEFILE_DEST_DISK",
+		},
+		{
+			name:    "prefixes are case sensitive",
+			environ: []string{"dest_dir=/etc", "Hegel_urls=http://a"},
+		},
+		{
+			name:    "variable without a value",
+			environ: []string{"DEST_DISK", "HOSTNAME"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStrictEnv(tt.environ)
+			if tt.wantUnknown == "" {
+				if err != nil {
+					t.Fatalf("checkStrictEnv() error = %v, want none", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("checkStrictEnv() error = nil, want [%s] to be unrecognized", tt.wantUnknown)
+			}
+
+			if want := "unrecognized environment variables [" + tt.wantUnknown + "]"; !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("checkStrictEnv() error = %v, want it to start with %s", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Package fetch holds what streaming remote contents over HTTP has in common, wherever the contents
+// are fetched from.
+package fetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Error is a failure to read streamed contents, as opposed to a failure to write them.
+type Error struct {
+	err error
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// body is the body of a streamed response whose request is cancelled once it is closed, and whose
+// read errors are returned as an Error.
+type body struct {
+	rc     io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// NewBody returns rc as a streamed body, cancel is called once it is closed.
+func NewBody(rc io.ReadCloser, cancel context.CancelFunc) io.ReadCloser {
+	return &body{rc: rc, cancel: cancel}
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if err != nil && err != io.EOF {
+		err = &Error{err: err}
+	}
+
+	return n, err
+}
+
+func (b *body) Close() error {
+	err := b.rc.Close()
+	b.cancel()
+
+	return err
+}
+
+// Open makes a request with get and returns the body of its response. timeout bounds how long the
+// response takes to start but not how long its body takes to read.
+func Open(parent context.Context, timeout time.Duration, get func(context.Context) (*http.Response, error)) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(parent)
+	timer := time.AfterFunc(timeout, cancel)
+
+	resp, err := get(ctx)
+	if !timer.Stop() && err == nil {
+		// The timeout fired as the response arrived, so its body can't be read anymore
+		resp.Body.Close()
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return NewBody(resp.Body, cancel), nil
+}
+
+// RedactURL returns rawURL with any password and query values replaced, so it can be logged.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<unparseable url>"
+	}
+
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query.Set(key, "redacted")
+		}
+
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
@@ -0,0 +1,281 @@
+// Package hegel fetches the metadata and user-data of the machine from Hegel, the Tinkerbell
+// metadata service.
+package hegel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/fetch"
+)
+
+const (
+	// RequestTimeout bounds each request made to a Hegel URL.
+	RequestTimeout = 10 * time.Second
+	// MetadataPath is the Hegel endpoint serving the metadata of the requesting machine.
+	MetadataPath = "/metadata"
+	// UserDataPath is the Hegel endpoint serving the user-data of the requesting machine.
+	UserDataPath = "/2009-04-04/user-data"
+
+	// initialBackoff is the delay before the first retry, it doubles for every retry after it.
+	initialBackoff = time.Second
+	// maxBackoff caps the delay between retries.
+	maxBackoff = 30 * time.Second
+)
+
+// MetadataClient fetches documents from a metadata service by their path.
+type MetadataClient interface {
+	// Fetch returns the whole document at path.
+	Fetch(path string) ([]byte, error)
+	// Open returns the document at path to be streamed.
+	Open(path string) (io.ReadCloser, error)
+}
+
+// Client fetches documents from Hegel.
+type Client struct {
+	URLs []string
+	// Retries is the number of times all URLs are tried again after they all failed.
+	Retries int
+	// Timeout is the deadline for fetching a document including all retries, zero means no deadline.
+	Timeout time.Duration
+	// Headers are added to every request, e.g. the credentials of an auth proxy in front of Hegel.
+	Headers    http.Header
+	HTTPClient *http.Client
+}
+
+// ParseHeaders parses HTTP headers given one per line in the "Name: Value" form.
+func ParseHeaders(value string) (http.Header, error) {
+	headers := http.Header{}
+
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		name, val := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			name, val = line[:i], line[i+1:]
+		}
+
+		name = strings.TrimSpace(name)
+		if name == "" || name == line {
+			return nil, fmt.Errorf("header %q must be in the form \"Name: Value\"", line)
+		}
+
+		headers.Add(name, strings.TrimSpace(val))
+	}
+
+	return headers, nil
+}
+
+// ParseURLs splits the comma separated HEGEL_URLS value into its URLs.
+func ParseURLs(value string) []string {
+	var urls []string
+
+	for _, u := range strings.Split(value, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, strings.TrimSuffix(u, "/"))
+		}
+	}
+
+	return urls
+}
+
+// Fetch requests path from all of the Hegel URLs at once and returns the first successful response.
+func (h *Client) Fetch(path string) ([]byte, error) {
+	ctx := context.Background()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	body, err := h.retry(ctx, ctx, path, func(attemptCtx context.Context, hegelURL string) (io.ReadCloser, error) {
+		contents, err := h.fetchURL(attemptCtx, hegelURL+path)
+		if err != nil {
+			return nil, err
+		}
+
+		return ioutil.NopCloser(bytes.NewReader(contents)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+// Open requests path from all of the Hegel URLs at once and returns the body of the first successful
+// response to be streamed. The deadline only bounds how long it takes to get a response, as a large
+// body may take much longer to read.
+func (h *Client) Open(path string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	return h.retry(ctx, context.Background(), path, func(attemptCtx context.Context, hegelURL string) (io.ReadCloser, error) {
+		return fetch.Open(attemptCtx, RequestTimeout, func(reqCtx context.Context) (*http.Response, error) {
+			return h.get(reqCtx, hegelURL+path)
+		})
+	})
+}
+
+// attempt requests a document from a single Hegel URL.
+type attempt func(ctx context.Context, hegelURL string) (io.ReadCloser, error)
+
+// retry races the Hegel URLs until one of them succeeds. When every URL fails they are raced again
+// after an exponential backoff, until the retries or the deadline of ctx are exhausted. The attempts
+// are derived from parent, so that a streamed body can outlive ctx.
+func (h *Client) retry(ctx, parent context.Context, path string, try attempt) (io.ReadCloser, error) {
+	if len(h.URLs) == 0 {
+		return nil, errors.New("no Hegel URLs specified with environment variable [HEGEL_URLS]")
+	}
+
+	backoff := initialBackoff
+
+	for round := 0; ; round++ {
+		if body, ok := h.race(parent, path, round, try); ok {
+			return body, nil
+		}
+
+		if round >= h.Retries {
+			return nil, fmt.Errorf("failed to fetch %s from any of the Hegel URLs after %d attempt(s)", path, round+1)
+		}
+
+		log.Infof("Retrying Hegel in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to fetch %s from any of the Hegel URLs within %s", path, h.Timeout)
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// race makes an attempt with every Hegel URL at once and returns the body of the first one that
+// succeeds, so that an unreachable URL doesn't hold up the others. The attempts that are still running
+// are cancelled and any of them that succeed regardless are closed.
+func (h *Client) race(parent context.Context, path string, round int, try attempt) (io.ReadCloser, bool) {
+	type result struct {
+		i    int
+		body io.ReadCloser
+		err  error
+	}
+
+	results := make(chan result, len(h.URLs))
+	cancels := make([]context.CancelFunc, len(h.URLs))
+
+	for i, hegelURL := range h.URLs {
+		ctx, cancel := context.WithCancel(parent)
+		cancels[i] = cancel
+
+		go func(i int, ctx context.Context, hegelURL string) {
+			body, err := try(ctx, hegelURL)
+			results <- result{i: i, body: body, err: err}
+		}(i, ctx, hegelURL)
+	}
+
+	for received := 1; received <= len(h.URLs); received++ {
+		res := <-results
+		if res.err != nil {
+			log.Warnf("Failed to fetch [%s] from Hegel [%s] (attempt %d): %v", path, fetch.RedactURL(h.URLs[res.i]), round+1, res.err)
+			cancels[res.i]()
+
+			continue
+		}
+
+		log.Infof("Fetched [%s] from Hegel [%s]", path, fetch.RedactURL(h.URLs[res.i]))
+
+		for i, cancel := range cancels {
+			if i != res.i {
+				cancel()
+			}
+		}
+
+		go func(remaining int) {
+			for ; remaining > 0; remaining-- {
+				if loser := <-results; loser.body != nil {
+					loser.body.Close()
+				}
+			}
+		}(len(h.URLs) - received)
+
+		return fetch.NewBody(res.body, cancels[res.i]), true
+	}
+
+	return nil, false
+}
+
+func (h *Client) fetchURL(ctx context.Context, u string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, RequestTimeout)
+	defer cancel()
+
+	resp, err := h.get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// get requests u with the configured headers and returns the response when it was successful.
+func (h *Client) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range h.Headers {
+		req.Header[name] = values
+	}
+
+	client := h.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// FetchMetadata fetches the metadata document of this machine with c.
+func FetchMetadata(c MetadataClient) (map[string]interface{}, error) {
+	body, err := c.Fetch(MetadataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]interface{}{}
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse Hegel metadata: %w", err)
+	}
+
+	return metadata, nil
+}
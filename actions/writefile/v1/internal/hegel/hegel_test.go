@@ -0,0 +1,259 @@
+package hegel
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseURLs(t *testing.T) {
+	got := ParseURLs(" http://10.0.0.1:50061/, ,http://10.0.0.2:50061")
+	want := []string{"http://10.0.0.1:50061", "http://10.0.0.2:50061"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseURLs() = %v, want %v", got, want)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    http.Header
+		wantErr bool
+	}{
+		{"empty", "", http.Header{}, false},
+		{"headers", "X-Api-Key: abc\n\nx-tenant:  one ", http.Header{"X-Api-Key": {"abc"}, "X-Tenant": {"one"}}, false},
+		{"empty value", "X-Empty:", http.Header{"X-Empty": {""}}, false},
+		{"no colon", "X-Api-Key abc", nil, true},
+		{"no name", ": abc", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHeaders(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHeaders() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseHeaders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// serve returns a Hegel server that answers every request with status and body.
+func serve(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFetch(t *testing.T) {
+	ok := serve(t, http.StatusOK, "#cloud-config\n")
+	notFound := serve(t, http.StatusNotFound, "")
+
+	tests := []struct {
+		name    string
+		urls    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "single URL", urls: []string{ok.URL}, want: "#cloud-config\n"},
+		{name: "failing URL is skipped", urls: []string{notFound.URL, ok.URL}, want: "#cloud-config\n"},
+		{name: "unreachable URL is skipped", urls: []string{"http://127.0.0.1:1", ok.URL}, want: "#cloud-config\n"},
+		{name: "all URLs fail", urls: []string{notFound.URL}, wantErr: true},
+		{name: "no URLs", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{URLs: tt.urls}
+
+			got, err := c.Fetch(UserDataPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("Fetch() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchRace(t *testing.T) {
+	hanging := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hanging:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(hanging)
+
+	ok := serve(t, http.StatusOK, "metadata")
+
+	start := time.Now()
+
+	got, err := (&Client{URLs: []string{slow.URL, ok.URL}}).Fetch(MetadataPath)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+
+	if string(got) != "metadata" {
+		t.Errorf("Fetch() = %q, want %q", got, "metadata")
+	}
+
+	// The hanging URL would otherwise only give up after RequestTimeout
+	if elapsed := time.Since(start); elapsed > RequestTimeout/2 {
+		t.Errorf("Fetch() took %s, the hanging URL held up the working one", elapsed)
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		io.WriteString(w, "user-data")
+	}))
+	defer server.Close()
+
+	got, err := (&Client{URLs: []string{server.URL}, Retries: 1}).Fetch(UserDataPath)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+
+	if string(got) != "user-data" || atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("Fetch() = %q after %d requests, want %q after 2", got, requests, "user-data")
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	unavailable := serve(t, http.StatusServiceUnavailable, "")
+
+	start := time.Now()
+
+	// The retries would take far longer than the timeout with their backoff
+	_, err := (&Client{URLs: []string{unavailable.URL}, Retries: 5, Timeout: 100 * time.Millisecond}).Fetch(UserDataPath)
+	if err == nil {
+		t.Fatal("Fetch() succeeded, want a timeout")
+	}
+
+	if elapsed := time.Since(start); elapsed >= initialBackoff {
+		t.Errorf("Fetch() took %s, want it to give up once the timeout passed", elapsed)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		io.WriteString(w, "user-data")
+	}))
+	defer server.Close()
+
+	c := &Client{URLs: []string{server.URL}}
+	if _, err := c.Fetch(UserDataPath); err == nil {
+		t.Error("Fetch() without the header succeeded")
+	}
+
+	c.Headers = http.Header{"Authorization": {"Bearer token"}}
+	if _, err := c.Fetch(UserDataPath); err != nil {
+		t.Errorf("Fetch() with the header error = %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	want := strings.Repeat("user-data\n", 1000)
+	ok := serve(t, http.StatusOK, want)
+	notFound := serve(t, http.StatusNotFound, "")
+
+	body, err := (&Client{URLs: []string{notFound.URL, ok.URL}}).Open(UserDataPath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer body.Close()
+
+	got, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+
+	if string(got) != want {
+		t.Errorf("Open() body has %d bytes, want %d", len(got), len(want))
+	}
+}
+
+// fakeClient serves documents from memory.
+type fakeClient map[string]string
+
+func (f fakeClient) Fetch(path string) ([]byte, error) {
+	doc, ok := f[path]
+	if !ok {
+		return nil, errors.New("404 Not Found")
+	}
+
+	return []byte(doc), nil
+}
+
+func (f fakeClient) Open(path string) (io.ReadCloser, error) {
+	doc, err := f.Fetch(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(strings.NewReader(string(doc))), nil
+}
+
+func TestFetchMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  fakeClient
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:   "metadata",
+			client: fakeClient{MetadataPath: `{"hostname": "worker-1", "instance": {"id": "abc"}}`},
+			want:   map[string]interface{}{"hostname": "worker-1", "instance": map[string]interface{}{"id": "abc"}},
+		},
+		{name: "invalid JSON", client: fakeClient{MetadataPath: "hostname: worker-1"}, wantErr: true},
+		{name: "missing", client: fakeClient{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FetchMetadata(tt.client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FetchMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
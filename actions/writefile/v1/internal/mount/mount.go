@@ -0,0 +1,148 @@
+// Package mount mounts the filesystems that writefile writes to, behind a Mounter so that the mount
+// logic can be exercised without mounting anything.
+package mount
+
+import (
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mounter mounts and unmounts filesystems, with the semantics of mount(2) and umount(2).
+type Mounter interface {
+	Mount(source, target, fsType string, flags uintptr, data string) error
+	Unmount(target string, flags int) error
+}
+
+// Kernel is the Mounter that calls into the kernel.
+type Kernel struct{}
+
+// Mount calls mount(2).
+func (Kernel) Mount(source, target, fsType string, flags uintptr, data string) error {
+	return syscall.Mount(source, target, fsType, flags, data)
+}
+
+// Unmount calls umount(2).
+func (Kernel) Unmount(target string, flags int) error {
+	return syscall.Unmount(target, flags)
+}
+
+// msLazytime is MS_LAZYTIME, which the syscall package doesn't define.
+const msLazytime = 1 << 25
+
+// flags are the mount(8) options that are passed to mount(2) as flags rather than as data, and
+// whether they set or clear their flag.
+var flags = map[string]struct {
+	flag  uintptr
+	clear bool
+}{
+	"ro":          {flag: syscall.MS_RDONLY},
+	"rw":          {flag: syscall.MS_RDONLY, clear: true},
+	"nosuid":      {flag: syscall.MS_NOSUID},
+	"suid":        {flag: syscall.MS_NOSUID, clear: true},
+	"nodev":       {flag: syscall.MS_NODEV},
+	"dev":         {flag: syscall.MS_NODEV, clear: true},
+	"noexec":      {flag: syscall.MS_NOEXEC},
+	"exec":        {flag: syscall.MS_NOEXEC, clear: true},
+	"sync":        {flag: syscall.MS_SYNCHRONOUS},
+	"async":       {flag: syscall.MS_SYNCHRONOUS, clear: true},
+	"dirsync":     {flag: syscall.MS_DIRSYNC},
+	"noatime":     {flag: syscall.MS_NOATIME},
+	"atime":       {flag: syscall.MS_NOATIME, clear: true},
+	"nodiratime":  {flag: syscall.MS_NODIRATIME},
+	"diratime":    {flag: syscall.MS_NODIRATIME, clear: true},
+	"relatime":    {flag: syscall.MS_RELATIME},
+	"norelatime":  {flag: syscall.MS_RELATIME, clear: true},
+	"strictatime": {flag: syscall.MS_STRICTATIME},
+	"lazytime":    {flag: msLazytime},
+	"nolazytime":  {flag: msLazytime, clear: true},
+}
+
+// ntfsDriver is the kernel driver that NTFS is mounted with, as the older ntfs driver can't write.
+const ntfsDriver = "ntfs3"
+
+// FSType returns the filesystem type that a filesystem of type fsType is mounted as.
+func FSType(fsType string) string {
+	if fsType == "ntfs" {
+		return ntfsDriver
+	}
+
+	return fsType
+}
+
+// Options are the flags and filesystem specific data of a mount.
+type Options struct {
+	Flags uintptr
+	Data  string
+}
+
+// ParseOptions splits a comma separated list of mount(8) options into the flags and the data, such
+// as subvol=@ or compress=zstd, that mount(2) takes. Options that aren't flags are passed on as data
+// for the filesystem to validate.
+func ParseOptions(value string) Options {
+	var opts Options
+	var data []string
+
+	for _, option := range strings.Split(value, ",") {
+		if option = strings.TrimSpace(option); option == "" || option == "defaults" {
+			continue
+		}
+
+		flag, ok := flags[option]
+		switch {
+		case !ok:
+			data = append(data, option)
+		case flag.clear:
+			opts.Flags &^= flag.flag
+		default:
+			opts.Flags |= flag.flag
+		}
+	}
+
+	opts.Data = strings.Join(data, ",")
+
+	return opts
+}
+
+// ReadOnly reports whether the options mount the filesystem read-only.
+func (o Options) ReadOnly() bool {
+	return o.Flags&syscall.MS_RDONLY != 0
+}
+
+// ReadWrite mounts source on target with opts. A filesystem that is mounted read-only is then
+// remounted read-write, as the action has to write to it, for filesystems that have to be mounted
+// read-only first.
+func ReadWrite(m Mounter, source, target, fsType string, opts Options) error {
+	if err := m.Mount(source, target, fsType, opts.Flags, opts.Data); err != nil {
+		return err
+	}
+
+	if !opts.ReadOnly() {
+		return nil
+	}
+
+	if err := m.Mount(source, target, fsType, syscall.MS_REMOUNT|(opts.Flags&^syscall.MS_RDONLY), opts.Data); err != nil {
+		// The read-only mount is of no use to the action, so it isn't left behind
+		m.Unmount(target, 0)
+
+		return err
+	}
+
+	return nil
+}
+
+// UnmountAll unmounts mounts in reverse order, mountpoints that are no longer mounted are skipped.
+func UnmountAll(m Mounter, mounts []string) {
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if err := m.Unmount(mounts[i], 0); err != nil {
+			if err != syscall.EINVAL {
+				log.Errorf("Unmounting [%s] error [%v]", mounts[i], err)
+			}
+
+			continue
+		}
+
+		log.Infof("Unmounted [%s]", mounts[i])
+	}
+}
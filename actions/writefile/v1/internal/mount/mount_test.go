@@ -0,0 +1,139 @@
+package mount
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// call is a mount or unmount made through fakeMounter.
+type call struct {
+	op     string
+	target string
+	flags  uintptr
+	data   string
+}
+
+// fakeMounter records its calls and fails the ones listed in errs, by their index.
+type fakeMounter struct {
+	calls []call
+	errs  map[int]error
+}
+
+func (f *fakeMounter) Mount(source, target, fsType string, flags uintptr, data string) error {
+	f.calls = append(f.calls, call{op: "mount", target: target, flags: flags, data: data})
+	return f.errs[len(f.calls)-1]
+}
+
+func (f *fakeMounter) Unmount(target string, flags int) error {
+	f.calls = append(f.calls, call{op: "unmount", target: target})
+	return f.errs[len(f.calls)-1]
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  Options
+	}{
+		{"empty", "", Options{}},
+		{"defaults", "defaults", Options{}},
+		{"flags", "noatime,nodev", Options{Flags: syscall.MS_NOATIME | syscall.MS_NODEV}},
+		{"data", "subvol=@, compress=zstd", Options{Data: "subvol=@,compress=zstd"}},
+		{"flags and data", "ro,uid=0,noexec", Options{Flags: syscall.MS_RDONLY | syscall.MS_NOEXEC, Data: "uid=0"}},
+		{"later option clears flag", "noatime,atime", Options{}},
+		{"lazytime", "lazytime", Options{Flags: msLazytime}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseOptions(tt.value); got != tt.want {
+				t.Errorf("ParseOptions(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFSType(t *testing.T) {
+	for fsType, want := range map[string]string{"ntfs": "ntfs3", "ext4": "ext4", "vfat": "vfat"} {
+		if got := FSType(fsType); got != want {
+			t.Errorf("FSType(%q) = %q, want %q", fsType, got, want)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	errMount := errors.New("mount failed")
+
+	tests := []struct {
+		name    string
+		opts    Options
+		errs    map[int]error
+		want    []call
+		wantErr bool
+	}{
+		{
+			name: "read-write",
+			opts: Options{Flags: syscall.MS_NOATIME, Data: "uid=0"},
+			want: []call{{op: "mount", target: "/mnt", flags: syscall.MS_NOATIME, data: "uid=0"}},
+		},
+		{
+			name: "read-only is remounted",
+			opts: Options{Flags: syscall.MS_RDONLY | syscall.MS_NOATIME},
+			want: []call{
+				{op: "mount", target: "/mnt", flags: syscall.MS_RDONLY | syscall.MS_NOATIME},
+				{op: "mount", target: "/mnt", flags: syscall.MS_REMOUNT | syscall.MS_NOATIME},
+			},
+		},
+		{
+			name:    "failed mount",
+			opts:    Options{Flags: syscall.MS_RDONLY},
+			errs:    map[int]error{0: errMount},
+			want:    []call{{op: "mount", target: "/mnt", flags: syscall.MS_RDONLY}},
+			wantErr: true,
+		},
+		{
+			name: "failed remount is unmounted",
+			opts: Options{Flags: syscall.MS_RDONLY},
+			errs: map[int]error{1: errMount},
+			want: []call{
+				{op: "mount", target: "/mnt", flags: syscall.MS_RDONLY},
+				{op: "mount", target: "/mnt", flags: syscall.MS_REMOUNT},
+				{op: "unmount", target: "/mnt"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &fakeMounter{errs: tt.errs}
+
+			err := ReadWrite(m, "/dev/sda1", "/mnt", "ext4", tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadWrite() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(m.calls, tt.want) {
+				t.Errorf("ReadWrite() calls = %+v, want %+v", m.calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmountAll(t *testing.T) {
+	m := &fakeMounter{errs: map[int]error{1: syscall.EINVAL}}
+
+	UnmountAll(m, []string{"/mountAction", "/overlayAction", "/other"})
+
+	want := []call{
+		{op: "unmount", target: "/other"},
+		{op: "unmount", target: "/overlayAction"},
+		{op: "unmount", target: "/mountAction"},
+	}
+
+	if !reflect.DeepEqual(m.calls, want) {
+		t.Errorf("UnmountAll() calls = %+v, want %+v", m.calls, want)
+	}
+}
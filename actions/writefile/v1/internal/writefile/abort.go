@@ -0,0 +1,26 @@
+package writefile
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// checkAbort returns an error with the aborted exit code if flagFile exists, the action then stops
+// without writing anything else. An empty flagFile disables the check.
+func checkAbort(flagFile string) error {
+	if flagFile == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(flagFile); err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Could not check abort flag file [%s]: %v", flagFile, err)
+		}
+
+		return nil
+	}
+
+	return &exitError{code: ExitCodeAborted, err: fmt.Errorf("abort flag file [%s] found, aborting without writing", flagFile)}
+}
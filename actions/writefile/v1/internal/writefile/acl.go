@@ -0,0 +1,322 @@
+package writefile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The extended attributes the kernel stores POSIX ACLs in.
+const (
+	aclAccessXattr  = "system.posix_acl_access"
+	aclDefaultXattr = "system.posix_acl_default"
+)
+
+// The layout of the POSIX ACL extended attributes, from include/uapi/linux/posix_acl_xattr.h.
+const (
+	aclXattrVersion = 2
+	aclUndefinedID  = 0xffffffff
+
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+// aclEntry is a single entry of a POSIX ACL.
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+	// name is the user or group of a named entry that is looked up on the destination filesystem.
+	name string
+}
+
+// posixACL is a POSIX ACL, as set by setfacl.
+type posixACL []aclEntry
+
+// parseACL parses an ACL in the short or long text form of setfacl, e.g.
+// "u::rwx,u:alice:r-x,g::r-x,o::r--", with entries separated by commas or new lines. Entries prefixed
+// with "d:" or "default:" make up the default ACL, which only a directory can have.
+func parseACL(text string) (posixACL, posixACL, error) {
+	var access, defaults posixACL
+
+	for _, raw := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		fields := strings.Split(raw, ":")
+		isDefault := fields[0] == "d" || fields[0] == "default"
+		if isDefault {
+			fields = fields[1:]
+		}
+
+		entry, err := parseACLEntry(fields)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid ACL entry %s: %w", raw, err)
+		}
+
+		if isDefault {
+			defaults = append(defaults, entry)
+		} else {
+			access = append(access, entry)
+		}
+	}
+
+	for _, acl := range []posixACL{access, defaults} {
+		if err := acl.validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, defaults, nil
+}
+
+// parseACLEntry parses the fields of an entry without its default prefix, the qualifier of a mask
+// or other entry may be left out altogether.
+func parseACLEntry(fields []string) (aclEntry, error) {
+	if len(fields) == 2 {
+		fields = []string{fields[0], "", fields[1]}
+	}
+
+	if len(fields) != 3 {
+		return aclEntry{}, fmt.Errorf("must be in the form type:qualifier:perms")
+	}
+
+	perm, err := parseACLPerm(fields[2])
+	if err != nil {
+		return aclEntry{}, err
+	}
+
+	entry := aclEntry{perm: perm, id: aclUndefinedID}
+	qualifier := fields[1]
+
+	switch fields[0] {
+	case "u", "user":
+		entry.tag = aclUserObj
+		if qualifier != "" {
+			entry.tag = aclUser
+		}
+	case "g", "group":
+		entry.tag = aclGroupObj
+		if qualifier != "" {
+			entry.tag = aclGroup
+		}
+	case "m", "mask":
+		entry.tag = aclMask
+	case "o", "other":
+		entry.tag = aclOther
+	default:
+		return aclEntry{}, fmt.Errorf("unknown type %s, must be one of [user, group, mask, other]", fields[0])
+	}
+
+	if qualifier != "" {
+		if entry.tag != aclUser && entry.tag != aclGroup {
+			return aclEntry{}, fmt.Errorf("%s entries don't take a qualifier", fields[0])
+		}
+
+		if id, err := strconv.ParseUint(qualifier, 10, 32); err == nil {
+			entry.id = uint32(id)
+		} else {
+			entry.name = qualifier
+		}
+	}
+
+	return entry, nil
+}
+
+// parseACLPerm parses permissions given either as letters, e.g. "r-x" or "rx", or as an octal digit.
+func parseACLPerm(value string) (uint16, error) {
+	if len(value) == 1 && value[0] >= '0' && value[0] <= '7' {
+		return uint16(value[0] - '0'), nil
+	}
+
+	var perm uint16
+
+	for _, c := range value {
+		switch c {
+		case 'r':
+			perm |= 4
+		case 'w':
+			perm |= 2
+		case 'x':
+			perm |= 1
+		case '-':
+		default:
+			return 0, fmt.Errorf("invalid permissions %s", value)
+		}
+	}
+
+	return perm, nil
+}
+
+// validate checks that an ACL which isn't empty has exactly one owner, owning group and other entry
+// and at most one mask entry, as the kernel rejects it otherwise.
+func (a posixACL) validate() error {
+	if len(a) == 0 {
+		return nil
+	}
+
+	counts := map[uint16]int{}
+	for _, entry := range a {
+		counts[entry.tag]++
+	}
+
+	for tag, name := range map[uint16]string{aclUserObj: "user::", aclGroupObj: "group::", aclOther: "other::"} {
+		if counts[tag] != 1 {
+			return fmt.Errorf("ACL must have exactly one %s entry", name)
+		}
+	}
+
+	if counts[aclMask] > 1 {
+		return fmt.Errorf("ACL must have at most one mask:: entry")
+	}
+
+	return nil
+}
+
+// resolve looks up the named user and group entries of the ACL in the passwd and group databases under
+// rootPath, and checks that no user or group has more than one entry once they are resolved.
+func (a posixACL) resolve(rootPath string) error {
+	seen := map[aclEntry]bool{}
+
+	for i := range a {
+		entry := &a[i]
+		if entry.tag != aclUser && entry.tag != aclGroup {
+			continue
+		}
+
+		if entry.name != "" {
+			if err := entry.lookup(rootPath); err != nil {
+				return err
+			}
+		}
+
+		key := aclEntry{tag: entry.tag, id: entry.id}
+		if seen[key] {
+			return fmt.Errorf("ACL has more than one entry for id %d", entry.id)
+		}
+
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// lookup resolves the name of a named entry to its id.
+func (entry *aclEntry) lookup(rootPath string) error {
+	database := passwdFile
+	if entry.tag == aclGroup {
+		database = groupFile
+	}
+
+	id, err := lookupID(filepath.Join(rootPath, database), entry.name)
+	if err != nil {
+		return fmt.Errorf("could not look up ACL entry %s: %w", entry.name, err)
+	}
+
+	entry.id, entry.name = uint32(id), ""
+
+	return nil
+}
+
+// withMask returns the entries of the ACL with a mask added when named entries don't come with one,
+// covering all of the group class permissions as setfacl does.
+func (a posixACL) withMask() posixACL {
+	entries := append(posixACL(nil), a...)
+
+	hasMask, hasNamed := false, false
+	var groupClass uint16
+
+	for _, entry := range entries {
+		switch entry.tag {
+		case aclMask:
+			hasMask = true
+		case aclUser, aclGroup:
+			hasNamed = true
+			groupClass |= entry.perm
+		case aclGroupObj:
+			groupClass |= entry.perm
+		}
+	}
+
+	if hasNamed && !hasMask {
+		entries = append(entries, aclEntry{tag: aclMask, perm: groupClass, id: aclUndefinedID})
+	}
+
+	return entries
+}
+
+// permissions returns the permission bits that the ACL gives a file once it is set, the group bits
+// are those of the mask when there is one.
+func (a posixACL) permissions() os.FileMode {
+	var owner, group, mask, other uint16
+	hasMask := false
+
+	for _, entry := range a.withMask() {
+		switch entry.tag {
+		case aclUserObj:
+			owner = entry.perm
+		case aclGroupObj:
+			group = entry.perm
+		case aclMask:
+			mask, hasMask = entry.perm, true
+		case aclOther:
+			other = entry.perm
+		}
+	}
+
+	if hasMask {
+		group = mask
+	}
+
+	return os.FileMode(owner<<6 | group<<3 | other)
+}
+
+// encode returns the ACL in the form of its extended attribute, with a mask added as in withMask.
+func (a posixACL) encode() []byte {
+	entries := a.withMask()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].tag != entries[j].tag {
+			return entries[i].tag < entries[j].tag
+		}
+
+		return entries[i].id < entries[j].id
+	})
+
+	buf := make([]byte, 4, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(buf, aclXattrVersion)
+
+	for _, entry := range entries {
+		var raw [8]byte
+		binary.LittleEndian.PutUint16(raw[0:], entry.tag)
+		binary.LittleEndian.PutUint16(raw[2:], entry.perm)
+		binary.LittleEndian.PutUint32(raw[4:], entry.id)
+		buf = append(buf, raw[:]...)
+	}
+
+	return buf
+}
+
+// setACL sets acl on fqPath as the given ACL extended attribute, an empty ACL is left unset.
+func setACL(fqPath, xattr string, acl posixACL) error {
+	if len(acl) == 0 {
+		return nil
+	}
+
+	if err := syscall.Setxattr(fqPath, xattr, acl.encode(), 0); err != nil {
+		return fmt.Errorf("could not set ACL: %w", err)
+	}
+
+	return nil
+}
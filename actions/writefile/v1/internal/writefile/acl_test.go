@@ -0,0 +1,214 @@
+package writefile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseACL(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		wantAccess   posixACL
+		wantDefaults posixACL
+		wantErr      bool
+	}{
+		{name: "empty", text: ""},
+		{
+			name: "short form",
+			text: "u::rwx,g::r-x,o::r--",
+			wantAccess: posixACL{
+				{tag: aclUserObj, perm: 7, id: aclUndefinedID},
+				{tag: aclGroupObj, perm: 5, id: aclUndefinedID},
+				{tag: aclOther, perm: 4, id: aclUndefinedID},
+			},
+		},
+		{
+			name: "long form with named entries and new lines",
+			text: "user::rw-\nuser:alice:rx\ngroup::r\ngroup:100:6\nmask::rwx\nother::-",
+			wantAccess: posixACL{
+				{tag: aclUserObj, perm: 6, id: aclUndefinedID},
+				{tag: aclUser, perm: 5, id: aclUndefinedID, name: "alice"},
+				{tag: aclGroupObj, perm: 4, id: aclUndefinedID},
+				{tag: aclGroup, perm: 6, id: 100},
+				{tag: aclMask, perm: 7, id: aclUndefinedID},
+				{tag: aclOther, perm: 0, id: aclUndefinedID},
+			},
+		},
+		{
+			name: "default entries",
+			text: "u::rwx,g::rx,o::-, d:u::rwx,default:g::rx,d:o::-",
+			wantAccess: posixACL{
+				{tag: aclUserObj, perm: 7, id: aclUndefinedID},
+				{tag: aclGroupObj, perm: 5, id: aclUndefinedID},
+				{tag: aclOther, perm: 0, id: aclUndefinedID},
+			},
+			wantDefaults: posixACL{
+				{tag: aclUserObj, perm: 7, id: aclUndefinedID},
+				{tag: aclGroupObj, perm: 5, id: aclUndefinedID},
+				{tag: aclOther, perm: 0, id: aclUndefinedID},
+			},
+		},
+		{name: "unknown type", text: "u::rwx,g::rx,o::-,x::r", wantErr: true},
+		{name: "invalid permissions", text: "u::rwz,g::rx,o::-", wantErr: true},
+		{name: "octal out of range", text: "u::8,g::rx,o::-", wantErr: true},
+		{name: "qualified mask", text: "u::rwx,g::rx,o::-,m:alice:rx", wantErr: true},
+		{name: "too many fields", text: "u:alice:r:x", wantErr: true},
+		{name: "missing owner", text: "g::rx,o::-", wantErr: true},
+		{name: "two owners", text: "u::rwx,u::rx,g::rx,o::-", wantErr: true},
+		{name: "two masks", text: "u::rwx,g::rx,m::r,m::rx,o::-", wantErr: true},
+		{name: "incomplete default", text: "u::rwx,g::rx,o::-,d:u::rwx", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			access, defaults, err := parseACL(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseACL(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(access, tt.wantAccess) {
+				t.Errorf("parseACL(%q) access = %+v, want %+v", tt.text, access, tt.wantAccess)
+			}
+
+			if !reflect.DeepEqual(defaults, tt.wantDefaults) {
+				t.Errorf("parseACL(%q) defaults = %+v, want %+v", tt.text, defaults, tt.wantDefaults)
+			}
+		})
+	}
+}
+
+func TestACLPermissions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want os.FileMode
+	}{
+		{"owner, group and other", "u::rw,g::r,o::-", 0o640},
+		{"computed mask covers the group class", "u::rw,u:1000:rwx,g::r,o::r", 0o674},
+		{"explicit mask", "u::rw,u:1000:rwx,g::r,m::r,o::-", 0o640},
+		{"mask without named entries", "u::rwx,g::rwx,m::rx,o::-", 0o750},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acl, _, err := parseACL(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := acl.permissions(); got != tt.want {
+				t.Errorf("permissions() of %q = %04o, want %04o", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestACLEncode(t *testing.T) {
+	// entry returns an entry of the extended attribute, in little endian
+	entry := func(tag, perm uint16, id uint32) []byte {
+		return []byte{byte(tag), byte(tag >> 8), byte(perm), byte(perm >> 8), byte(id), byte(id >> 8), byte(id >> 16), byte(id >> 24)}
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want [][]byte
+	}{
+		{
+			name: "minimal",
+			text: "o::r,g::rx,u::rwx",
+			want: [][]byte{
+				{2, 0, 0, 0},
+				entry(aclUserObj, 7, aclUndefinedID),
+				entry(aclGroupObj, 5, aclUndefinedID),
+				entry(aclOther, 4, aclUndefinedID),
+			},
+		},
+		{
+			name: "named entries sorted by tag and id with a computed mask",
+			text: "u::rw,u:1001:r,u:1000:w,g::r,g:50:x,o::-",
+			want: [][]byte{
+				{2, 0, 0, 0},
+				entry(aclUserObj, 6, aclUndefinedID),
+				entry(aclUser, 2, 1000),
+				entry(aclUser, 4, 1001),
+				entry(aclGroupObj, 4, aclUndefinedID),
+				entry(aclGroup, 1, 50),
+				entry(aclMask, 7, aclUndefinedID),
+				entry(aclOther, 0, aclUndefinedID),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acl, _, err := parseACL(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := acl.encode(), bytes.Join(tt.want, nil); !bytes.Equal(got, want) {
+				t.Errorf("encode() of %q = % x, want % x", tt.text, got, want)
+			}
+		})
+	}
+}
+
+func TestACLResolve(t *testing.T) {
+	rootPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootPath, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(rootPath, passwdFile), []byte("root:x:0:0::/root:/bin/sh\nalice:x:1000:1000::/home/alice:/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(rootPath, groupFile), []byte("root:x:0:\nwheel:x:10:alice\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    posixACL
+		wantErr bool
+	}{
+		{
+			name: "named user and group",
+			text: "u::rw,u:alice:r,g::r,g:wheel:rw,o::-",
+			want: posixACL{
+				{tag: aclUserObj, perm: 6, id: aclUndefinedID},
+				{tag: aclUser, perm: 4, id: 1000},
+				{tag: aclGroupObj, perm: 4, id: aclUndefinedID},
+				{tag: aclGroup, perm: 6, id: 10},
+				{tag: aclOther, perm: 0, id: aclUndefinedID},
+			},
+		},
+		{name: "unknown user", text: "u::rw,u:bob:r,g::r,o::-", wantErr: true},
+		{name: "same user by name and id", text: "u::rw,u:alice:r,u:1000:rw,g::r,o::-", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acl, _, err := parseACL(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = acl.resolve(rootPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(acl, tt.want) {
+				t.Errorf("resolve() = %+v, want %+v", acl, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package writefile
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeAtomic writes file to a temporary file next to fqPath and moves it into place once its
+// contents and metadata have been synced, so that a power loss leaves either the previous file or
+// the complete new one. The parent directory is synced afterwards to persist the rename.
+func writeAtomic(fqPath string, file *fileWrite) error {
+	dir, name := filepath.Split(fqPath)
+
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmp.Name()
+	// The temporary file is left behind only if the action is killed before it is moved into place
+	defer os.Remove(tmpPath)
+
+	if err := syncContents(tmp, fqPath, file); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if file.writeMode == writeModeCreateOnly {
+		// A hard link fails if the destination exists, where a rename would silently replace it
+		if err := os.Link(tmpPath, fqPath); err != nil {
+			return err
+		}
+	} else if err := os.Rename(tmpPath, fqPath); err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+
+	return d.Close()
+}
+
+// syncContents writes the contents of file to f, after the existing contents of fqPath when appending,
+// applies the metadata of file and flushes it all to disk.
+func syncContents(f *os.File, fqPath string, file *fileWrite) error {
+	if file.writeMode == writeModeAppend {
+		if err := copyExisting(f, fqPath); err != nil {
+			return err
+		}
+	}
+
+	if _, err := file.writeContents(f); err != nil {
+		return err
+	}
+
+	if err := f.Chown(file.uid, file.gid); err != nil {
+		return err
+	}
+
+	// The temporary file is created with 0600 so the mode is set explicitly
+	if err := f.Chmod(file.mode); err != nil {
+		return err
+	}
+
+	if err := setAttributes(f.Name(), file); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// copyExisting copies the contents of fqPath to f, a file that doesn't exist yet has no contents.
+func copyExisting(f *os.File, fqPath string) error {
+	existing, err := os.Open(fqPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+	defer existing.Close()
+
+	_, err = io.Copy(f, existing)
+
+	return err
+}
@@ -0,0 +1,86 @@
+package writefile
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// backupTimeFormat is the UTC timestamp appended to the name of a backup.
+const backupTimeFormat = "20060102T150405Z"
+
+// backupFile copies the existing regular file at fqPath aside to <name>.bak-<timestamp>, keeping its
+// mode, ownership and modification time, and returns the path of the copy. Nothing is backed up when
+// there is no file at fqPath yet. The file is copied rather than renamed, so that it stays in place
+// for appending and until the new contents replace it.
+func backupFile(fqPath string, now time.Time) (string, error) {
+	info, err := os.Lstat(fqPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	if !info.Mode().IsRegular() {
+		return "", nil
+	}
+
+	src, err := os.Open(fqPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	backupPath, dst, err := createBackup(fqPath+".bak-"+now.UTC().Format(backupTimeFormat), info.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", err
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := dst.Chown(int(stat.Uid), int(stat.Gid)); err != nil {
+			dst.Close()
+			return "", err
+		}
+	}
+
+	// The mode is set again as the umask applies when the backup is created
+	if err := dst.Chmod(info.Mode().Perm()); err != nil {
+		dst.Close()
+		return "", err
+	}
+
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return "", err
+	}
+
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+
+	return backupPath, os.Chtimes(backupPath, info.ModTime(), info.ModTime())
+}
+
+// createBackup creates the backup file at backupPath, a backup taken within the same second as an
+// earlier one gets a numbered suffix instead of replacing it.
+func createBackup(backupPath string, mode os.FileMode) (string, *os.File, error) {
+	path := backupPath
+
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if !os.IsExist(err) {
+			return path, f, err
+		}
+
+		path = fmt.Sprintf("%s.%d", backupPath, i)
+	}
+}
@@ -0,0 +1,379 @@
+package writefile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sysBlock lists every block device known to the kernel, including partitions.
+const sysBlock = "/sys/class/block"
+
+// fsSignature is a filesystem found on a block device by its superblock.
+type fsSignature struct {
+	Type  string
+	UUID  string
+	Label string
+}
+
+// superblockProbe looks for a single filesystem type in the start of a block device.
+type superblockProbe func(r io.ReaderAt) *fsSignature
+
+// superblockProbes are all the filesystems that can be detected.
+var superblockProbes = []superblockProbe{
+	probeExt,
+	probeXFS,
+	probeBtrfs,
+	probeVFAT,
+	probeNTFS,
+}
+
+// probeSignatures returns every filesystem signature found on the device at path.
+func probeSignatures(path string) ([]fsSignature, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var signatures []fsSignature
+
+	for _, probe := range superblockProbes {
+		if sig := probe(f); sig != nil {
+			signatures = append(signatures, *sig)
+		}
+	}
+
+	return signatures, nil
+}
+
+// detectFilesystem returns the type of the filesystem on the device at path, it fails when no
+// filesystem or more than one filesystem signature is found.
+func detectFilesystem(path string) (string, error) {
+	signatures, err := probeSignatures(path)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(signatures) {
+	case 0:
+		return "", errors.New("no known filesystem signature found")
+	case 1:
+		return signatures[0].Type, nil
+	default:
+		types := make([]string, 0, len(signatures))
+		for _, sig := range signatures {
+			types = append(types, sig.Type)
+		}
+
+		return "", fmt.Errorf("found multiple filesystem signatures [%s]", strings.Join(types, ", "))
+	}
+}
+
+// resolveDevice resolves a DEST_DISK given as LABEL=, UUID= or PARTLABEL= to the device node that
+// carries it by probing every block device, the way mount(8) does. Any other value is a device path
+// and is returned as is.
+func resolveDevice(spec string) (string, error) {
+	tag, value := "", ""
+	for _, prefix := range []string{"LABEL", "UUID", "PARTLABEL"} {
+		if strings.HasPrefix(spec, prefix+"=") {
+			tag, value = prefix, strings.Trim(strings.TrimPrefix(spec, prefix+"="), `"`)
+			break
+		}
+	}
+
+	if tag == "" {
+		return spec, nil
+	}
+
+	if value == "" {
+		return "", fmt.Errorf("%s must not be empty", tag)
+	}
+
+	entries, err := ioutil.ReadDir(sysBlock)
+	if err != nil {
+		return "", fmt.Errorf("failed to list block devices: %w", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+
+	var matches []string
+
+	for _, name := range names {
+		device := filepath.Join("/dev", name)
+
+		matched, err := deviceHasTag(name, device, tag, value)
+		if err != nil {
+			log.Debugf("Skipping block device [%s]: %v", device, err)
+			continue
+		}
+
+		if matched {
+			matches = append(matches, device)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no block device found with %s", spec)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%s is ambiguous, it matches [%s]", spec, strings.Join(matches, ", "))
+	}
+}
+
+func deviceHasTag(name, device, tag, value string) (bool, error) {
+	if tag == "PARTLABEL" {
+		// The kernel exposes the GPT partition name of every partition that has one
+		partName, err := ueventValue(name, "PARTNAME")
+		if err != nil {
+			return false, err
+		}
+
+		return partName == value, nil
+	}
+
+	signatures, err := probeSignatures(device)
+	if err != nil {
+		return false, err
+	}
+
+	for _, sig := range signatures {
+		if (tag == "LABEL" && sig.Label == value) || (tag == "UUID" && strings.EqualFold(sig.UUID, value)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ueventValue returns the value of key in the uevent file of the block device name.
+func ueventValue(name, key string) (string, error) {
+	f, err := os.Open(filepath.Join(sysBlock, name, "uevent"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v := strings.TrimPrefix(scanner.Text(), key+"="); v != scanner.Text() {
+			return v, nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// readAt reads length bytes at offset, returning nil if the device is too small.
+func readAt(r io.ReaderAt, offset int64, length int) []byte {
+	buf := make([]byte, length)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return nil
+	}
+
+	return buf
+}
+
+// formatUUID formats 16 raw bytes as a canonical lower case UUID.
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// cString returns b up to its first null byte, with trailing spaces removed.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+
+	return strings.TrimRight(string(b), " ")
+}
+
+// probeExt detects ext2, ext3 and ext4, the superblock starts 1024 bytes into the device.
+func probeExt(r io.ReaderAt) *fsSignature {
+	sb := readAt(r, 1024, 136)
+	if sb == nil || binary.LittleEndian.Uint16(sb[56:58]) != 0xEF53 {
+		return nil
+	}
+
+	const (
+		compatHasJournal = 0x4
+		// incompatExt3Mask is every incompatible feature an ext3 filesystem may have, anything
+		// else (extents, 64bit, flex_bg...) requires ext4.
+		incompatExt3Mask = 0x2 | 0x4 | 0x10
+	)
+
+	compat := binary.LittleEndian.Uint32(sb[92:96])
+	incompat := binary.LittleEndian.Uint32(sb[96:100])
+
+	fsType := "ext2"
+	switch {
+	case incompat&^incompatExt3Mask != 0:
+		fsType = "ext4"
+	case compat&compatHasJournal != 0:
+		fsType = "ext3"
+	}
+
+	return &fsSignature{Type: fsType, UUID: formatUUID(sb[104:120]), Label: cString(sb[120:136])}
+}
+
+// probeXFS detects xfs, the superblock is at the start of the device.
+func probeXFS(r io.ReaderAt) *fsSignature {
+	sb := readAt(r, 0, 120)
+	if sb == nil || string(sb[0:4]) != "XFSB" {
+		return nil
+	}
+
+	return &fsSignature{Type: "xfs", UUID: formatUUID(sb[32:48]), Label: cString(sb[108:120])}
+}
+
+// probeBtrfs detects btrfs, the primary superblock is 64KiB into the device.
+func probeBtrfs(r io.ReaderAt) *fsSignature {
+	sb := readAt(r, 0x10000, 0x12b+256)
+	if sb == nil || string(sb[0x40:0x48]) != "_BHRfS_M" {
+		return nil
+	}
+
+	return &fsSignature{Type: "btrfs", UUID: formatUUID(sb[0x20:0x30]), Label: cString(sb[0x12b:])}
+}
+
+// probeVFAT detects FAT12/16/32 filesystems from their boot sector.
+func probeVFAT(r io.ReaderAt) *fsSignature {
+	bs := readAt(r, 0, 512)
+	if bs == nil || bs[510] != 0x55 || bs[511] != 0xAA {
+		return nil
+	}
+
+	var id, label []byte
+
+	switch {
+	case string(bs[82:87]) == "FAT32":
+		id, label = bs[67:71], bs[71:82]
+	case string(bs[54:57]) == "FAT":
+		id, label = bs[39:43], bs[43:54]
+	default:
+		return nil
+	}
+
+	serial := binary.LittleEndian.Uint32(id)
+	volumeLabel := cString(label)
+
+	if volumeLabel == "NO NAME" {
+		volumeLabel = ""
+	}
+
+	return &fsSignature{Type: "vfat", UUID: fmt.Sprintf("%04X-%04X", serial>>16, serial&0xffff), Label: volumeLabel}
+}
+
+// The layout of NTFS needed to find the volume label, which is kept in the $VOLUME_NAME attribute of
+// the $Volume file, the fourth record of the MFT.
+const (
+	ntfsVolumeRecord    = 3
+	ntfsVolumeNameAttr  = 0x60
+	ntfsEndAttr         = 0xffffffff
+	ntfsFixupSectorSize = 512
+)
+
+func probeNTFS(r io.ReaderAt) *fsSignature {
+	bs := readAt(r, 0, 512)
+	if bs == nil || string(bs[3:11]) != "NTFS    " {
+		return nil
+	}
+
+	sig := &fsSignature{Type: "ntfs", UUID: fmt.Sprintf("%016X", binary.LittleEndian.Uint64(bs[72:80]))}
+
+	sectorSize := int64(binary.LittleEndian.Uint16(bs[11:13]))
+	clusterSize := sectorSize * int64(bs[13])
+
+	// A negative number of clusters per record is the log2 of the record size in bytes
+	recordSize := int64(int8(bs[64])) * clusterSize
+	if int8(bs[64]) < 0 {
+		recordSize = 1 << uint(-int8(bs[64]))
+	}
+
+	mftOffset := int64(binary.LittleEndian.Uint64(bs[48:56])) * clusterSize
+	if clusterSize == 0 || recordSize < 64 || recordSize > 64*1024 {
+		return sig
+	}
+
+	record := readAt(r, mftOffset+ntfsVolumeRecord*recordSize, int(recordSize))
+	if record == nil || string(record[0:4]) != "FILE" || !applyNTFSFixups(record) {
+		return sig
+	}
+
+	for off := int(binary.LittleEndian.Uint16(record[20:22])); off+24 <= len(record); {
+		attrType := binary.LittleEndian.Uint32(record[off:])
+		attrLen := int(binary.LittleEndian.Uint32(record[off+4:]))
+
+		if attrType == ntfsEndAttr || attrLen == 0 {
+			break
+		}
+
+		// The volume name is always resident, so its value follows the attribute header
+		if attrType == ntfsVolumeNameAttr && record[off+8] == 0 {
+			valueLen := int(binary.LittleEndian.Uint32(record[off+16:]))
+			valueOff := off + int(binary.LittleEndian.Uint16(record[off+20:]))
+
+			if valueOff+valueLen <= len(record) {
+				sig.Label = decodeUTF16LE(record[valueOff : valueOff+valueLen])
+			}
+
+			break
+		}
+
+		off += attrLen
+	}
+
+	return sig
+}
+
+// applyNTFSFixups restores the last two bytes of every sector of an MFT record, which are replaced by
+// the update sequence number on disk to detect torn writes. It reports whether the record is intact.
+func applyNTFSFixups(record []byte) bool {
+	usaOff := int(binary.LittleEndian.Uint16(record[4:6]))
+	usaCount := int(binary.LittleEndian.Uint16(record[6:8]))
+
+	if usaCount == 0 || usaOff+2*usaCount > len(record) || (usaCount-1)*ntfsFixupSectorSize > len(record) {
+		return false
+	}
+
+	usn := record[usaOff : usaOff+2]
+
+	for i := 1; i < usaCount; i++ {
+		end := i * ntfsFixupSectorSize
+		if !bytes.Equal(record[end-2:end], usn) {
+			return false
+		}
+
+		copy(record[end-2:end], record[usaOff+2*i:usaOff+2*i+2])
+	}
+
+	return true
+}
+
+// decodeUTF16LE decodes a little endian UTF-16 string.
+func decodeUTF16LE(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+
+	return string(utf16.Decode(units))
+}
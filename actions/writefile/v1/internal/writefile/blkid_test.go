@@ -0,0 +1,317 @@
+package writefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+var testUUID = []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+
+const testUUIDString = "12345678-9abc-def0-0123-456789abcdef"
+
+// extImage returns a device with an ext superblock carrying the compat and incompat feature flags.
+func extImage(compat, incompat uint32, label string) []byte {
+	b := make([]byte, 4096)
+	sb := b[1024:]
+	binary.LittleEndian.PutUint16(sb[56:], 0xEF53)
+	binary.LittleEndian.PutUint32(sb[92:], compat)
+	binary.LittleEndian.PutUint32(sb[96:], incompat)
+	copy(sb[104:], testUUID)
+	copy(sb[120:136], label)
+
+	return b
+}
+
+func xfsImage(label string) []byte {
+	b := make([]byte, 4096)
+	copy(b, "XFSB")
+	copy(b[32:], testUUID)
+	copy(b[108:120], label)
+
+	return b
+}
+
+func btrfsImage(label string) []byte {
+	b := make([]byte, 0x10000+4096)
+	sb := b[0x10000:]
+	copy(sb[0x20:], testUUID)
+	copy(sb[0x40:], "_BHRfS_M")
+	copy(sb[0x12b:], label)
+
+	return b
+}
+
+// vfatImage returns a FAT boot sector, fsType is the type in the extended boot record, FAT32 or
+// FAT16.
+func vfatImage(fsType string, serial uint32, label string) []byte {
+	b := make([]byte, 512)
+	b[510], b[511] = 0x55, 0xAA
+
+	idOff, labelOff, typeOff := 39, 43, 54
+	if fsType == "FAT32" {
+		idOff, labelOff, typeOff = 67, 71, 82
+	}
+
+	binary.LittleEndian.PutUint32(b[idOff:], serial)
+	copy(b[labelOff:labelOff+11], label+"           ")
+	copy(b[typeOff:], fsType+"   ")
+
+	return b
+}
+
+// ntfsImage returns an NTFS volume with 512 byte sectors, 4KiB clusters and 1KiB MFT records, whose
+// $Volume record carries label. A torn record has a sector that doesn't end with the update
+// sequence number.
+func ntfsImage(label string, torn bool) []byte {
+	const (
+		clusterSize = 4096
+		recordSize  = 1024
+		mftCluster  = 1
+	)
+
+	b := make([]byte, mftCluster*clusterSize+4*recordSize)
+	copy(b[3:], "NTFS    ")
+	binary.LittleEndian.PutUint16(b[11:], 512)
+	b[13] = clusterSize / 512
+	binary.LittleEndian.PutUint64(b[48:], mftCluster)
+	b[64] = 0xF6 // -10, records are 2^10 bytes
+	binary.LittleEndian.PutUint64(b[72:], 0x0123456789ABCDEF)
+
+	record := b[mftCluster*clusterSize+ntfsVolumeRecord*recordSize:][:recordSize]
+	copy(record, "FILE")
+
+	// The update sequence array holds the number and the original end of both sectors of the record
+	binary.LittleEndian.PutUint16(record[4:], 48)
+	binary.LittleEndian.PutUint16(record[6:], 3)
+	binary.LittleEndian.PutUint16(record[20:], 56)
+	copy(record[48:], []byte{0x01, 0x00, 0xaa, 0xbb, 0xcc, 0xdd})
+
+	value := utf16LE(label)
+	attrLen := (24 + len(value) + 7) &^ 7
+	attr := record[56:]
+	binary.LittleEndian.PutUint32(attr[0:], ntfsVolumeNameAttr)
+	binary.LittleEndian.PutUint32(attr[4:], uint32(attrLen))
+	binary.LittleEndian.PutUint32(attr[16:], uint32(len(value)))
+	binary.LittleEndian.PutUint16(attr[20:], 24)
+	copy(attr[24:], value)
+	binary.LittleEndian.PutUint32(attr[attrLen:], ntfsEndAttr)
+
+	copy(record[510:], []byte{0x01, 0x00})
+	copy(record[1022:], []byte{0x01, 0x00})
+
+	if torn {
+		copy(record[1022:], []byte{0x02, 0x00})
+	}
+
+	return b
+}
+
+func utf16LE(s string) []byte {
+	units := utf16.Encode([]rune(s))
+
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+
+	return b
+}
+
+func TestSuperblockProbes(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe superblockProbe
+		image []byte
+		want  *fsSignature
+	}{
+		{
+			name:  "ext2",
+			probe: probeExt,
+			image: extImage(0, 0x2, "boot"),
+			want:  &fsSignature{Type: "ext2", UUID: testUUIDString, Label: "boot"},
+		},
+		{
+			name:  "ext3",
+			probe: probeExt,
+			image: extImage(0x4, 0x2, ""),
+			want:  &fsSignature{Type: "ext3", UUID: testUUIDString},
+		},
+		{
+			name:  "ext4",
+			probe: probeExt,
+			image: extImage(0x4, 0x2|0x40|0x80, "cloudimg-rootfs"),
+			want:  &fsSignature{Type: "ext4", UUID: testUUIDString, Label: "cloudimg-rootfs"},
+		},
+		{name: "ext without magic", probe: probeExt, image: make([]byte, 4096)},
+		{name: "ext on a short device", probe: probeExt, image: make([]byte, 1024)},
+		{
+			name:  "xfs",
+			probe: probeXFS,
+			image: xfsImage("data"),
+			want:  &fsSignature{Type: "xfs", UUID: testUUIDString, Label: "data"},
+		},
+		{name: "xfs without magic", probe: probeXFS, image: extImage(0, 0, "")},
+		{
+			name:  "btrfs",
+			probe: probeBtrfs,
+			image: btrfsImage("pool"),
+			want:  &fsSignature{Type: "btrfs", UUID: testUUIDString, Label: "pool"},
+		},
+		{name: "btrfs on a short device", probe: probeBtrfs, image: make([]byte, 0x10000)},
+		{
+			name:  "fat32",
+			probe: probeVFAT,
+			image: vfatImage("FAT32", 0x1234ABCD, "EFI"),
+			want:  &fsSignature{Type: "vfat", UUID: "1234-ABCD", Label: "EFI"},
+		},
+		{
+			name:  "fat16",
+			probe: probeVFAT,
+			image: vfatImage("FAT16", 0x00C0FFEE, "CIDATA"),
+			want:  &fsSignature{Type: "vfat", UUID: "00C0-FFEE", Label: "CIDATA"},
+		},
+		{
+			name:  "fat without a label",
+			probe: probeVFAT,
+			image: vfatImage("FAT32", 0x1234ABCD, "NO NAME"),
+			want:  &fsSignature{Type: "vfat", UUID: "1234-ABCD"},
+		},
+		{name: "boot sector that isn't fat", probe: probeVFAT, image: vfatImage("EXT", 0, "")},
+		{
+			name:  "ntfs",
+			probe: probeNTFS,
+			image: ntfsImage("Windows", false),
+			want:  &fsSignature{Type: "ntfs", UUID: "0123456789ABCDEF", Label: "Windows"},
+		},
+		{
+			name:  "ntfs with a label outside the BMP",
+			probe: probeNTFS,
+			image: ntfsImage("Data \U0001F4BE", false),
+			want:  &fsSignature{Type: "ntfs", UUID: "0123456789ABCDEF", Label: "Data \U0001F4BE"},
+		},
+		{
+			name:  "ntfs with a torn volume record",
+			probe: probeNTFS,
+			image: ntfsImage("Windows", true),
+			want:  &fsSignature{Type: "ntfs", UUID: "0123456789ABCDEF"},
+		},
+		{name: "ntfs without magic", probe: probeNTFS, image: vfatImage("FAT32", 0, "")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.probe(bytes.NewReader(tt.image)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("probe() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyNTFSFixups(t *testing.T) {
+	tests := []struct {
+		name     string
+		usaOff   uint16
+		usaCount uint16
+		// ends are the last two bytes of both sectors of the record on disk
+		ends [2][2]byte
+		want bool
+	}{
+		{name: "intact", usaOff: 48, usaCount: 3, ends: [2][2]byte{{1, 0}, {1, 0}}, want: true},
+		{name: "torn first sector", usaOff: 48, usaCount: 3, ends: [2][2]byte{{2, 0}, {1, 0}}},
+		{name: "torn last sector", usaOff: 48, usaCount: 3, ends: [2][2]byte{{1, 0}, {0, 0}}},
+		{name: "no update sequence", usaOff: 48, usaCount: 0, ends: [2][2]byte{{1, 0}, {1, 0}}},
+		{name: "array past the end", usaOff: 1020, usaCount: 3, ends: [2][2]byte{{1, 0}, {1, 0}}},
+		{name: "more sectors than the record", usaOff: 48, usaCount: 4, ends: [2][2]byte{{1, 0}, {1, 0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := make([]byte, 1024)
+			binary.LittleEndian.PutUint16(record[4:], tt.usaOff)
+			binary.LittleEndian.PutUint16(record[6:], tt.usaCount)
+
+			if int(tt.usaOff)+6 <= len(record) {
+				copy(record[tt.usaOff:], []byte{0x01, 0x00, 0xaa, 0xbb, 0xcc, 0xdd})
+			}
+
+			copy(record[510:], tt.ends[0][:])
+			copy(record[1022:], tt.ends[1][:])
+
+			if got := applyNTFSFixups(record); got != tt.want {
+				t.Fatalf("applyNTFSFixups() = %v, want %v", got, tt.want)
+			}
+
+			if tt.want && (!bytes.Equal(record[510:512], []byte{0xaa, 0xbb}) || !bytes.Equal(record[1022:1024], []byte{0xcc, 0xdd})) {
+				t.Errorf("applyNTFSFixups() didn't restore the end of the sectors, got % x and % x", record[510:512], record[1022:1024])
+			}
+		})
+	}
+}
+
+func TestFormatUUID(t *testing.T) {
+	if got := formatUUID(testUUID); got != testUUIDString {
+		t.Errorf("formatUUID() = %s, want %s", got, testUUIDString)
+	}
+}
+
+func TestCString(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"null terminated", []byte("root\x00\x00garbage"), "root"},
+		{"space padded", []byte("EFI        "), "EFI"},
+		{"full", []byte("cloudimg-rootfs!"), "cloudimg-rootfs!"},
+		{"empty", make([]byte, 16), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cString(tt.b); got != tt.want {
+				t.Errorf("cString(%q) = %q, want %q", tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectFilesystem(t *testing.T) {
+	xfsAndExt := xfsImage("")
+	copy(xfsAndExt[1024:], extImage(0, 0, "")[1024:])
+
+	tests := []struct {
+		name    string
+		image   []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "ext4", image: extImage(0x4, 0x40, ""), want: "ext4"},
+		{name: "xfs", image: xfsImage(""), want: "xfs"},
+		{name: "fat32", image: vfatImage("FAT32", 0, ""), want: "vfat"},
+		{name: "no filesystem", image: make([]byte, 4096), wantErr: true},
+		{name: "conflicting signatures", image: xfsAndExt, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := filepath.Join(t.TempDir(), "disk")
+			if err := ioutil.WriteFile(device, tt.image, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := detectFilesystem(device)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("detectFilesystem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("detectFilesystem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
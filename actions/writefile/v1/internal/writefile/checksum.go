@@ -0,0 +1,42 @@
+package writefile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// verifySHA256 checks that the SHA256 digest of contents is the hex encoded expected digest.
+func verifySHA256(contents []byte, expected string) error {
+	sum := sha256.Sum256(contents)
+	actual := hex.EncodeToString(sum[:])
+
+	if !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		return fmt.Errorf("sha256 of %d bytes is %s, expected %s", len(contents), actual, expected)
+	}
+
+	return nil
+}
+
+// fileSHA256 returns the SHA256 digest of the file at path.
+func fileSHA256(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+
+	copy(sum[:], h.Sum(nil))
+
+	return sum, nil
+}
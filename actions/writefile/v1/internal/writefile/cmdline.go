@@ -0,0 +1,70 @@
+package writefile
+
+import (
+	"strings"
+	"unicode"
+)
+
+// kernelCmdline is where the kernel command line of the running system is read from.
+const kernelCmdline = "/proc/cmdline"
+
+// cmdlineValue returns the value of key on the kernel command line cmdline. Values may be double
+// quoted to include spaces, and when the key appears more than once the last value wins as it does
+// for the kernel.
+func cmdlineValue(cmdline, key string) (string, bool) {
+	var (
+		value string
+		found bool
+	)
+
+	for _, param := range splitCmdline(cmdline) {
+		name, val := param, ""
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			name, val = param[:i], param[i+1:]
+		}
+
+		if name == key {
+			value, found = strings.Trim(val, `"`), true
+		}
+	}
+
+	return value, found
+}
+
+// splitCmdline splits a kernel command line into its parameters, whitespace inside double quotes
+// does not separate parameters.
+func splitCmdline(cmdline string) []string {
+	var (
+		params  []string
+		current strings.Builder
+		quoted  bool
+	)
+
+	for _, r := range cmdline {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				params = append(params, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if current.Len() > 0 {
+		params = append(params, current.String())
+	}
+
+	// A parameter may be quoted as a whole, e.g. "key=value with spaces"
+	for i, param := range params {
+		if len(param) > 1 && strings.HasPrefix(param, `"`) && strings.HasSuffix(param, `"`) {
+			params[i] = param[1 : len(param)-1]
+		}
+	}
+
+	return params
+}
@@ -0,0 +1,88 @@
+package writefile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCmdlineValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		cmdline   string
+		key       string
+		want      string
+		wantFound bool
+	}{
+		{
+			name:      "plain value",
+			cmdline:   "BOOT_IMAGE=/vmlinuz root=/dev/sda1 ro quiet\n",
+			key:       "root",
+			want:      "/dev/sda1",
+			wantFound: true,
+		},
+		{
+			name:      "value with an equals sign",
+			cmdline:   "console=ttyS0 url=http://10.0.0.1/userdata?id=a",
+			key:       "url",
+			want:      "http://10.0.0.1/userdata?id=a",
+			wantFound: true,
+		},
+		{
+			name:      "quoted value",
+			cmdline:   `ro motd="hello  world" quiet`,
+			key:       "motd",
+			want:      "hello  world",
+			wantFound: true,
+		},
+		{
+			name:      "quoted parameter",
+			cmdline:   `ro "motd=hello world" quiet`,
+			key:       "motd",
+			want:      "hello world",
+			wantFound: true,
+		},
+		{
+			name:      "last value wins",
+			cmdline:   "url=http://a url=http://b",
+			key:       "url",
+			want:      "http://b",
+			wantFound: true,
+		},
+		{name: "flag without a value", cmdline: "ro quiet", key: "quiet", wantFound: true},
+		{name: "empty value", cmdline: "url= quiet", key: "url", wantFound: true},
+		{name: "missing", cmdline: "ro quiet", key: "url"},
+		{name: "key is a prefix of another", cmdline: "urls=http://a", key: "url"},
+		{name: "empty command line", cmdline: "", key: "url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := cmdlineValue(tt.cmdline, tt.key)
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("cmdlineValue(%q, %q) = %q, %v, want %q, %v", tt.cmdline, tt.key, got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestSplitCmdline(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmdline string
+		want    []string
+	}{
+		{"whitespace", " ro\tquiet \n", []string{"ro", "quiet"}},
+		{"quoted value", `a="b c" d`, []string{`a="b c"`, "d"}},
+		{"quoted parameter", `"a=b c" d`, []string{"a=b c", "d"}},
+		{"unterminated quote", `a="b c d`, []string{`a="b c d`}},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitCmdline(tt.cmdline); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCmdline(%q) = %q, want %q", tt.cmdline, got, tt.want)
+			}
+		})
+	}
+}
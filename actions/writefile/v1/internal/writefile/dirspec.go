@@ -0,0 +1,109 @@
+package writefile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dirSpec is the mode and ownership that DIRSPEC gives a directory when it is created, anything it
+// leaves unset falls back to the DIRMODE and ownership of the file being written.
+type dirSpec struct {
+	mode *os.FileMode
+	// user and group are either numeric ids or names that are looked up on the destination filesystem.
+	user  string
+	group string
+}
+
+// dirSpecs are the directory specs by their cleaned path.
+type dirSpecs map[string]dirSpec
+
+// parseDirSpecs parses directory specs given one per line in the form "path mode [user[:group]]",
+// e.g. "/home/alice/.ssh 0700 alice:alice". A mode of "-" keeps DIRMODE and the user and group may
+// each be an id or a name.
+func parseDirSpecs(value string) (dirSpecs, error) {
+	specs := dirSpecs{}
+
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("directory spec %q must be in the form path mode [user[:group]]", line)
+		}
+
+		if !filepath.IsAbs(fields[0]) {
+			return nil, fmt.Errorf("directory spec path %s must be an absolute path", fields[0])
+		}
+
+		var spec dirSpec
+
+		if fields[1] != "-" {
+			mode, err := parseMode(fields[1], "")
+			if err != nil {
+				return nil, fmt.Errorf("could not parse mode of directory spec %s: %w", fields[0], err)
+			}
+
+			spec.mode = &mode
+		}
+
+		if len(fields) == 3 {
+			owner := strings.SplitN(fields[2], ":", 2)
+			spec.user = owner[0]
+			if len(owner) == 2 {
+				spec.group = owner[1]
+			}
+		}
+
+		path := filepath.Clean(fields[0])
+		if _, ok := specs[path]; ok {
+			return nil, fmt.Errorf("directory %s has more than one spec", path)
+		}
+
+		specs[path] = spec
+	}
+
+	return specs, nil
+}
+
+// apply returns the mode, uid and gid that the directory at path under rootPath is created with,
+// overriding the given ones with its spec if it has one.
+func (s dirSpecs) apply(rootPath, path string, mode os.FileMode, uid, gid int) (os.FileMode, int, int, error) {
+	spec, ok := s[filepath.Clean(path)]
+	if !ok {
+		return mode, uid, gid, nil
+	}
+
+	if spec.mode != nil {
+		mode = *spec.mode
+	}
+
+	var err error
+
+	if spec.user != "" {
+		if uid, err = resolveSpecOwner(filepath.Join(rootPath, passwdFile), spec.user); err != nil {
+			return 0, 0, 0, fmt.Errorf("could not look up user of directory spec %s: %w", path, err)
+		}
+	}
+
+	if spec.group != "" {
+		if gid, err = resolveSpecOwner(filepath.Join(rootPath, groupFile), spec.group); err != nil {
+			return 0, 0, 0, fmt.Errorf("could not look up group of directory spec %s: %w", path, err)
+		}
+	}
+
+	return mode, uid, gid, nil
+}
+
+// resolveSpecOwner returns owner as an id when it is numeric, otherwise it looks the name up in database.
+func resolveSpecOwner(database, owner string) (int, error) {
+	if id, err := strconv.Atoi(owner); err == nil {
+		return id, nil
+	}
+
+	return lookupID(database, owner)
+}
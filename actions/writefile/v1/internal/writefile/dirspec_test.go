@@ -0,0 +1,116 @@
+package writefile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDirSpecs(t *testing.T) {
+	mode := func(m os.FileMode) *os.FileMode { return &m }
+
+	tests := []struct {
+		name    string
+		value   string
+		want    dirSpecs
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: dirSpecs{}},
+		{
+			name:  "mode and owner",
+			value: "/home/alice/.ssh 0700 alice:alice",
+			want:  dirSpecs{"/home/alice/.ssh": {mode: mode(0o700), user: "alice", group: "alice"}},
+		},
+		{
+			name:  "user only",
+			value: "/srv 0755 1000",
+			want:  dirSpecs{"/srv": {mode: mode(0o755), user: "1000"}},
+		},
+		{
+			name:  "group only",
+			value: "/srv 0775 :wheel",
+			want:  dirSpecs{"/srv": {mode: mode(0o775), group: "wheel"}},
+		},
+		{
+			name:  "mode kept",
+			value: "/var/lib/app - app:app",
+			want:  dirSpecs{"/var/lib/app": {user: "app", group: "app"}},
+		},
+		{
+			name:  "comments, blank lines and uncleaned paths",
+			value: "# ssh\n\n  /home/alice/.ssh/ 0700 \n/etc//app 0750\n",
+			want: dirSpecs{
+				"/home/alice/.ssh": {mode: mode(0o700)},
+				"/etc/app":         {mode: mode(0o750)},
+			},
+		},
+		{name: "path only", value: "/srv", wantErr: true},
+		{name: "too many fields", value: "/srv 0755 root root", wantErr: true},
+		{name: "relative path", value: "srv 0755", wantErr: true},
+		{name: "invalid mode", value: "/srv rwx", wantErr: true},
+		{name: "duplicate path", value: "/srv 0755\n/srv/ 0700", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDirSpecs(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDirSpecs(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDirSpecs(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirSpecsApply(t *testing.T) {
+	rootPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootPath, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(rootPath, passwdFile), []byte("root:x:0:0::/root:/bin/sh\nalice:x:1000:1000::/home/alice:/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(rootPath, groupFile), []byte("root:x:0:\nwheel:x:10:alice\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	specs, err := parseDirSpecs("/home/alice/.ssh 0700 alice:wheel\n/srv - 2000\n/var/lib/app 0750 bob\n/opt 0755 :staff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		wantMode os.FileMode
+		wantUID  int
+		wantGID  int
+		wantErr  bool
+	}{
+		{name: "no spec", path: "/etc", wantMode: 0o755, wantUID: 1, wantGID: 2},
+		{name: "names", path: "/home/alice/.ssh/", wantMode: 0o700, wantUID: 1000, wantGID: 10},
+		{name: "numeric user and mode kept", path: "/srv", wantMode: 0o755, wantUID: 2000, wantGID: 2},
+		{name: "unknown user", path: "/var/lib/app", wantErr: true},
+		{name: "unknown group", path: "/opt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, uid, gid, err := specs.apply(rootPath, tt.path, 0o755, 1, 2)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply(%s) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && (mode != tt.wantMode || uid != tt.wantUID || gid != tt.wantGID) {
+				t.Errorf("apply(%s) = %04o, %d, %d, want %04o, %d, %d", tt.path, mode, uid, gid, tt.wantMode, tt.wantUID, tt.wantGID)
+			}
+		})
+	}
+}
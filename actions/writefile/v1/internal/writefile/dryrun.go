@@ -0,0 +1,80 @@
+package writefile
+
+import (
+	"crypto/sha256"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// printPlan logs what the action would do to blockDevice without mounting or writing anything. A
+// filesystem that still has to be detected is probed, as reading the superblock changes nothing.
+func printPlan(blockDevice, filesystemType, mountOptions string, encrypted bool, files []*fileWrite) error {
+	switch {
+	case encrypted:
+		log.Infof("Dry run: would unlock LUKS device [%s]", blockDevice)
+	case filesystemType == "":
+		detected, err := detectFilesystem(blockDevice)
+		if err != nil {
+			return deviceFailed("no filesystem type specified with [FS_TYPE] and it could not be detected on [%s]: %v", blockDevice, err)
+		}
+
+		filesystemType = detected
+	}
+
+	if filesystemType == "" {
+		filesystemType = "detected after unlocking"
+	}
+
+	log.Infof("Dry run: would mount [%s] with filesystem type [%s] and options [%s]", blockDevice, filesystemType, mountOptions)
+
+	for _, file := range files {
+		if file.remove {
+			log.Infof("Dry run: would delete [%s]", file.path)
+			continue
+		}
+
+		if file.linkTarget != "" {
+			log.Infof("Dry run: would link [%s] -> [%s] with owner %s:%s", file.path, file.linkTarget,
+				ownerName(file.uid, file.user), ownerName(file.gid, file.group))
+
+			continue
+		}
+
+		size, sum, err := contentsDigest(file)
+		if err != nil {
+			return fetchFailed("could not fetch contents of [%s]: %v", file.path, err)
+		}
+
+		log.Infof("Dry run: would write [%s] (%d bytes, sha256 %x) with mode %04o, owner %s:%s and write mode %s",
+			file.path, size, sum, file.mode,
+			ownerName(file.uid, file.user), ownerName(file.gid, file.group), file.writeMode)
+	}
+
+	return nil
+}
+
+// contentsDigest returns the size and SHA256 digest of the contents of file, streamed contents are
+// hashed as they are read rather than held in memory.
+func contentsDigest(file *fileWrite) (int64, []byte, error) {
+	h := sha256.New()
+
+	size, err := file.writeContents(h)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return size, h.Sum(nil), nil
+}
+
+// ownerName describes a uid or gid that may only be resolved once the destination is mounted.
+func ownerName(id int, name string) string {
+	switch id {
+	case namedOwner:
+		return name
+	case inheritOwner:
+		return "<parent>"
+	default:
+		return strconv.Itoa(id)
+	}
+}
@@ -0,0 +1,75 @@
+package writefile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode"
+)
+
+// The encodings the contents of a file can be supplied in.
+const (
+	// encodingPlain is contents that are written as they are.
+	encodingPlain = "plain"
+	// encodingBase64 is base64 encoded contents.
+	encodingBase64 = "base64"
+	// encodingGzipBase64 is gzip compressed contents that are then base64 encoded.
+	encodingGzipBase64 = "gzip+base64"
+)
+
+// decodeContents decodes contents that were supplied with the given encoding.
+func decodeContents(contents, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", encodingPlain:
+		return []byte(contents), nil
+	case encodingBase64:
+		return decodeBase64(contents)
+	case encodingGzipBase64:
+		compressed, err := decodeBase64(contents)
+		if err != nil {
+			return nil, err
+		}
+
+		return gunzip(compressed)
+	default:
+		return nil, fmt.Errorf("unknown encoding %s, must be one of [%s, %s, %s]",
+			encoding, encodingPlain, encodingBase64, encodingGzipBase64)
+	}
+}
+
+// gunzip decompresses gzip compressed contents.
+func gunzip(compressed []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress gzip contents: %w", err)
+	}
+	defer zr.Close()
+
+	decompressed, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress gzip contents: %w", err)
+	}
+
+	return decompressed, nil
+}
+
+// decodeBase64 decodes base64 contents, ignoring any whitespace such as the line breaks of a
+// multi-line YAML value.
+func decodeBase64(contents string) ([]byte, error) {
+	stripped := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, contents)
+
+	decoded, err := base64.StdEncoding.DecodeString(stripped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 contents: %w", err)
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,60 @@
+package writefile
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The exit codes of the action, they let a workflow tell input that will never work apart from
+// failures that may succeed when the action is retried.
+const (
+	// ExitCodeFailure is any failure that doesn't fall into one of the classes below.
+	ExitCodeFailure = 1
+	// ExitCodeInvalidInput is an environment variable, manifest or template that is invalid.
+	ExitCodeInvalidInput = 2
+	// ExitCodeAborted is the action stopping without writing because the abort flag file exists.
+	ExitCodeAborted = 3
+	// ExitCodeFetch is a failure to fetch contents or metadata, which is usually transient.
+	ExitCodeFetch = 4
+	// ExitCodeDevice is a failure to prepare, mount or write to the destination device.
+	ExitCodeDevice = 5
+)
+
+// exitError is an error with the exit code of its class.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// invalidInput returns an error for input that is invalid.
+func invalidInput(format string, a ...interface{}) error {
+	return &exitError{code: ExitCodeInvalidInput, err: fmt.Errorf(format, a...)}
+}
+
+// fetchFailed returns an error for contents or metadata that could not be fetched.
+func fetchFailed(format string, a ...interface{}) error {
+	return &exitError{code: ExitCodeFetch, err: fmt.Errorf(format, a...)}
+}
+
+// deviceFailed returns an error for a destination device that could not be prepared or written.
+func deviceFailed(format string, a ...interface{}) error {
+	return &exitError{code: ExitCodeDevice, err: fmt.Errorf(format, a...)}
+}
+
+// ExitCode returns the exit code of the class of err.
+func ExitCode(err error) int {
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+
+	return ExitCodeFailure
+}
@@ -0,0 +1,102 @@
+package writefile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/fetch"
+)
+
+// fetchTimeout bounds how long fetching remote contents may take, or for streamed contents how long
+// the response may take to start.
+const fetchTimeout = 60 * time.Second
+
+// fetchURL retrieves the body of contentsURL, an s3:// URL is fetched from the S3 HTTPS endpoint of
+// the bucket.
+func fetchURL(contentsURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	resp, err := getURL(ctx, contentsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// openURL starts fetching the body of contentsURL and returns it to be streamed. The fetch timeout
+// only bounds how long the response takes to start, as a large body may take much longer to read.
+func openURL(contentsURL string) (io.ReadCloser, error) {
+	return fetch.Open(context.Background(), fetchTimeout, func(ctx context.Context) (*http.Response, error) {
+		return getURL(ctx, contentsURL)
+	})
+}
+
+// getURL requests contentsURL and returns the response when it was successful.
+func getURL(ctx context.Context, contentsURL string) (*http.Response, error) {
+	httpURL, err := s3ToHTTPS(contentsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+
+		// Customise response for the 404 to make debugging simpler
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%s not found", fetch.RedactURL(contentsURL))
+		}
+
+		// A private object is the usual reason S3 refuses a request, as they aren't signed
+		if resp.StatusCode == http.StatusForbidden && httpURL != contentsURL {
+			return nil, fmt.Errorf("%s: %s, s3:// requests are not signed so the object must be public or the URL presigned",
+				fetch.RedactURL(contentsURL), resp.Status)
+		}
+
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// s3ToHTTPS rewrites an s3://bucket/key URL to the virtual hosted HTTPS URL of the object, in the
+// region set by AWS_REGION when there is one. Requests are not signed, so the object has to be public
+// or the S3 URL has to carry the query of a presigned URL. Any other URL is returned as is.
+func s3ToHTTPS(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "s3" {
+		return rawURL, nil
+	}
+
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return "", fmt.Errorf("%s must be of the form s3://bucket/key", fetch.RedactURL(rawURL))
+	}
+
+	host := u.Host + ".s3.amazonaws.com"
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		host = u.Host + ".s3." + region + ".amazonaws.com"
+	}
+
+	u.Scheme, u.Host = "https", host
+
+	return u.String(), nil
+}
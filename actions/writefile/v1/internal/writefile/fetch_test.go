@@ -0,0 +1,77 @@
+package writefile
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestGetURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"partial content", http.StatusPartialContent, false},
+		{"multiple choices", http.StatusMultipleChoices, true},
+		{"not modified", http.StatusNotModified, true},
+		{"forbidden", http.StatusForbidden, true},
+		{"not found", http.StatusNotFound, true},
+		{"server error", http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			resp, err := getURL(context.Background(), server.URL+"/contents")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getURL() with status %d error = %v, wantErr %v", tt.status, err, tt.wantErr)
+			}
+
+			if resp != nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
+func TestS3ToHTTPS(t *testing.T) {
+	defer os.Setenv("AWS_REGION", os.Getenv("AWS_REGION"))
+
+	tests := []struct {
+		name    string
+		region  string
+		rawURL  string
+		want    string
+		wantErr bool
+	}{
+		{"https", "", "https://example.com/a?b=c", "https://example.com/a?b=c", false},
+		{"s3", "", "s3://bucket/dir/key", "https://bucket.s3.amazonaws.com/dir/key", false},
+		{"s3 in a region", "eu-west-1", "s3://bucket/key", "https://bucket.s3.eu-west-1.amazonaws.com/key", false},
+		{"s3 presigned", "", "s3://bucket/key?X-Amz-Signature=abc", "https://bucket.s3.amazonaws.com/key?X-Amz-Signature=abc", false},
+		{"s3 without a key", "", "s3://bucket/", "", true},
+		{"s3 without a bucket", "", "s3:///key", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("AWS_REGION", tt.region)
+
+			got, err := s3ToHTTPS(tt.rawURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("s3ToHTTPS(%q) error = %v, wantErr %v", tt.rawURL, err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("s3ToHTTPS(%q) = %q, want %q", tt.rawURL, got, tt.want)
+			}
+		})
+	}
+}
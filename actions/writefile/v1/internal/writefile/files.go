@@ -0,0 +1,560 @@
+package writefile
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// filesManifest describes several files that are written in a single mount cycle, it is read from
+// MANIFEST or MANIFEST_URL as either YAML or JSON.
+type filesManifest struct {
+	Files []manifestFile `yaml:"files"`
+}
+
+// manifestFile is a single file of a files manifest, any metadata it doesn't set falls back to the
+// MODE, DIRMODE, UID, GID, USER, GROUP, XATTRS and ACL environment variables.
+type manifestFile struct {
+	Path      string `yaml:"path"`
+	Contents  string `yaml:"contents"`
+	Mode      string `yaml:"mode"`
+	DirMode   string `yaml:"dirmode"`
+	UID       *int   `yaml:"uid"`
+	GID       *int   `yaml:"gid"`
+	User      string `yaml:"user"`
+	Group     string `yaml:"group"`
+	SELinux   string `yaml:"selinux"`
+	Xattrs    string `yaml:"xattrs"`
+	ACL       string `yaml:"acl"`
+	Link      string `yaml:"link"`
+	Delete    bool   `yaml:"delete"`
+	WriteMode string `yaml:"writemode"`
+	Encoding  string `yaml:"encoding"`
+}
+
+// parseFilesManifest parses a YAML or JSON files manifest.
+func parseFilesManifest(raw []byte) (*filesManifest, error) {
+	manifest := &filesManifest{}
+	if err := yaml.Unmarshal(raw, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	if len(manifest.Files) == 0 {
+		return nil, errors.New("manifest does not contain any files")
+	}
+
+	return manifest, nil
+}
+
+// selinuxXattr is the extended attribute that holds the SELinux security context of a file.
+const selinuxXattr = "security.selinux"
+
+// The ways in which a file can be written.
+const (
+	// writeModeOverwrite replaces the contents of an existing file.
+	writeModeOverwrite = "overwrite"
+	// writeModeAppend appends to the contents of an existing file.
+	writeModeAppend = "append"
+	// writeModeCreateOnly fails if the file already exists.
+	writeModeCreateOnly = "create-only"
+)
+
+// fileDefaults are the values of the MODE, DIRMODE, UID, GID, USER, GROUP, WRITE_MODE,
+// CONTENTS_ENCODING, SELINUX_CONTEXT, XATTRS and ACL environment variables.
+type fileDefaults struct {
+	mode      string
+	dirMode   string
+	uid       string
+	gid       string
+	user      string
+	group     string
+	writeMode string
+	encoding  string
+	selinux   string
+	xattrs    string
+	acl       string
+	// inheritOwner is set when an unset uid or gid is inherited from the parent directory.
+	inheritOwner bool
+	// atomic is set when files are written to a temporary file that is renamed into place.
+	atomic bool
+	// skipUnchanged is set when files that already have the contents and metadata are left alone.
+	skipUnchanged bool
+	// backup is set when existing files are copied aside before they are written.
+	backup bool
+	// linkForce is set when whatever is in the way of a symlink is replaced.
+	linkForce bool
+	// dirSpecs override the mode and ownership of the parent directories that are created.
+	dirSpecs dirSpecs
+}
+
+// fileWrite is a single file to write with its metadata resolved.
+type fileWrite struct {
+	path     string
+	contents []byte
+	// body streams the contents when they are fetched from a URL, it is read instead of contents
+	// when it is set.
+	body      io.ReadCloser
+	mode      os.FileMode
+	dirMode   os.FileMode
+	uid       int
+	gid       int
+	writeMode string
+	atomic    bool
+	// user and group are names that are looked up on the destination filesystem when uid or gid
+	// is namedOwner.
+	user  string
+	group string
+	// selinux is the SELinux security context of the file, it is left unset when empty.
+	selinux string
+	// skipUnchanged is set when the file is left alone if it already has the contents and metadata.
+	skipUnchanged bool
+	// xattrs are extended attributes that are set on the file once it is owned by uid and gid.
+	xattrs map[string][]byte
+	// acl is the access ACL of the file and defaultACL is the default ACL of its parent directory.
+	acl        posixACL
+	defaultACL posixACL
+	// backup is set when an existing file is copied aside before it is written.
+	backup bool
+	// linkTarget is set when a symlink to it is created instead of writing contents, linkForce is
+	// set when whatever is in the way of the symlink is replaced.
+	linkTarget string
+	linkForce  bool
+	// remove is set when the file or directory tree at path is deleted instead of written.
+	remove bool
+	// dirSpecs override dirMode, uid and gid for the parent directories they name.
+	dirSpecs dirSpecs
+}
+
+// resolve validates file and fills in any metadata it doesn't set from the defaults.
+func (d fileDefaults) resolve(file manifestFile) (*fileWrite, error) {
+	if !filepath.IsAbs(file.Path) {
+		return nil, errors.New("path must be an absolute path")
+	}
+
+	if escapesRoot(file.Path) {
+		return nil, errors.New("path must not climb above the root directory with ..")
+	}
+
+	if _, fileName := filepath.Split(file.Path); len(fileName) == 0 {
+		return nil, errors.New("path must include a file component")
+	}
+
+	if file.Delete {
+		if file.Contents != "" || file.Link != "" {
+			return nil, errors.New("a deleted path can't have contents or a link target")
+		}
+
+		// A path such as /etc/.. would otherwise delete the whole filesystem
+		if filepath.Clean(file.Path) == "/" {
+			return nil, errors.New("the root directory can't be deleted")
+		}
+
+		return &fileWrite{path: file.Path, remove: true}, nil
+	}
+
+	mode, err := parseMode(file.Mode, d.mode)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse mode: %w", err)
+	}
+
+	dirMode, err := parseMode(file.DirMode, d.dirMode)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse dirmode: %w", err)
+	}
+
+	uid, user, err := d.parseNamedOwner(file.UID, file.User, d.uid, d.user)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse uid: %w", err)
+	}
+
+	gid, group, err := d.parseNamedOwner(file.GID, file.Group, d.gid, d.group)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse gid: %w", err)
+	}
+
+	writeMode := file.WriteMode
+	if writeMode == "" {
+		writeMode = d.writeMode
+	}
+
+	switch writeMode {
+	case "":
+		writeMode = writeModeOverwrite
+	case writeModeOverwrite, writeModeAppend, writeModeCreateOnly:
+	default:
+		return nil, fmt.Errorf("unknown write mode %s, must be one of [%s, %s, %s]",
+			writeMode, writeModeOverwrite, writeModeAppend, writeModeCreateOnly)
+	}
+
+	selinux := file.SELinux
+	if selinux == "" {
+		selinux = d.selinux
+	}
+
+	if selinux != "" && strings.Count(selinux, ":") < 2 {
+		return nil, fmt.Errorf("invalid SELinux context %s, must be of the form user:role:type[:level]", selinux)
+	}
+
+	rawXattrs := file.Xattrs
+	if rawXattrs == "" {
+		rawXattrs = d.xattrs
+	}
+
+	xattrs, err := parseXattrs(rawXattrs)
+	if err != nil {
+		return nil, err
+	}
+
+	rawACL := file.ACL
+	if rawACL == "" {
+		rawACL = d.acl
+	}
+
+	acl, defaultACL, err := parseACL(rawACL)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Link != "" && file.Contents != "" {
+		return nil, errors.New("only one of contents and a link target may be set")
+	}
+
+	encoding := file.Encoding
+	if encoding == "" {
+		encoding = d.encoding
+	}
+
+	contents, err := decodeContents(file.Contents, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileWrite{
+		path:      file.Path,
+		contents:  contents,
+		mode:      mode,
+		dirMode:   dirMode,
+		uid:       uid,
+		gid:       gid,
+		writeMode: writeMode,
+		atomic:    d.atomic,
+		user:      user,
+		group:     group,
+		selinux:   selinux,
+		// Appending is never a no-op, so only overwritten and created files can be unchanged
+		skipUnchanged: d.skipUnchanged && writeMode != writeModeAppend && file.Link == "",
+		xattrs:        xattrs,
+		acl:           acl,
+		defaultACL:    defaultACL,
+		// A create-only write never replaces an existing file, so there is nothing to back up
+		backup:     d.backup && writeMode != writeModeCreateOnly,
+		linkTarget: file.Link,
+		linkForce:  d.linkForce,
+		dirSpecs:   d.dirSpecs,
+	}, nil
+}
+
+// parseMode parses value as an octal mode, falling back to fallback when value is empty.
+func parseMode(value, fallback string) (os.FileMode, error) {
+	if value == "" {
+		value = fallback
+	}
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// parseNamedOwner resolves an owner that may be given either as an id or as a name. A file's own id
+// or name wins over the defaults, and namedOwner is returned along with the name when the owner has
+// to be looked up on the destination filesystem.
+func (d fileDefaults) parseNamedOwner(value *int, name, fallback, fallbackName string) (int, string, error) {
+	if value != nil && name != "" {
+		return 0, "", errors.New("only one of an id or a name may be set")
+	}
+
+	if value == nil && name == "" {
+		name = fallbackName
+	}
+
+	if name != "" {
+		return namedOwner, name, nil
+	}
+
+	id, err := d.parseOwner(value, fallback)
+
+	return id, "", err
+}
+
+// parseOwner returns value when it is set, otherwise it parses fallback. An explicit uid or gid
+// always wins over the ownership of the parent directory.
+func (d fileDefaults) parseOwner(value *int, fallback string) (int, error) {
+	if value != nil {
+		return *value, nil
+	}
+
+	if fallback == "" && d.inheritOwner {
+		return inheritOwner, nil
+	}
+
+	return strconv.Atoi(fallback)
+}
+
+// writeFile writes file under rootPath, creating any missing parent directories. When parentDirMode
+// is set it is applied to the directory containing the file once it has been written.
+func writeFile(rootPath string, file *fileWrite, parentDirMode *os.FileMode) error {
+	dirPath := filepath.Dir(file.path)
+
+	if file.remove {
+		return removePath(rootPath, file.path)
+	}
+
+	fqFilePath, err := securePath(rootPath, file.path)
+	if err != nil {
+		return err
+	}
+
+	if err := lookupOwners(rootPath, file); err != nil {
+		return err
+	}
+
+	if err := recursiveEnsureDir(rootPath, dirPath, file.dirMode, file.uid, file.gid, file.dirSpecs); err != nil {
+		return fmt.Errorf("failed to ensure directory exists: %w", err)
+	}
+
+	if file.uid == inheritOwner || file.gid == inheritOwner {
+		parentUID, parentGID, err := ownerOf(filepath.Dir(fqFilePath))
+		if err != nil {
+			return fmt.Errorf("could not inherit ownership from parent directory %s: %w", dirPath, err)
+		}
+
+		if file.uid == inheritOwner {
+			file.uid = parentUID
+		}
+
+		if file.gid == inheritOwner {
+			file.gid = parentGID
+		}
+
+		log.Infof("Inherited ownership %d:%d from parent directory %s", file.uid, file.gid, dirPath)
+	}
+
+	unchanged := false
+	if file.skipUnchanged {
+		// Streamed contents have to be read into memory to be compared
+		if err := file.load(); err != nil {
+			return fmt.Errorf("could not read contents of %s: %w", file.path, err)
+		}
+
+		if unchanged, err = isUnchanged(fqFilePath, file); err != nil {
+			return fmt.Errorf("could not compare existing file %s: %w", file.path, err)
+		}
+	}
+
+	if file.backup && !unchanged {
+		backupPath, err := backupFile(fqFilePath, time.Now())
+		if err != nil {
+			return fmt.Errorf("could not back up existing file %s: %w", file.path, err)
+		}
+
+		if backupPath != "" {
+			log.Infof("Backed up existing file [%s] to [%s]", file.path, strings.TrimPrefix(backupPath, rootPath))
+		}
+	}
+
+	// Write the file to disk
+	if unchanged {
+		log.Infof("File [%s] unchanged, skipping write", file.path)
+	} else if err := writeFileContents(fqFilePath, file); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("file %s already exists and write mode is %s", file.path, writeModeCreateOnly)
+		}
+
+		return fmt.Errorf("could not write file %s: %w", file.path, err)
+	}
+
+	if parentDirMode != nil {
+		// This is applied once the file exists, independently of the DIRMODE used to create directories
+		if err := os.Chmod(filepath.Dir(fqFilePath), *parentDirMode); err != nil {
+			return fmt.Errorf("could not modify mode of parent directory %s: %w", dirPath, err)
+		}
+
+		log.Infof("Successfully set mode of parent directory %s to %04o", dirPath, *parentDirMode)
+	}
+
+	// Only a directory can have a default ACL, so it is set on the directory the file is written to
+	if err := setACL(filepath.Dir(fqFilePath), aclDefaultXattr, file.defaultACL); err != nil {
+		return fmt.Errorf("could not set default ACL of parent directory %s: %w", dirPath, err)
+	}
+
+	if !unchanged {
+		log.Infof("Successfully wrote file [%s]", file.path)
+	}
+
+	return nil
+}
+
+// removePath deletes the file or directory tree at path under rootPath, a path that doesn't exist is
+// already deleted.
+func removePath(rootPath, path string) error {
+	fqPath, err := securePath(rootPath, path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(fqPath); err != nil {
+		if os.IsNotExist(err) {
+			log.Infof("Path [%s] does not exist, nothing to delete", path)
+			return nil
+		}
+
+		return err
+	}
+
+	if err := os.RemoveAll(fqPath); err != nil {
+		return fmt.Errorf("could not delete %s: %w", path, err)
+	}
+
+	log.Infof("Successfully deleted [%s]", path)
+
+	return nil
+}
+
+// escapesRoot reports whether the .. elements of path climb above the root directory it is relative
+// to, such as /../etc or /etc/../../boot.
+func escapesRoot(path string) bool {
+	rel := filepath.Clean(strings.TrimLeft(path, string(os.PathSeparator)))
+
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// securePath returns path under rootPath. The path is cleaned as an absolute path before it is joined
+// so that .. can't climb out of rootPath, and a path that would have is rejected rather than written
+// somewhere else.
+func securePath(rootPath, path string) (string, error) {
+	fqPath := filepath.Join(rootPath, filepath.Clean(string(os.PathSeparator)+path))
+
+	rel, err := filepath.Rel(rootPath, fqPath)
+	if escapesRoot(path) || err != nil || escapesRoot(rel) {
+		return "", fmt.Errorf("path %s is outside of %s", path, rootPath)
+	}
+
+	return fqPath, nil
+}
+
+// isUnchanged reports whether fqPath is a regular file that already has the contents, mode and
+// ownership of file. The existing contents are hashed rather than read into memory.
+func isUnchanged(fqPath string, file *fileWrite) (bool, error) {
+	info, err := os.Lstat(fqPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if !info.Mode().IsRegular() || info.Size() != int64(len(file.contents)) || info.Mode().Perm() != file.mode.Perm() {
+		return false, nil
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(stat.Uid) != file.uid || int(stat.Gid) != file.gid {
+		return false, nil
+	}
+
+	existing, err := fileSHA256(fqPath)
+	if err != nil {
+		return false, err
+	}
+
+	return existing == sha256.Sum256(file.contents), nil
+}
+
+// writeFileContents writes the contents of file to fqPath according to its write mode, and sets
+// its ownership. A file with a link target is created as a symlink instead.
+func writeFileContents(fqPath string, file *fileWrite) error {
+	if file.linkTarget != "" {
+		return writeLink(fqPath, file)
+	}
+
+	if file.atomic {
+		return writeAtomic(fqPath, file)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch file.writeMode {
+	case writeModeAppend:
+		flags |= os.O_APPEND
+	case writeModeCreateOnly:
+		flags |= os.O_EXCL
+	default:
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(fqPath, flags, file.mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.writeContents(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chown(fqPath, file.uid, file.gid); err != nil {
+		return err
+	}
+
+	// The mode given to OpenFile is masked by the umask and doesn't apply to an existing file
+	if err := os.Chmod(fqPath, file.mode); err != nil {
+		return err
+	}
+
+	return setAttributes(fqPath, file)
+}
+
+// setAttributes sets the SELinux context, extended attributes and ACL of file on fqPath. This has to
+// happen after the chown, which clears file capabilities.
+func setAttributes(fqPath string, file *fileWrite) error {
+	if err := setSELinuxContext(fqPath, file.selinux); err != nil {
+		return err
+	}
+
+	if err := setXattrs(fqPath, file.xattrs); err != nil {
+		return err
+	}
+
+	return setACL(fqPath, aclAccessXattr, file.acl)
+}
+
+// setSELinuxContext labels fqPath with context, an empty context leaves the label as it is.
+func setSELinuxContext(fqPath, context string) error {
+	if context == "" {
+		return nil
+	}
+
+	// The kernel stores and returns contexts with a trailing null byte, as setfilecon(3) does
+	if err := syscall.Setxattr(fqPath, selinuxXattr, append([]byte(context), 0), 0); err != nil {
+		return fmt.Errorf("could not set SELinux context %s: %w", context, err)
+	}
+
+	return nil
+}
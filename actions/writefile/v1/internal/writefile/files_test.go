@@ -0,0 +1,229 @@
+package writefile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseFilesManifest(t *testing.T) {
+	uid := 1000
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    *filesManifest
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			raw: `files:
+- path: /etc/hostname
+  contents: |
+    node-1
+  mode: "0644"
+  uid: 1000
+  group: wheel
+- path: /etc/motd
+  delete: true
+`,
+			want: &filesManifest{Files: []manifestFile{
+				{Path: "/etc/hostname", Contents: "node-1\n", Mode: "0644", UID: &uid, Group: "wheel"},
+				{Path: "/etc/motd", Delete: true},
+			}},
+		},
+		{
+			name: "json",
+			raw:  `{"files": [{"path": "/etc/hostname", "contents": "node-1", "uid": 1000, "writemode": "append"}, {"path": "/etc/localtime", "link": "/usr/share/zoneinfo/UTC"}]}`,
+			want: &filesManifest{Files: []manifestFile{
+				{Path: "/etc/hostname", Contents: "node-1", UID: &uid, WriteMode: "append"},
+				{Path: "/etc/localtime", Link: "/usr/share/zoneinfo/UTC"},
+			}},
+		},
+		{name: "no files", raw: "files: []", wantErr: true},
+		{name: "empty", raw: "", wantErr: true},
+		{name: "invalid yaml", raw: "files: [", wantErr: true},
+		{name: "files is not a list", raw: "files: /etc/hostname", wantErr: true},
+		{name: "uid is not a number", raw: "files:\n- path: /etc/hostname\n  uid: root", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFilesManifest([]byte(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFilesManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFilesManifest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	uid, gid := 1000, 1000
+
+	defaults := fileDefaults{mode: "0600", dirMode: "0755", uid: "0", gid: "0", backup: true, skipUnchanged: true}
+
+	tests := []struct {
+		name     string
+		defaults fileDefaults
+		file     manifestFile
+		want     *fileWrite
+		wantErr  bool
+	}{
+		{
+			name:     "defaults",
+			defaults: defaults,
+			file:     manifestFile{Path: "/etc/hostname", Contents: "node-1"},
+			want: &fileWrite{
+				path: "/etc/hostname", contents: []byte("node-1"), mode: 0o600, dirMode: 0o755,
+				writeMode: writeModeOverwrite, xattrs: map[string][]byte{}, skipUnchanged: true, backup: true,
+			},
+		},
+		{
+			name:     "file metadata wins over the defaults",
+			defaults: defaults,
+			file:     manifestFile{Path: "/home/alice/.profile", Mode: "0644", DirMode: "0700", UID: &uid, GID: &gid, WriteMode: writeModeAppend},
+			want: &fileWrite{
+				path: "/home/alice/.profile", contents: []byte{}, mode: 0o644, dirMode: 0o700, uid: 1000, gid: 1000,
+				writeMode: writeModeAppend, xattrs: map[string][]byte{}, backup: true,
+			},
+		},
+		{
+			name:     "names are looked up later",
+			defaults: fileDefaults{mode: "0600", dirMode: "0755", user: "alice", group: "alice"},
+			file:     manifestFile{Path: "/home/alice/.profile", Group: "wheel"},
+			want: &fileWrite{
+				path: "/home/alice/.profile", contents: []byte{}, mode: 0o600, dirMode: 0o755, uid: namedOwner, gid: namedOwner,
+				user: "alice", group: "wheel", writeMode: writeModeOverwrite, xattrs: map[string][]byte{},
+			},
+		},
+		{
+			name:     "inherited owner",
+			defaults: fileDefaults{mode: "0600", dirMode: "0755", inheritOwner: true},
+			file:     manifestFile{Path: "/srv/index.html", GID: &gid},
+			want: &fileWrite{
+				path: "/srv/index.html", contents: []byte{}, mode: 0o600, dirMode: 0o755, uid: inheritOwner, gid: 1000,
+				writeMode: writeModeOverwrite, xattrs: map[string][]byte{},
+			},
+		},
+		{
+			name:     "create only is never backed up",
+			defaults: defaults,
+			file:     manifestFile{Path: "/etc/machine-id", WriteMode: writeModeCreateOnly},
+			want: &fileWrite{
+				path: "/etc/machine-id", contents: []byte{}, mode: 0o600, dirMode: 0o755,
+				writeMode: writeModeCreateOnly, xattrs: map[string][]byte{}, skipUnchanged: true,
+			},
+		},
+		{
+			name:     "link",
+			defaults: defaults,
+			file:     manifestFile{Path: "/etc/localtime", Link: "/usr/share/zoneinfo/UTC"},
+			want: &fileWrite{
+				path: "/etc/localtime", contents: []byte{}, mode: 0o600, dirMode: 0o755,
+				writeMode: writeModeOverwrite, xattrs: map[string][]byte{}, backup: true, linkTarget: "/usr/share/zoneinfo/UTC",
+			},
+		},
+		{
+			name:     "delete",
+			defaults: defaults,
+			file:     manifestFile{Path: "/etc/motd", Delete: true, Mode: "not a mode"},
+			want:     &fileWrite{path: "/etc/motd", remove: true},
+		},
+		{name: "relative path", defaults: defaults, file: manifestFile{Path: "etc/hostname"}, wantErr: true},
+		{name: "directory path", defaults: defaults, file: manifestFile{Path: "/etc/"}, wantErr: true},
+		{name: "invalid mode", defaults: defaults, file: manifestFile{Path: "/etc/hostname", Mode: "rw-r--r--"}, wantErr: true},
+		{name: "uid and user", defaults: defaults, file: manifestFile{Path: "/etc/hostname", UID: &uid, User: "alice"}, wantErr: true},
+		{name: "unknown write mode", defaults: defaults, file: manifestFile{Path: "/etc/hostname", WriteMode: "truncate"}, wantErr: true},
+		{name: "invalid selinux context", defaults: defaults, file: manifestFile{Path: "/etc/hostname", SELinux: "etc_t"}, wantErr: true},
+		{name: "contents and link", defaults: defaults, file: manifestFile{Path: "/etc/hostname", Contents: "a", Link: "/b"}, wantErr: true},
+		{name: "deleted with contents", defaults: defaults, file: manifestFile{Path: "/etc/hostname", Contents: "a", Delete: true}, wantErr: true},
+		{name: "delete the root", defaults: defaults, file: manifestFile{Path: "/etc/..", Delete: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.defaults.resolve(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecurePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"file", "/etc/hostname", "/mountAction/etc/hostname", false},
+		{"relative", "etc/hostname", "/mountAction/etc/hostname", false},
+		{"dot dot within the root", "/etc/../boot/grub.cfg", "/mountAction/boot/grub.cfg", false},
+		{"root", "/", "/mountAction", false},
+		{"dot dot above the root", "/../etc/shadow", "", true},
+		{"dot dot only", "..", "", true},
+		{"dot dot after a directory", "/etc/../../boot", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := securePath("/mountAction", tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("securePath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("securePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveRejectsDotDot(t *testing.T) {
+	for _, path := range []string{"/../etc/passwd", "/etc/../../etc/passwd"} {
+		for _, remove := range []bool{false, true} {
+			if _, err := (fileDefaults{}).resolve(manifestFile{Path: path, Delete: remove}); err == nil {
+				t.Errorf("resolve() of %s (delete %t) succeeded, want an error", path, remove)
+			}
+		}
+	}
+}
+
+func TestRemovePathOutsideRoot(t *testing.T) {
+	dir := t.TempDir()
+	rootPath := filepath.Join(dir, "mountAction")
+	outside := filepath.Join(dir, "etc")
+
+	for _, path := range []string{rootPath, outside} {
+		if err := os.Mkdir(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(outside, "passwd"), []byte("root:x:0:0::/root:/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removePath(rootPath, "/../etc"); err == nil {
+		t.Error("removePath() of /../etc succeeded, want an error")
+	}
+
+	if err := writeFile(rootPath, &fileWrite{path: "/../etc/passwd", contents: []byte("x")}, nil); err == nil {
+		t.Error("writeFile() of /../etc/passwd succeeded, want an error")
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "passwd")); err != nil {
+		t.Errorf("file outside of the root was touched: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package writefile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// writeLink creates a symlink to the link target of file at fqPath and gives the symlink its
+// ownership. An existing symlink to the same target is kept, anything else in the way is only
+// replaced when the file is forced, and then atomically so that the path never goes missing.
+func writeLink(fqPath string, file *fileWrite) error {
+	info, err := os.Lstat(fqPath)
+
+	switch {
+	case os.IsNotExist(err):
+		if err := os.Symlink(file.linkTarget, fqPath); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case info.IsDir():
+		return errors.New("a directory is in the way of the symlink")
+	case isLinkTo(fqPath, file.linkTarget):
+		log.Infof("Symlink [%s] already points to [%s]", file.path, file.linkTarget)
+	case !file.linkForce:
+		return errors.New("a file is in the way of the symlink, set [LINK_FORCE] to replace it")
+	default:
+		dir, name := filepath.Split(fqPath)
+		tmpPath := filepath.Join(dir, "."+name+".link-tmp")
+
+		// A leftover from an earlier run that was killed half way would make the symlink fail
+		if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := os.Symlink(file.linkTarget, tmpPath); err != nil {
+			return err
+		}
+
+		if err := os.Rename(tmpPath, fqPath); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	return os.Lchown(fqPath, file.uid, file.gid)
+}
+
+// isLinkTo reports whether fqPath is a symlink to target.
+func isLinkTo(fqPath, target string) bool {
+	existing, err := os.Readlink(fqPath)
+
+	return err == nil && existing == target
+}
@@ -0,0 +1,71 @@
+package writefile
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The formats the action can log in.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// stepFields are added to every log entry that doesn't set them itself, they identify the action and
+// the device and file that it is working on.
+var stepFields = &fieldsHook{fields: log.Fields{"action": "writefile"}}
+
+// ConfigureLogging sets the level and format of the action's logs, empty values keep the logrus
+// defaults of info and text.
+func ConfigureLogging(level, format string) error {
+	if level != "" {
+		parsed, err := log.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+
+		log.SetLevel(parsed)
+	}
+
+	switch format {
+	case "", LogFormatText:
+	case LogFormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %s, must be one of [%s, %s]", format, LogFormatText, LogFormatJSON)
+	}
+
+	log.AddHook(stepFields)
+
+	return nil
+}
+
+// fieldsHook is a logrus hook that adds fields to every entry.
+type fieldsHook struct {
+	fields log.Fields
+}
+
+// set adds key to the fields of every following entry, an empty value removes it.
+func (h *fieldsHook) set(key, value string) {
+	if value == "" {
+		delete(h.fields, key)
+		return
+	}
+
+	h.fields[key] = value
+}
+
+func (h *fieldsHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *fieldsHook) Fire(entry *log.Entry) error {
+	for key, value := range h.fields {
+		if _, exists := entry.Data[key]; !exists {
+			entry.Data[key] = value
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package writefile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// cryptsetupBinary is the static cryptsetup that is shipped in the action's image.
+const cryptsetupBinary = "/sbin/cryptsetup"
+
+// luksMagic starts the header of both LUKS1 and LUKS2 devices.
+var luksMagic = []byte{'L', 'U', 'K', 'S', 0xba, 0xbe}
+
+// isLUKS reports whether the device at path starts with a LUKS header.
+func isLUKS(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := readAt(f, 0, len(luksMagic))
+
+	return bytes.Equal(header, luksMagic), nil
+}
+
+// luksMappingName is the device mapper name that the LUKS device at path is opened as.
+func luksMappingName(path string) string {
+	return "writefile-" + filepath.Base(path)
+}
+
+// openLUKS unlocks the LUKS device at path with key and returns the device node of the decrypted
+// mapping. The key is passed on stdin so that it never shows up in the process list.
+func openLUKS(path, name string, key []byte) (string, error) {
+	cmd := exec.Command(cryptsetupBinary, "open", "--type", "luks", "--key-file", "-", path, name)
+	cmd.Stdin = bytes.NewReader(key)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("command [%s open %s %s] error [%w]", cryptsetupBinary, path, name, err)
+	}
+
+	return filepath.Join("/dev/mapper", name), nil
+}
+
+// closeLUKS removes the decrypted mapping name, anything mounted from it must be unmounted first.
+func closeLUKS(name string) error {
+	cmd := exec.Command(cryptsetupBinary, "close", name)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("command [%s close %s] error [%w]", cryptsetupBinary, name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package writefile
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// lvmBinary is the static lvm that is shipped in the action's image.
+const lvmBinary = "/sbin/lvm"
+
+// volumeGroupOf returns the volume group of a logical volume path such as /dev/vg0/root or
+// /dev/mapper/vg0-root, and false if path doesn't look like a logical volume.
+func volumeGroupOf(path string) (string, bool) {
+	dir, name := filepath.Split(filepath.Clean(path))
+	parent := filepath.Base(dir)
+
+	if filepath.Dir(filepath.Clean(dir)) != "/dev" || name == "" {
+		return "", false
+	}
+
+	if parent != "mapper" {
+		return parent, true
+	}
+
+	// Device mapper names join the volume group and logical volume with a single dash, dashes within
+	// either name are doubled
+	escaped := strings.ReplaceAll(name, "--", "\x00")
+
+	i := strings.Index(escaped, "-")
+	if i <= 0 {
+		return "", false
+	}
+
+	return strings.ReplaceAll(escaped[:i], "\x00", "-"), true
+}
+
+// activateVolumeGroup activates every logical volume of vg, and creates their device nodes as
+// there is no udev running inside the action's container.
+func activateVolumeGroup(vg string) error {
+	if err := runLVM("vgchange", "--activate", "y", vg); err != nil {
+		return err
+	}
+
+	return runLVM("vgmknodes", vg)
+}
+
+// deactivateVolumeGroup deactivates every logical volume of vg, anything mounted from them must be
+// unmounted first.
+func deactivateVolumeGroup(vg string) error {
+	return runLVM("vgchange", "--activate", "n", vg)
+}
+
+func runLVM(args ...string) error {
+	cmd := exec.Command(lvmBinary, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("command [%s %s] error [%w]", lvmBinary, strings.Join(args, " "), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package writefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// fileMetadata is the metadata recorded for a single path in a metadata manifest, any field that
+// isn't set is left as it is on disk.
+type fileMetadata struct {
+	Mode   string            `json:"mode,omitempty"`
+	UID    *int              `json:"uid,omitempty"`
+	GID    *int              `json:"gid,omitempty"`
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+
+	mode os.FileMode
+}
+
+// metadataManifest maps paths relative to DEST_ROOT to the metadata they should have.
+type metadataManifest map[string]*fileMetadata
+
+// parseMetadataManifest parses and validates a JSON metadata manifest.
+func parseMetadataManifest(raw string) (metadataManifest, error) {
+	manifest := metadataManifest{}
+	if err := json.Unmarshal([]byte(raw), &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	for path, entry := range manifest {
+		if entry == nil {
+			return nil, fmt.Errorf("entry for %s is empty", path)
+		}
+
+		if filepath.IsAbs(path) || filepath.Clean(path) != path || escapesRoot(path) {
+			return nil, fmt.Errorf("entry %s must be a clean path relative to DEST_ROOT", path)
+		}
+
+		if entry.Mode != "" {
+			mode, err := strconv.ParseUint(entry.Mode, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("entry %s has an invalid mode %s: %w", path, entry.Mode, err)
+			}
+
+			entry.mode = os.FileMode(mode)
+		}
+
+		if (entry.UID == nil) != (entry.GID == nil) {
+			return nil, fmt.Errorf("entry %s must set both uid and gid or neither", path)
+		}
+
+		for name := range entry.Xattrs {
+			if !strings.Contains(name, ".") {
+				return nil, fmt.Errorf("entry %s has xattr %s without a namespace prefix", path, name)
+			}
+		}
+	}
+
+	return manifest, nil
+}
+
+// apply sets the recorded metadata on every path of the manifest that exists under root on the
+// filesystem mounted at mountPath, and returns the paths that could not be found.
+func (m metadataManifest) apply(mountPath, root string) ([]string, error) {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	var unmatched []string
+
+	for _, path := range paths {
+		fqPath, err := securePath(mountPath, filepath.Join(root, path))
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Lstat(fqPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				unmatched = append(unmatched, path)
+				continue
+			}
+
+			return nil, fmt.Errorf("failed to stat path %s: %w", path, err)
+		}
+
+		// The path itself may be a symlink, which is never followed, but a symlinked directory on the
+		// way to it could lead out of the mounted filesystem
+		if err := withinMount(mountPath, filepath.Dir(fqPath)); err != nil {
+			return nil, fmt.Errorf("failed to apply metadata to %s: %w", path, err)
+		}
+
+		if err := m[path].apply(fqPath, info.Mode()&os.ModeSymlink != 0); err != nil {
+			return nil, fmt.Errorf("failed to apply metadata to %s: %w", path, err)
+		}
+
+		log.Infof("Successfully applied metadata to %s", filepath.Join(root, path))
+	}
+
+	return unmatched, nil
+}
+
+// apply sets the metadata on fqPath without following it when it is a symlink, whose mode Linux
+// ignores and so is left as it is.
+func (f *fileMetadata) apply(fqPath string, symlink bool) error {
+	if f.UID != nil {
+		if err := os.Lchown(fqPath, *f.UID, *f.GID); err != nil {
+			return err
+		}
+	}
+
+	// chown may clear the setuid and setgid bits so the mode is always applied after it
+	if f.Mode != "" && !symlink {
+		if err := os.Chmod(fqPath, f.mode); err != nil {
+			return err
+		}
+	}
+
+	for name, value := range f.Xattrs {
+		if err := unix.Lsetxattr(fqPath, name, []byte(value), 0); err != nil {
+			return fmt.Errorf("failed to set xattr %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// withinMount returns an error unless dir, once its symlinks are resolved, is still under mountPath.
+// An absolute symlink in the image points into the host's filesystem here and is refused.
+func withinMount(mountPath, dir string) error {
+	resolvedMount, err := filepath.EvalSymlinks(mountPath)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	if rel, err := filepath.Rel(resolvedMount, resolved); err != nil || escapesRoot(rel) {
+		return fmt.Errorf("%s leads out of %s through a symlink", dir, mountPath)
+	}
+
+	return nil
+}
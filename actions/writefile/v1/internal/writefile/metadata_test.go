@@ -0,0 +1,103 @@
+package writefile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMetadataManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{name: "relative path", raw: `{"etc/hostname": {"mode": "0644"}}`},
+		{name: "name starting with dots", raw: `{"..foo": {"mode": "0644"}, "etc/..bar": {"uid": 0, "gid": 0}}`},
+		{name: "dot dot", raw: `{"../etc/shadow": {"mode": "0644"}}`, wantErr: true},
+		{name: "only dot dot", raw: `{"..": {"mode": "0644"}}`, wantErr: true},
+		{name: "absolute path", raw: `{"/etc/hostname": {"mode": "0644"}}`, wantErr: true},
+		{name: "uncleaned path", raw: `{"etc/../etc/hostname": {"mode": "0644"}}`, wantErr: true},
+		{name: "invalid mode", raw: `{"etc/hostname": {"mode": "rw"}}`, wantErr: true},
+		{name: "uid without gid", raw: `{"etc/hostname": {"uid": 0}}`, wantErr: true},
+		{name: "xattr without a namespace", raw: `{"etc/hostname": {"xattrs": {"role": "a"}}}`, wantErr: true},
+		{name: "empty entry", raw: `{"etc/hostname": null}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseMetadataManifest(tt.raw); (err != nil) != tt.wantErr {
+				t.Errorf("parseMetadataManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetadataApplySymlinks(t *testing.T) {
+	dir := t.TempDir()
+	mountPath := filepath.Join(dir, "mountAction")
+	outside := filepath.Join(dir, "outside")
+
+	for _, path := range []string{filepath.Join(mountPath, "etc"), outside} {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{filepath.Join(mountPath, "etc", "hostname"), filepath.Join(outside, "shadow")} {
+		if err := ioutil.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The links point out of the mounted filesystem, as an absolute symlink in an image does
+	if err := os.Symlink(filepath.Join(outside, "shadow"), filepath.Join(mountPath, "etc", "shadow")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(mountPath, "var")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "file", path: "etc/hostname"},
+		{name: "symlink to a file outside", path: "etc/shadow"},
+		{name: "through a symlinked directory", path: "var/shadow", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, err := parseMetadataManifest(`{"` + tt.path + `": {"mode": "0644"}}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := manifest.apply(mountPath, "/"); (err != nil) != tt.wantErr {
+				t.Fatalf("apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			info, err := os.Stat(filepath.Join(outside, "shadow"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Mode().Perm() != 0o600 {
+				t.Errorf("apply() changed the mode of a file outside of the mount to %04o", info.Mode().Perm())
+			}
+		})
+	}
+
+	info, err := os.Stat(filepath.Join(mountPath, "etc", "hostname"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("apply() set the mode of etc/hostname to %04o, want 0644", info.Mode().Perm())
+	}
+}
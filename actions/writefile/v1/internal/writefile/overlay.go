@@ -0,0 +1,52 @@
+package writefile
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/mount"
+)
+
+// mountOverlay mounts an overlay filesystem on target. The lower, upper and work directories are
+// paths on the filesystem mounted at mountPath and must all exist before the overlay is mounted.
+func mountOverlay(mounter mount.Mounter, mountPath, target, lower, upper, work string) error {
+	dirs := []struct {
+		option string
+		path   string
+	}{
+		{"lowerdir", lower},
+		{"upperdir", upper},
+		{"workdir", work},
+	}
+
+	options := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir.path) {
+			return fmt.Errorf("%s %s must be an absolute path", dir.option, dir.path)
+		}
+
+		// The mount data is a comma separated list of options so the paths can't contain one
+		if strings.Contains(dir.path, ",") {
+			return fmt.Errorf("%s %s must not contain a comma", dir.option, dir.path)
+		}
+
+		exists, err := dirExists(mountPath, dir.path)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return fmt.Errorf("%s %s does not exist", dir.option, dir.path)
+		}
+
+		fqPath, err := securePath(mountPath, dir.path)
+		if err != nil {
+			return err
+		}
+
+		options = append(options, fmt.Sprintf("%s=%s", dir.option, fqPath))
+	}
+
+	return mounter.Mount("overlay", target, "overlay", 0, strings.Join(options, ","))
+}
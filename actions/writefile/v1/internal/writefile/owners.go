@@ -0,0 +1,82 @@
+package writefile
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// namedOwner is used in place of a uid or gid that is looked up by name on the destination filesystem.
+const namedOwner = -2
+
+// The databases that user and group names are looked up in, relative to the destination filesystem.
+const (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+// lookupOwners resolves the user and group names of file and of its ACL entries to the ids they have in
+// the passwd and group databases under rootPath, as the numeric ids of a name can differ between images.
+func lookupOwners(rootPath string, file *fileWrite) error {
+	if file.uid == namedOwner {
+		uid, err := lookupID(filepath.Join(rootPath, passwdFile), file.user)
+		if err != nil {
+			return fmt.Errorf("could not look up user %s: %w", file.user, err)
+		}
+
+		log.Infof("Resolved user %s to uid %d", file.user, uid)
+		file.uid = uid
+	}
+
+	if file.gid == namedOwner {
+		gid, err := lookupID(filepath.Join(rootPath, groupFile), file.group)
+		if err != nil {
+			return fmt.Errorf("could not look up group %s: %w", file.group, err)
+		}
+
+		log.Infof("Resolved group %s to gid %d", file.group, gid)
+		file.gid = gid
+	}
+
+	if err := file.acl.resolve(rootPath); err != nil {
+		return err
+	}
+
+	return file.defaultACL.resolve(rootPath)
+}
+
+// lookupID returns the id of name in a passwd or group formatted database, both of which keep the
+// id in the third field.
+func lookupID(database, name string) (int, error) {
+	f, err := os.Open(database)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid id for %s in %s: %w", name, database, err)
+		}
+
+		return id, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("%s not found in %s", name, database)
+}
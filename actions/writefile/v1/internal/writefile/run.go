@@ -0,0 +1,699 @@
+// Package writefile writes the files of the action to the filesystem of the destination device.
+package writefile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/config"
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/fetch"
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/hegel"
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/mount"
+)
+
+// The mountpoints of the destination and of the overlay on top of it, they are variables so that
+// tests can mount somewhere else.
+var (
+	mountAction   = "/mountAction"
+	overlayAction = "/overlayAction"
+)
+
+// Run writes the files that cfg describes, it returns an error carrying the exit code of its class.
+// Every mountpoint is unmounted and every device that was set up is released again before it
+// returns, whether it succeeds or not.
+func Run(start time.Time, cfg *config.Config, mounter mount.Mounter) error {
+	blockDevice := cfg.DestDisk
+	filesystemType := cfg.FSType
+	filePath := cfg.DestPath
+	contents := cfg.Contents
+	// A description of where the contents came from, logged for auditing
+	contentSource := "environment variable [CONTENTS]"
+	mountOpts := mount.ParseOptions(cfg.MountOptions)
+
+	var err error
+
+	if err := checkAbort(cfg.AbortFlagFile); err != nil {
+		return err
+	}
+
+	if device, err := resolveDevice(blockDevice); err != nil {
+		return deviceFailed("could not resolve block device [%s]: %v", blockDevice, err)
+	} else if device != blockDevice {
+		log.Infof("Resolved [%s] -> [%s]", blockDevice, device)
+		blockDevice = device
+	}
+
+	stepFields.set("device", blockDevice)
+
+	// The mountpoints mounted so far, they are unmounted before the action exits
+	var mounted []string
+	// cleanups release the devices that were set up for the destination in reverse order, once
+	// everything has been unmounted
+	var cleanups []func()
+
+	defer func() {
+		mount.UnmountAll(mounter, mounted)
+
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}()
+
+	// A logical volume has no device node until its volume group is activated
+	if _, err := os.Stat(blockDevice); os.IsNotExist(err) {
+		if vg, ok := volumeGroupOf(blockDevice); ok && cfg.DryRun {
+			log.Infof("Dry run: would activate volume group [%s]", vg)
+		} else if ok {
+			if err := activateVolumeGroup(vg); err != nil {
+				return deviceFailed("could not activate volume group [%s] of [%s]: %v", vg, blockDevice, err)
+			}
+
+			log.Infof("Activated volume group [%s]", vg)
+
+			cleanups = append(cleanups, func() {
+				if err := deactivateVolumeGroup(vg); err != nil {
+					log.Errorf("Could not deactivate volume group [%s]: %v", vg, err)
+					return
+				}
+
+				log.Infof("Deactivated volume group [%s]", vg)
+			})
+		}
+	}
+
+	// A device that doesn't exist is reported when it is mounted
+	encrypted, err := isLUKS(blockDevice)
+	if err != nil && !os.IsNotExist(err) {
+		return deviceFailed("could not read block device [%s]: %v", blockDevice, err)
+	}
+
+	var luksKey []byte
+
+	if encrypted {
+		switch {
+		case cfg.LUKSPassphrase != "" && cfg.LUKSKeyfileContents != "":
+			return invalidInput("only one of [LUKS_PASSPHRASE] and [LUKS_KEYFILE_CONTENTS] may be set")
+		case cfg.LUKSPassphrase != "":
+			luksKey = []byte(cfg.LUKSPassphrase)
+		case cfg.LUKSKeyfileContents != "":
+			luksKey, err = decodeBase64(cfg.LUKSKeyfileContents)
+			if err != nil {
+				return invalidInput("could not decode [LUKS_KEYFILE_CONTENTS]: %v", err)
+			}
+		default:
+			return invalidInput("block device [%s] is LUKS encrypted, set [LUKS_PASSPHRASE] or [LUKS_KEYFILE_CONTENTS] to unlock it", blockDevice)
+		}
+	}
+
+	var parentDirMode *os.FileMode
+	if cfg.ParentDirMode != "" {
+		parsed, err := parseMode(cfg.ParentDirMode, "")
+		if err != nil {
+			return invalidInput("could not parse parent dir mode: %v", err)
+		}
+
+		parentDirMode = &parsed
+	}
+
+	specs, err := parseDirSpecs(cfg.DirSpec)
+	if err != nil {
+		return invalidInput("could not parse [DIRSPEC]: %v", err)
+	}
+
+	defaults := fileDefaults{
+		mode:          cfg.Mode,
+		dirMode:       cfg.DirMode,
+		uid:           cfg.UID,
+		gid:           cfg.GID,
+		user:          cfg.User,
+		group:         cfg.Group,
+		writeMode:     cfg.WriteMode,
+		encoding:      cfg.ContentsEncoding,
+		selinux:       cfg.SELinuxContext,
+		xattrs:        cfg.Xattrs,
+		acl:           cfg.ACL,
+		dirSpecs:      specs,
+		inheritOwner:  cfg.InheritParentOwner,
+		atomic:        cfg.Atomic,
+		linkForce:     cfg.LinkForce,
+		backup:        cfg.Backup,
+		skipUnchanged: cfg.SkipUnchanged,
+	}
+
+	hegelClient := &hegel.Client{
+		URLs:       cfg.HegelURLs,
+		Retries:    cfg.HegelRetries,
+		Timeout:    cfg.HegelTimeout,
+		Headers:    cfg.HegelHeaders,
+		HTTPClient: http.DefaultClient,
+	}
+
+	if cfg.HegelCACert != "" || cfg.HegelClientCert != "" || cfg.HegelClientKey != "" || cfg.HegelInsecureSkipVerify {
+		tlsConfig, err := newTLSConfig(cfg.HegelCACert, cfg.HegelClientCert, cfg.HegelClientKey, cfg.HegelInsecureSkipVerify)
+		if err != nil {
+			return invalidInput("could not configure TLS for Hegel: %v", err)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		hegelClient.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	if cfg.HegelAuthToken != "" {
+		if hegelClient.Headers == nil {
+			hegelClient.Headers = http.Header{}
+		}
+
+		hegelClient.Headers.Set("Authorization", "Bearer "+cfg.HegelAuthToken)
+	}
+
+	var metadata metadataManifest
+	if cfg.MetadataManifest != "" {
+		metadata, err = parseMetadataManifest(cfg.MetadataManifest)
+		if err != nil {
+			return invalidInput("could not parse metadata manifest: %v", err)
+		}
+	}
+
+	// Contents fetched from a URL are streamed to the destination file rather than held in memory
+	var contentBody io.ReadCloser
+
+	if cfg.CmdlineContentsKey != "" {
+		cmdline, err := ioutil.ReadFile(kernelCmdline)
+		if err != nil {
+			return fmt.Errorf("could not read kernel command line: %w", err)
+		}
+
+		cmdlineURL, found := cmdlineValue(string(cmdline), cfg.CmdlineContentsKey)
+		if !found || cmdlineURL == "" {
+			return invalidInput("kernel command line has no value for [%s]", cfg.CmdlineContentsKey)
+		}
+
+		contentBody, err = openURL(cmdlineURL)
+		if err != nil {
+			return fetchFailed("could not fetch contents from [%s]: %v", fetch.RedactURL(cmdlineURL), err)
+		}
+		defer contentBody.Close()
+
+		contentSource = fmt.Sprintf("kernel command line [%s] URL [%s]", cfg.CmdlineContentsKey, fetch.RedactURL(cmdlineURL))
+	}
+
+	if cfg.ContentsURL != "" {
+		contentBody, err = openURL(cfg.ContentsURL)
+		if err != nil {
+			return fetchFailed("could not fetch contents from [%s]: %v", fetch.RedactURL(cfg.ContentsURL), err)
+		}
+		defer contentBody.Close()
+
+		contentSource = fmt.Sprintf("URL [%s]", fetch.RedactURL(cfg.ContentsURL))
+	}
+
+	// The files to write, either the single DEST_PATH or every file of a manifest
+	var files []*fileWrite
+
+	if cfg.Manifest != "" || cfg.ManifestURL != "" {
+		rawManifest := []byte(cfg.Manifest)
+		contentSource = "environment variable [MANIFEST]"
+
+		if cfg.ManifestURL != "" {
+			rawManifest, err = fetchURL(cfg.ManifestURL)
+			if err != nil {
+				return fetchFailed("could not fetch manifest from [%s]: %v", fetch.RedactURL(cfg.ManifestURL), err)
+			}
+
+			contentSource = fmt.Sprintf("manifest URL [%s]", fetch.RedactURL(cfg.ManifestURL))
+		}
+
+		manifest, err := parseFilesManifest(rawManifest)
+		if err != nil {
+			return invalidInput("could not parse manifest: %v", err)
+		}
+
+		for i, entry := range manifest.Files {
+			file, err := defaults.resolve(entry)
+			if err != nil {
+				return invalidInput("invalid manifest file %d [%s]: %v", i, entry.Path, err)
+			}
+
+			files = append(files, file)
+		}
+	} else {
+		// Without any contents the user-data of the machine, or the Hegel endpoint at HEGEL_PATH, is
+		// written, as long as Hegel is configured
+		hegelPath := cfg.HegelPath
+		if contents == "" && cfg.CmdlineContentsKey == "" && cfg.ContentsURL == "" && cfg.LinkTarget == "" && !cfg.Delete && len(hegelClient.URLs) > 0 {
+			if hegelPath == "" {
+				hegelPath = hegel.UserDataPath
+			} else if !strings.HasPrefix(hegelPath, "/") {
+				hegelPath = "/" + hegelPath
+			}
+
+			if cfg.UserDataSHA256 != "" {
+				// The user-data is verified before anything is written, so it is held in memory
+				userData, err := hegelClient.Fetch(hegelPath)
+				if err != nil {
+					return fetchFailed("could not fetch [%s] from Hegel: %v", hegelPath, err)
+				}
+
+				// Transport errors fail the fetch, so a mismatch is of user-data that doesn't match
+				// USERDATA_SHA256 and won't on a retry either
+				if err := verifySHA256(userData, cfg.UserDataSHA256); err != nil {
+					return invalidInput("refusing to write [%s] that failed verification: %v", hegelPath, err)
+				}
+
+				log.Infof("Verified [%s] against [USERDATA_SHA256]", hegelPath)
+				contents = string(userData)
+			} else {
+				contentBody, err = hegelClient.Open(hegelPath)
+				if err != nil {
+					return fetchFailed("could not fetch [%s] from Hegel: %v", hegelPath, err)
+				}
+				defer contentBody.Close()
+			}
+
+			contentSource = fmt.Sprintf("Hegel [%s]", hegelPath)
+		} else if cfg.UserDataSHA256 != "" || hegelPath != "" {
+			return invalidInput("[USERDATA_SHA256] and [HEGEL_PATH] are only used when the contents are fetched from Hegel, with [CONTENTS] unset")
+		}
+
+		if cfg.LinkTarget != "" && (contents != "" || contentBody != nil) {
+			return invalidInput("[LINK_TARGET] can't be combined with [CONTENTS], [CMDLINE_CONTENTS_KEY] or [CONTENTS_URL]")
+		}
+
+		if cfg.Delete && (contents != "" || contentBody != nil) {
+			return invalidInput("[DELETE] can't be combined with [CONTENTS], [CMDLINE_CONTENTS_KEY] or [CONTENTS_URL]")
+		}
+
+		entry := manifestFile{Path: filePath, Contents: contents, Link: cfg.LinkTarget, Delete: cfg.Delete}
+		if contentBody != nil {
+			// Streamed contents are decoded as they are read
+			entry.Encoding = encodingPlain
+		}
+
+		file, err := defaults.resolve(entry)
+		if err != nil {
+			return invalidInput("invalid file [%s]: %v", filePath, err)
+		}
+
+		if contentBody != nil {
+			if file.body, err = decodeStream(contentBody, defaults.encoding); err != nil {
+				return invalidInput("could not decode contents of [%s]: %v", filePath, err)
+			}
+		}
+
+		files = append(files, file)
+	}
+
+	for _, file := range files {
+		if file.remove {
+			log.Infof("Deleting [%s]", file.path)
+			continue
+		}
+
+		if file.linkTarget != "" {
+			log.Infof("Linking [%s] to [%s]", file.path, file.linkTarget)
+			continue
+		}
+
+		if file.body != nil {
+			log.Infof("Streaming contents for [%s] from %s", file.path, contentSource)
+			continue
+		}
+
+		log.Infof("Using contents for [%s] from %s (%d bytes)", file.path, contentSource, len(file.contents))
+	}
+
+	if cfg.Template {
+		if err := loadContents(files); err != nil {
+			return err
+		}
+
+		metadata, err := hegel.FetchMetadata(hegelClient)
+		if err != nil {
+			return fetchFailed("could not fetch metadata to render templates: %v", err)
+		}
+
+		for _, file := range files {
+			file.contents, err = renderTemplate(file.path, file.contents, metadata)
+			if err != nil {
+				return invalidInput("could not render contents of [%s]: %v", file.path, err)
+			}
+
+			log.Infof("Rendered contents of [%s] from Hegel metadata", file.path)
+		}
+	}
+
+	if cfg.ContentWASM != "" {
+		if err := loadContents(files); err != nil {
+			return err
+		}
+
+		// Transform the contents before anything is mounted so a broken module never touches the disk
+		for _, file := range files {
+			file.contents, err = transformWASM(cfg.ContentWASM, file.contents, cfg.ContentWASMTimeout, uint64(cfg.ContentWASMMemoryMB)*1024*1024)
+			if err != nil {
+				return fmt.Errorf("could not transform contents of [%s] with [%s]: %w", file.path, cfg.ContentWASM, err)
+			}
+
+			log.Infof("Transformed contents of [%s] with [%s]", file.path, cfg.ContentWASM)
+		}
+	}
+
+	if cfg.NullTerminate {
+		if err := loadContents(files); err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			file.contents = append(file.contents, 0)
+		}
+	}
+
+	if cfg.PadToBytes != nil {
+		padToBytes := *cfg.PadToBytes
+
+		if err := loadContents(files); err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			if len(file.contents) > padToBytes {
+				return invalidInput("contents of [%s] are %d bytes which exceeds [PAD_TO_BYTES] of %d bytes", file.path, len(file.contents), padToBytes)
+			}
+
+			file.contents = append(file.contents, make([]byte, padToBytes-len(file.contents))...)
+		}
+	}
+
+	if cfg.DryRun {
+		return printPlan(blockDevice, filesystemType, cfg.MountOptions, encrypted, files)
+	}
+
+	if err := checkAbort(cfg.AbortFlagFile); err != nil {
+		return err
+	}
+
+	// The device that is mounted, this is the decrypted mapping of a LUKS device
+	mountDevice := blockDevice
+
+	if encrypted {
+		luksMapping := luksMappingName(blockDevice)
+
+		mountDevice, err = openLUKS(blockDevice, luksMapping, luksKey)
+		if err != nil {
+			return deviceFailed("could not open LUKS device [%s]: %v", blockDevice, err)
+		}
+
+		log.Infof("Opened LUKS device [%s] -> [%s]", blockDevice, mountDevice)
+
+		// The decrypted mapping would otherwise outlive the action
+		cleanups = append(cleanups, func() {
+			if err := closeLUKS(luksMapping); err != nil {
+				log.Errorf("Could not close LUKS mapping [%s]: %v", luksMapping, err)
+				return
+			}
+
+			log.Infof("Closed LUKS mapping [%s]", luksMapping)
+		})
+	}
+
+	if filesystemType == "" {
+		detected, err := detectFilesystem(mountDevice)
+		if err != nil {
+			return deviceFailed("no filesystem type specified with [FS_TYPE] and it could not be detected on [%s]: %v", mountDevice, err)
+		}
+
+		log.Infof("Detected filesystem type [%s] on [%s]", detected, mountDevice)
+		filesystemType = detected
+	}
+
+	// Create the /mountAction mountpoint (no folders exist previously in scratch container)
+	if err := os.Mkdir(mountAction, 0o755); err != nil {
+		return deviceFailed("error creating the action mountpoint [%s]: %v", mountAction, err)
+	}
+
+	// The mountpoint is left behind in the host's filesystem when running with HOST_DEV
+	cleanups = append(cleanups, func() { os.Remove(mountAction) })
+
+	// Mount the block device to the /mountAction point
+	if err := mount.ReadWrite(mounter, mountDevice, mountAction, mount.FSType(filesystemType), mountOpts); err != nil {
+		return deviceFailed("mounting [%s] -> [%s] with options [%s] error [%v]", mountDevice, mountAction, cfg.MountOptions, err)
+	}
+
+	log.Infof("Mounted [%s] -> [%s]", mountDevice, mountAction)
+	if mountOpts.ReadOnly() {
+		log.Infof("Remounted [%s] read-write", mountAction)
+	}
+
+	mounted = append(mounted, mountAction)
+
+	// The root that the file is written under, this is the overlay when one is requested so that
+	// writes land in the upper directory and the lower directory is left untouched.
+	rootPath := mountAction
+
+	if cfg.MountType == "overlay" {
+		if err := os.Mkdir(overlayAction, 0o755); err != nil {
+			return deviceFailed("error creating the overlay mountpoint [%s]: %v", overlayAction, err)
+		}
+
+		cleanups = append(cleanups, func() { os.Remove(overlayAction) })
+
+		if err := mountOverlay(mounter, mountAction, overlayAction, cfg.OverlayLower, cfg.OverlayUpper, cfg.OverlayWork); err != nil {
+			return deviceFailed("mounting overlay -> [%s] error [%v]", overlayAction, err)
+		}
+
+		log.Infof("Mounted overlay [%s] (upper [%s]) -> [%s]", cfg.OverlayLower, cfg.OverlayUpper, overlayAction)
+		mounted = append(mounted, overlayAction)
+		rootPath = overlayAction
+	}
+
+	for _, file := range files {
+		if err := checkAbort(cfg.AbortFlagFile); err != nil {
+			return err
+		}
+
+		stepFields.set("path", file.path)
+
+		if err := writeFile(rootPath, file, parentDirMode); err != nil {
+			// The source of streamed contents failing mid-way is a failed fetch rather than a failed write
+			var readErr *fetch.Error
+			if errors.As(err, &readErr) {
+				return fetchFailed("failed to fetch contents of [%s]: %v", file.path, err)
+			}
+
+			return deviceFailed("failed to write file [%s]: %v", file.path, err)
+		}
+	}
+
+	stepFields.set("path", "")
+
+	if metadata != nil {
+		// Paths in the manifest, including the files just written, override the global defaults
+		unmatched, err := metadata.apply(rootPath, cfg.DestRoot)
+		if err != nil {
+			return deviceFailed("could not apply metadata manifest: %v", err)
+		}
+
+		for _, path := range unmatched {
+			log.Warnf("Metadata manifest entry [%s] did not match any path under [%s]", path, cfg.DestRoot)
+		}
+	}
+
+	for _, file := range files {
+		// Deleted paths are gone and the mode of a symlink means nothing
+		if file.linkTarget != "" || file.remove {
+			continue
+		}
+
+		wantMode, wantUID, wantGID := file.mode, file.uid, file.gid
+
+		// An access ACL replaces the permission bits of the mode
+		if len(file.acl) != 0 {
+			wantMode = file.mode&^os.ModePerm | file.acl.permissions()
+		}
+
+		// A metadata manifest entry for the file takes precedence over its own metadata
+		if rel, err := filepath.Rel(cfg.DestRoot, file.path); err == nil && metadata[rel] != nil {
+			entry := metadata[rel]
+			if entry.Mode != "" {
+				wantMode = entry.mode
+			}
+
+			if entry.UID != nil {
+				wantUID, wantGID = *entry.UID, *entry.GID
+			}
+		}
+
+		fqFilePath, err := securePath(rootPath, file.path)
+		if err != nil {
+			return deviceFailed("could not check metadata of file %s: %v", file.path, err)
+		}
+
+		if cfg.VerifyMetadata {
+			if err := verifyMetadata(fqFilePath, wantMode, wantUID, wantGID); err != nil {
+				return deviceFailed("could not verify metadata of file %s: %v", file.path, err)
+			}
+
+			continue
+		}
+
+		// Filesystems such as vfat accept chmod and chown without applying them
+		drift, err := metadataDrift(fqFilePath, wantMode, wantUID, wantGID)
+		if err != nil {
+			return deviceFailed("could not check metadata of file %s: %v", file.path, err)
+		}
+
+		if drift == "" {
+			continue
+		}
+
+		if cfg.StrictPerms {
+			return deviceFailed("metadata of file %s was not applied (%s)", file.path, drift)
+		}
+
+		log.Warnf("Metadata of file [%s] was not applied (%s), the filesystem may not support it", file.path, drift)
+	}
+
+	log.WithField("duration", time.Since(start).String()).Infof("Successfully wrote %d file(s) to device [%s]", len(files), blockDevice)
+
+	return nil
+}
+
+// loadContents reads any streamed contents of files into memory, for the transforms that need all of
+// the contents at once.
+func loadContents(files []*fileWrite) error {
+	for _, file := range files {
+		if err := file.load(); err != nil {
+			return fetchFailed("could not fetch contents of [%s]: %v", file.path, err)
+		}
+	}
+
+	return nil
+}
+
+// inheritOwner is used in place of a uid or gid that should be inherited from the parent directory.
+const inheritOwner = -1
+
+// ownerOf returns the uid and gid that own fqPath.
+func ownerOf(fqPath string) (int, int, error) {
+	info, err := os.Stat(fqPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to stat path %s: %w", fqPath, err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, fmt.Errorf("failed to read ownership of %s", fqPath)
+	}
+
+	return int(stat.Uid), int(stat.Gid), nil
+}
+
+func dirExists(mountPath, path string) (bool, error) {
+	fqPath, err := securePath(mountPath, path)
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(fqPath)
+
+	switch {
+	// Any error that does not indicate the directory doesn't exist
+	case err != nil && !os.IsNotExist(err):
+		return false, fmt.Errorf("failed to stat path %s: %w", path, err)
+	// The directory already exists
+	case err == nil:
+		if !info.IsDir() {
+			return false, fmt.Errorf("expected %s to be a path, but it is a file", path)
+		}
+	}
+
+	return !os.IsNotExist(err), nil
+}
+
+func recursiveEnsureDir(mountPath, path string, mode os.FileMode, uid, gid int, specs dirSpecs) error {
+	// Does the directory already exist? If so we can return early
+	exists, err := dirExists(mountPath, path)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	pathParts := strings.Split(path, string(os.PathSeparator))
+	if len(pathParts) == 1 && pathParts[0] == path {
+		return errors.New("bad path")
+	}
+
+	basePath := string(os.PathSeparator)
+	for _, part := range pathParts {
+		basePath = filepath.Join(basePath, part)
+		if err := ensureDir(mountPath, basePath, mode, uid, gid, specs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ensureDir(mountPath, path string, mode os.FileMode, uid, gid int, specs dirSpecs) error {
+	exists, err := dirExists(mountPath, path)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	// The directory doesn't exist, let's create it.
+	fqPath, err := securePath(mountPath, path)
+	if err != nil {
+		return err
+	}
+
+	mode, uid, gid, err = specs.apply(mountPath, path, mode, uid, gid)
+	if err != nil {
+		return err
+	}
+
+	if uid == inheritOwner || gid == inheritOwner {
+		parentUID, parentGID, err := ownerOf(filepath.Dir(fqPath))
+		if err != nil {
+			return err
+		}
+
+		if uid == inheritOwner {
+			uid = parentUID
+		}
+
+		if gid == inheritOwner {
+			gid = parentGID
+		}
+	}
+
+	if err := os.Mkdir(fqPath, mode); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", path, err)
+	}
+
+	log.Infof("Successfully created directory: %s", path)
+
+	if err := os.Chown(fqPath, uid, gid); err != nil {
+		return fmt.Errorf("failed to set ownership of directory %s to %d:%d: %w", path, uid, gid, err)
+	}
+
+	log.Infof("Successfully set ownernership of directory %s to %d:%d", path, uid, gid)
+
+	return nil
+}
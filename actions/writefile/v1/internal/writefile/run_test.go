@@ -0,0 +1,161 @@
+package writefile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/config"
+)
+
+// fakeMounter mounts nothing, the mountpoint stands in for the filesystem of the device and is
+// populated with files when it is mounted.
+type fakeMounter struct {
+	files map[string]string
+}
+
+func (f *fakeMounter) Mount(source, target, fsType string, flags uintptr, data string) error {
+	for path, contents := range f.files {
+		fqPath := filepath.Join(target, path)
+		if err := os.MkdirAll(filepath.Dir(fqPath), 0o755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(fqPath, []byte(contents), 0o644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeMounter) Unmount(target string, flags int) error {
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	padded := 8
+
+	tests := []struct {
+		name string
+		cfg  config.Config
+		// existing are the files on the device before the action runs.
+		existing map[string]string
+		// want are the contents of the files on the device after the action ran, an empty string is a
+		// file that must not exist.
+		want     map[string]string
+		wantCode int
+	}{
+		{
+			name: "contents",
+			cfg:  config.Config{DestPath: "/etc/hostname", Contents: "node-1\n", Mode: "0600"},
+			want: map[string]string{"/etc/hostname": "node-1\n"},
+		},
+		{
+			name:     "overwrite",
+			cfg:      config.Config{DestPath: "/etc/hostname", Contents: "node-2\n", Mode: "0600"},
+			existing: map[string]string{"/etc/hostname": "node-1\n"},
+			want:     map[string]string{"/etc/hostname": "node-2\n"},
+		},
+		{
+			name: "manifest",
+			cfg: config.Config{Manifest: `files:
+- path: /etc/hostname
+  contents: node-1
+- path: /etc/motd
+  contents: hello`, Mode: "0600"},
+			want: map[string]string{"/etc/hostname": "node-1", "/etc/motd": "hello"},
+		},
+		{
+			name:     "delete",
+			cfg:      config.Config{DestPath: "/etc/motd", Delete: true},
+			existing: map[string]string{"/etc/motd": "hello"},
+			want:     map[string]string{"/etc/motd": ""},
+		},
+		{
+			name: "padded",
+			cfg:  config.Config{DestPath: "/boot/env", Contents: "a=b", Mode: "0600", PadToBytes: &padded},
+			want: map[string]string{"/boot/env": "a=b\x00\x00\x00\x00\x00"},
+		},
+		{
+			name: "dry run",
+			cfg:  config.Config{DestPath: "/etc/hostname", Contents: "node-1\n", Mode: "0600", DryRun: true},
+			want: map[string]string{"/etc/hostname": ""},
+		},
+		{
+			name:     "path above the root",
+			cfg:      config.Config{DestPath: "/../escaped", Contents: "x", Mode: "0600"},
+			want:     map[string]string{"/escaped": ""},
+			wantCode: ExitCodeInvalidInput,
+		},
+		{
+			name:     "invalid mode",
+			cfg:      config.Config{DestPath: "/etc/hostname", Contents: "x", Mode: "rw-"},
+			want:     map[string]string{"/etc/hostname": ""},
+			wantCode: ExitCodeInvalidInput,
+		},
+		{
+			name:     "padding exceeded",
+			cfg:      config.Config{DestPath: "/boot/env", Contents: "a=b;c=d;e=f", Mode: "0600", PadToBytes: &padded},
+			want:     map[string]string{"/boot/env": ""},
+			wantCode: ExitCodeInvalidInput,
+		},
+		{
+			name:     "aborted",
+			cfg:      config.Config{DestPath: "/etc/hostname", Contents: "x", Mode: "0600", AbortFlagFile: "abort"},
+			want:     map[string]string{"/etc/hostname": ""},
+			wantCode: ExitCodeAborted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			defer func(mountPath string) { mountAction = mountPath }(mountAction)
+			mountAction = filepath.Join(dir, "mountAction")
+
+			disk := filepath.Join(dir, "disk")
+			if err := ioutil.WriteFile(disk, make([]byte, 64<<10), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := tt.cfg
+			cfg.DestDisk, cfg.FSType, cfg.DestRoot, cfg.DirMode = disk, "ext4", "/", "0755"
+
+			// Files are owned by whoever runs the test, so that it passes without root
+			cfg.UID, cfg.GID = strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+
+			if cfg.AbortFlagFile != "" {
+				cfg.AbortFlagFile = filepath.Join(dir, cfg.AbortFlagFile)
+				if err := ioutil.WriteFile(cfg.AbortFlagFile, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := Run(time.Now(), &cfg, &fakeMounter{files: tt.existing})
+			if code := ExitCode(err); err != nil && code != tt.wantCode || err == nil && tt.wantCode != 0 {
+				t.Fatalf("Run() error = %v (exit code %d), want exit code %d", err, code, tt.wantCode)
+			}
+
+			for path, want := range tt.want {
+				got, err := ioutil.ReadFile(filepath.Join(mountAction, path))
+				switch {
+				case want == "" && !os.IsNotExist(err):
+					t.Errorf("Run() left %s with %q, want it not to exist", path, got)
+				case want != "" && err != nil:
+					t.Errorf("Run() didn't write %s: %v", path, err)
+				case want != "" && string(got) != want:
+					t.Errorf("Run() wrote %q to %s, want %q", got, path, want)
+				}
+			}
+
+			// Nothing is ever written next to the mountpoint
+			if _, err := os.Stat(filepath.Join(dir, "escaped")); !os.IsNotExist(err) {
+				t.Errorf("Run() wrote outside of the mountpoint")
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package writefile
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"unicode"
+)
+
+// decodeStream decodes streamed contents that were supplied with the given encoding.
+func decodeStream(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "", encodingPlain:
+		return body, nil
+	case encodingBase64:
+		return decodedBody{Reader: base64.NewDecoder(base64.StdEncoding, &spaceStripper{r: body}), Closer: body}, nil
+	case encodingGzipBase64:
+		zr, err := gzip.NewReader(base64.NewDecoder(base64.StdEncoding, &spaceStripper{r: body}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress gzip contents: %w", err)
+		}
+
+		return decodedBody{Reader: zr, Closer: body}, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %s, must be one of [%s, %s, %s]",
+			encoding, encodingPlain, encodingBase64, encodingGzipBase64)
+	}
+}
+
+// decodedBody reads the decoded contents of a streamed body and closes the body itself.
+type decodedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// spaceStripper drops any whitespace from the base64 it reads, as decodeBase64 does.
+type spaceStripper struct {
+	r io.Reader
+}
+
+func (s *spaceStripper) Read(p []byte) (int, error) {
+	for {
+		n, err := s.r.Read(p)
+
+		kept := 0
+		for _, b := range p[:n] {
+			if !unicode.IsSpace(rune(b)) {
+				p[kept] = b
+				kept++
+			}
+		}
+
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// load reads streamed contents into memory, for the transforms that need all of the contents at
+// once. Contents that aren't streamed are left as they are.
+func (f *fileWrite) load() error {
+	if f.body == nil {
+		return nil
+	}
+
+	defer f.body.Close()
+
+	contents, err := ioutil.ReadAll(f.body)
+	if err != nil {
+		return err
+	}
+
+	f.contents, f.body = contents, nil
+
+	return nil
+}
+
+// writeContents writes the contents of f to w and returns the number of bytes written, streamed
+// contents are copied a buffer at a time rather than read into memory first.
+func (f *fileWrite) writeContents(w io.Writer) (int64, error) {
+	if f.body == nil {
+		n, err := w.Write(f.contents)
+		return int64(n), err
+	}
+
+	defer f.body.Close()
+
+	return io.Copy(w, f.body)
+}
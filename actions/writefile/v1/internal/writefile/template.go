@@ -0,0 +1,23 @@
+package writefile
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// renderTemplate executes contents as a Go template against the Hegel metadata of the machine.
+// Referencing a key that isn't in the metadata is an error rather than rendering "<no value>".
+func renderTemplate(name string, contents []byte, metadata map[string]interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, metadata); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+
+	return rendered.Bytes(), nil
+}
@@ -0,0 +1,72 @@
+package writefile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// verifyMetadata checks that fqPath still has the mode and ownership it was given, if anything has
+// changed them since they were set they are re-applied once. A second drift is returned as an error.
+func verifyMetadata(fqPath string, mode os.FileMode, uid, gid int) error {
+	drift, err := metadataDrift(fqPath, mode, uid, gid)
+	if err != nil {
+		return err
+	}
+
+	if drift == "" {
+		return nil
+	}
+
+	log.Warnf("Metadata of %s drifted (%s), re-applying", fqPath, drift)
+
+	if err := os.Chown(fqPath, uid, gid); err != nil {
+		return fmt.Errorf("failed to re-apply ownership of %s: %w", fqPath, err)
+	}
+
+	if err := os.Chmod(fqPath, mode); err != nil {
+		return fmt.Errorf("failed to re-apply mode of %s: %w", fqPath, err)
+	}
+
+	drift, err = metadataDrift(fqPath, mode, uid, gid)
+	if err != nil {
+		return err
+	}
+
+	if drift != "" {
+		return fmt.Errorf("metadata of %s drifted again after being re-applied (%s)", fqPath, drift)
+	}
+
+	log.Infof("Successfully re-applied metadata of %s", fqPath)
+
+	return nil
+}
+
+// metadataDrift describes how the mode and ownership of fqPath differ from the expected values, an
+// empty description means they match.
+func metadataDrift(fqPath string, mode os.FileMode, uid, gid int) (string, error) {
+	info, err := os.Stat(fqPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", fqPath, err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("failed to read ownership of %s", fqPath)
+	}
+
+	var drift []string
+
+	if info.Mode().Perm() != mode.Perm() {
+		drift = append(drift, fmt.Sprintf("mode is %04o, expected %04o", info.Mode().Perm(), mode.Perm()))
+	}
+
+	if int(stat.Uid) != uid || int(stat.Gid) != gid {
+		drift = append(drift, fmt.Sprintf("owner is %d:%d, expected %d:%d", stat.Uid, stat.Gid, uid, gid))
+	}
+
+	return strings.Join(drift, ", "), nil
+}
@@ -0,0 +1,71 @@
+package writefile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// wasmPageSize is the size in bytes of a single page of WebAssembly linear memory.
+const wasmPageSize = 64 * 1024
+
+// transformWASM runs contents through the WASI module found at modulePath. The module receives the
+// contents on stdin and whatever it writes to stdout replaces them, anything written to stderr is
+// passed through to the action's stderr. The module has no access to the filesystem or network,
+// its memory is capped at memoryLimit bytes and it is stopped if it runs for longer than timeout.
+func transformWASM(modulePath string, contents []byte, timeout time.Duration, memoryLimit uint64) ([]byte, error) {
+	module, err := ioutil.ReadFile(modulePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read module %s: %w", modulePath, err)
+	}
+
+	pages := memoryLimit / wasmPageSize
+	if pages == 0 || pages > 65536 {
+		return nil, fmt.Errorf("memory limit of %d bytes is outside of the supported range", memoryLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(pages)).
+		WithCloseOnContextDone(true)
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	defer runtime.Close(ctx)
+
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+
+	compiled, err := runtime.CompileModule(ctx, module)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile module %s: %w", modulePath, err)
+	}
+
+	var stdout bytes.Buffer
+
+	moduleConfig := wazero.NewModuleConfig().
+		WithName("transform").
+		WithArgs("transform").
+		WithStdin(bytes.NewReader(contents)).
+		WithStdout(&stdout).
+		WithStderr(os.Stderr)
+
+	if _, err := runtime.InstantiateModule(ctx, compiled, moduleConfig); err != nil {
+		var exitErr *sys.ExitError
+		if errors.As(err, &exitErr) && ctx.Err() != nil {
+			return nil, fmt.Errorf("module %s did not complete within %s", modulePath, timeout)
+		}
+
+		return nil, fmt.Errorf("failed to run module %s: %w", modulePath, err)
+	}
+
+	return stdout.Bytes(), nil
+}
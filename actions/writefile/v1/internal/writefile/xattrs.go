@@ -0,0 +1,77 @@
+package writefile
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// parseXattrs parses extended attributes given one per line in the "name=value" form printed by
+// getfattr --dump. A value prefixed with 0x is hex and one prefixed with 0s is base64, as for binary
+// attributes such as security.capability, a double quoted value may use Go escapes and any other
+// value is used as it is.
+func parseXattrs(value string) (map[string][]byte, error) {
+	xattrs := map[string][]byte{}
+
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("extended attribute %q must be in the form name=value", line)
+		}
+
+		name := strings.TrimSpace(line[:i])
+		if !strings.Contains(name, ".") {
+			return nil, fmt.Errorf("extended attribute %s must have a namespace such as user. or security.", name)
+		}
+
+		decoded, err := decodeXattrValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for extended attribute %s: %w", name, err)
+		}
+
+		xattrs[name] = decoded
+	}
+
+	return xattrs, nil
+}
+
+// decodeXattrValue decodes an extended attribute value in one of the encodings of getfattr.
+func decodeXattrValue(value string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X"):
+		return hex.DecodeString(value[2:])
+	case strings.HasPrefix(value, "0s") || strings.HasPrefix(value, "0S"):
+		return base64.StdEncoding.DecodeString(value[2:])
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := strconv.Unquote(value)
+		return []byte(unquoted), err
+	default:
+		return []byte(value), nil
+	}
+}
+
+// setXattrs sets the extended attributes on fqPath, in order of their names.
+func setXattrs(fqPath string, xattrs map[string][]byte) error {
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := syscall.Setxattr(fqPath, name, xattrs[name], 0); err != nil {
+			return fmt.Errorf("could not set extended attribute %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -1,169 +1,82 @@
 package main
 
 import (
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
-	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/config"
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/mount"
+	"github.com/tinkerbell/hub/actions/writefile/v1/internal/writefile"
 )
 
-const mountAction = "/mountAction"
-
 func main() {
-	fmt.Printf("WriteFile - Write file to disk\n------------------------\n")
-
-	blockDevice := os.Getenv("DEST_DISK")
-	filesystemType := os.Getenv("FS_TYPE")
-	filePath := os.Getenv("DEST_PATH")
-
-	contents := os.Getenv("CONTENTS")
-	uid := os.Getenv("UID")
-	gid := os.Getenv("GID")
-	mode := os.Getenv("MODE")
-	dirMode := os.Getenv("DIRMODE")
-
-	// Validate inputs
-	if blockDevice == "" {
-		log.Fatalf("No Block Device speified with Environment Variable [DEST_DISK]")
-	}
-
-	if !filepath.IsAbs(filePath) {
-		log.Fatal("Provide path must be an absolute path")
-	}
-
-	modePrime, err := strconv.ParseUint(mode, 8, 32)
-	if err != nil {
-		log.Fatalf("Could not parse mode: %v", err)
-	}
-
-	fileMode := os.FileMode(modePrime)
-
-	dirModePrime, err := strconv.ParseUint(dirMode, 8, 32)
-	if err != nil {
-		log.Fatalf("Could not parse dirmode: %v", err)
-	}
-
-	newDirMode := os.FileMode(dirModePrime)
-
-	fileUID, err := strconv.Atoi(uid)
-	if err != nil {
-		log.Fatalf("Could not parse uid: %v", err)
-	}
-
-	fileGID, err := strconv.Atoi(gid)
-	if err != nil {
-		log.Fatalf("Could not parse gid: %v", err)
-	}
-
-	dirPath, fileName := filepath.Split(filePath)
-	if len(fileName) == 0 {
-		log.Fatal("Provide path must include a file component")
-	}
-
-	// Create the /mountAction mountpoint (no folders exist previously in scratch container)
-	if err := os.Mkdir(mountAction, os.ModeDir); err != nil {
-		log.Fatalf("Error creating the action Mountpoint [%s]", mountAction)
-	}
-
-	// Mount the block device to the /mountAction point
-	if err := syscall.Mount(blockDevice, mountAction, filesystemType, 0, ""); err != nil {
-		log.Fatalf("Mounting [%s] -> [%s] error [%v]", blockDevice, mountAction, err)
-	}
-
-	log.Infof("Mounted [%s] -> [%s]", blockDevice, mountAction)
-
-	if err := recursiveEnsureDir(mountAction, dirPath, newDirMode, fileUID, fileGID); err != nil {
-		log.Fatalf("Failed to ensure directory exists: %v", err)
-	}
+	start := time.Now()
 
-	fqFilePath := filepath.Join(mountAction, filePath)
-	// Write the file to disk
-	if err := ioutil.WriteFile(fqFilePath, []byte(contents), fileMode); err != nil {
-		log.Fatalf("Could not write file %s: %v", filePath, err)
+	logFormat := os.Getenv("LOG_FORMAT")
+	if err := writefile.ConfigureLogging(os.Getenv("LOG_LEVEL"), logFormat); err != nil {
+		log.Errorf("Could not configure logging: %v", err)
+		os.Exit(writefile.ExitCodeInvalidInput)
 	}
 
-	if err := os.Chown(fqFilePath, fileUID, fileGID); err != nil {
-		log.Fatalf("Could not modify ownership of file %s: %v", filePath, err)
-	}
-
-	log.Infof("Successfully wrote file [%s] to device [%s]", filePath, blockDevice)
-}
-
-func dirExists(mountPath, path string) (bool, error) {
-	fqPath := filepath.Join(mountPath, path)
-	info, err := os.Stat(fqPath)
-
-	switch {
-	// Any error that does not indicate the directory doesn't exist
-	case err != nil && !os.IsNotExist(err):
-		return false, fmt.Errorf("failed to stat path %s: %w", path, err)
-	// The directory already exists
-	case err == nil:
-		if !info.IsDir() {
-			return false, fmt.Errorf("expected %s to be a path, but it is a file", path)
+	hostDevKey := "HOST_DEV"
+	if _, exists := os.LookupEnv(hostDevKey); exists && !inHostMountNamespace() {
+		hostDev, err := strconv.ParseBool(os.Getenv(hostDevKey))
+		if err != nil {
+			log.Errorf("Parsing failed for environment variable [%s].  %v", hostDevKey, err)
+			os.Exit(writefile.ExitCodeInvalidInput)
 		}
-	}
-
-	return !os.IsNotExist(err), nil
-}
-
-func recursiveEnsureDir(mountPath, path string, mode os.FileMode, uid, gid int) error {
-	// Does the directory already exist? If so we can return early
-	exists, err := dirExists(mountPath, path)
-	if err != nil {
-		return err
-	}
 
-	if exists {
-		return nil
+		if hostDev {
+			mntnsPIDKey := "MNTNS_PID"
+			mntnsPID := 1
+			if _, exists := os.LookupEnv(mntnsPIDKey); exists {
+				mntnsPID, err = strconv.Atoi(os.Getenv(mntnsPIDKey))
+				if err != nil {
+					log.Errorf("Parsing failed for environment variable [%s].  %v", mntnsPIDKey, err)
+					os.Exit(writefile.ExitCodeInvalidInput)
+				}
+			}
+
+			// DEST_DISK is resolved and mounted by the re-executed action in the host's mount namespace
+			code, err := reexecInMountNamespace(mntnsPID)
+			if err != nil {
+				log.Errorf("Could not enter the mount namespace of pid [%d]: %v", mntnsPID, err)
+				os.Exit(writefile.ExitCodeFailure)
+			}
+
+			os.Exit(code)
+		}
 	}
 
-	pathParts := strings.Split(path, string(os.PathSeparator))
-	if len(pathParts) == 1 && pathParts[0] == path {
-		return errors.New("bad path")
+	// The banner would break up a stream of JSON entries
+	if logFormat != writefile.LogFormatJSON {
+		fmt.Printf("WriteFile - Write file to disk\n------------------------\n")
 	}
 
-	basePath := string(os.PathSeparator)
-	for _, part := range pathParts {
-		basePath = filepath.Join(basePath, part)
-		if err := ensureDir(mountPath, basePath, mode, uid, gid); err != nil {
-			return err
-		}
+	if inHostMountNamespace() {
+		log.Infof("Running in mount namespace [%s]", os.Getenv(hostMountNamespaceEnv))
 	}
 
-	return nil
-}
-
-func ensureDir(mountPath, path string, mode os.FileMode, uid, gid int) error {
-	exists, err := dirExists(mountPath, path)
+	cfg, err := config.FromEnv(os.Environ())
 	if err != nil {
-		return err
+		log.WithField("exit_code", writefile.ExitCodeInvalidInput).Error(err)
+		os.Exit(writefile.ExitCodeInvalidInput)
 	}
 
-	if exists {
-		return nil
-	}
+	// Anything mounted or opened by Run has been cleaned up by the time it returns
+	if err := writefile.Run(start, cfg, mount.Kernel{}); err != nil {
+		code := writefile.ExitCode(err)
+		entry := log.WithField("exit_code", code)
 
-	// The directory doesn't exist, let's create it.
-	fqPath := filepath.Join(mountPath, path)
-
-	if err := os.Mkdir(fqPath, mode); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", path, err)
-	}
-
-	log.Infof("Successfully created directory: %s", path)
+		if code == writefile.ExitCodeAborted {
+			entry.Warn(err)
+		} else {
+			entry.Error(err)
+		}
 
-	if err := os.Chown(fqPath, uid, gid); err != nil {
-		return fmt.Errorf("failed to set ownership of directory %s to %d:%d: %w", path, uid, gid, err)
+		os.Exit(code)
 	}
-
-	log.Infof("Successfully set ownernership of directory %s to %d:%d", path, uid, gid)
-
-	return nil
 }